@@ -0,0 +1,275 @@
+// Command paygateway is a local stand-in for a real payment gateway. It
+// accepts payment intents, settles them asynchronously after a delay and
+// reports the result to the intent's callback URL with a signed webhook, so
+// the booking flow can be exercised against realistic payment timing.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"cinema-booking/internal/payments"
+
+	"github.com/google/uuid"
+)
+
+type intent struct {
+	payments.GatewayIntent
+	callbackURL string
+	expiresAt   time.Time
+	// refundKeys are the idempotency keys of the refunds already made.
+	refundKeys map[string]bool
+}
+
+type gateway struct {
+	secret      string
+	delay       time.Duration
+	failureRate float64
+	client      *http.Client
+
+	mu      sync.Mutex
+	intents map[string]*intent
+}
+
+func main() {
+	g := &gateway{
+		secret:      getEnv("PAYGATEWAY_SECRET", "change-me-in-production"),
+		delay:       time.Duration(getEnvFloat("PAYGATEWAY_DELAY", 3) * float64(time.Second)),
+		failureRate: getEnvFloat("PAYGATEWAY_FAILURE_RATE", 0.1),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		intents:     make(map[string]*intent),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/intents", g.auth(g.createIntent))
+	mux.HandleFunc("GET /v1/intents/{id}", g.auth(g.getIntent))
+	mux.HandleFunc("POST /v1/intents/{id}/capture", g.auth(g.captureIntent))
+	mux.HandleFunc("POST /v1/intents/{id}/refund", g.auth(g.refundIntent))
+	// Test hooks: settle a pending intent now with a chosen outcome
+	mux.HandleFunc("POST /v1/intents/{id}/succeed", g.auth(g.forceOutcome(payments.IntentSucceeded)))
+	mux.HandleFunc("POST /v1/intents/{id}/fail", g.auth(g.forceOutcome(payments.IntentFailed)))
+
+	port := getEnv("PAYGATEWAY_PORT", "8090")
+	log.Printf("Payment gateway listening on :%s (delay: %v, failure rate: %.2f)", port, g.delay, g.failureRate)
+	if err := http.ListenAndServe(":"+port, mux); err != nil {
+		log.Fatalf("Failed to start gateway: %v", err)
+	}
+}
+
+func (g *gateway) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+g.secret {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid api key"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (g *gateway) createIntent(w http.ResponseWriter, r *http.Request) {
+	var req payments.GatewayIntentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.Amount < 0 || req.Reference == "" || req.CallbackURL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reference, callbackUrl and a non-negative amount are required"})
+		return
+	}
+
+	in := &intent{
+		GatewayIntent: payments.GatewayIntent{
+			ID:        "pi_" + uuid.New().String(),
+			Reference: req.Reference,
+			Amount:    req.Amount,
+			Currency:  req.Currency,
+			Status:    payments.IntentPending,
+			CreatedAt: time.Now(),
+		},
+		callbackURL: req.CallbackURL,
+		expiresAt:   req.ExpiresAt,
+	}
+	g.mu.Lock()
+	g.intents[in.ID] = in
+	g.mu.Unlock()
+
+	time.AfterFunc(g.delay, func() {
+		outcome, reason := payments.IntentSucceeded, ""
+		if rand.Float64() < g.failureRate {
+			outcome, reason = payments.IntentFailed, "card_declined"
+		}
+		g.settle(in.ID, outcome, reason)
+	})
+
+	log.Printf("Intent %s created for %s (%.2f %s)", in.ID, in.Reference, in.Amount, in.Currency)
+	writeJSON(w, http.StatusCreated, in.GatewayIntent)
+}
+
+func (g *gateway) getIntent(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	in, ok := g.intents[r.PathValue("id")]
+	var snapshot payments.GatewayIntent
+	if ok {
+		snapshot = in.GatewayIntent
+	}
+	g.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "intent not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+func (g *gateway) captureIntent(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	in, ok := g.intents[r.PathValue("id")]
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "intent not found"})
+	case in.Status == payments.IntentCaptured:
+		writeJSON(w, http.StatusOK, in.GatewayIntent)
+	case in.Status != payments.IntentSucceeded:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "intent is " + in.Status})
+	default:
+		in.Status = payments.IntentCaptured
+		log.Printf("Intent %s captured", in.ID)
+		writeJSON(w, http.StatusOK, in.GatewayIntent)
+	}
+}
+
+func (g *gateway) refundIntent(w http.ResponseWriter, r *http.Request) {
+	var req payments.GatewayRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	in, ok := g.intents[r.PathValue("id")]
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "intent not found"})
+	case req.IdempotencyKey != "" && in.refundKeys[req.IdempotencyKey]:
+		log.Printf("Intent %s refund %s replayed", in.ID, req.IdempotencyKey)
+		writeJSON(w, http.StatusOK, in.GatewayIntent)
+	case in.Status != payments.IntentSucceeded && in.Status != payments.IntentCaptured && in.Status != payments.IntentRefunded:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "intent is " + in.Status})
+	case req.Amount <= 0 || in.Refunded+req.Amount > in.Amount+0.005:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "refund exceeds the remaining amount"})
+	default:
+		in.Refunded += req.Amount
+		if req.IdempotencyKey != "" {
+			if in.refundKeys == nil {
+				in.refundKeys = make(map[string]bool)
+			}
+			in.refundKeys[req.IdempotencyKey] = true
+		}
+		if in.Refunded >= in.Amount-0.005 {
+			in.Status = payments.IntentRefunded
+		}
+		log.Printf("Intent %s refunded %.2f", in.ID, req.Amount)
+		writeJSON(w, http.StatusOK, in.GatewayIntent)
+	}
+}
+
+func (g *gateway) forceOutcome(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !g.settle(id, status, "forced") {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "intent not found or already settled"})
+			return
+		}
+		g.getIntent(w, r)
+	}
+}
+
+// settle moves a PENDING intent to status and sends the webhook. It
+// reports false when the intent does not exist or was already settled.
+func (g *gateway) settle(id, status, reason string) bool {
+	g.mu.Lock()
+	in, ok := g.intents[id]
+	if !ok || in.Status != payments.IntentPending {
+		g.mu.Unlock()
+		return false
+	}
+	if !in.expiresAt.IsZero() && time.Now().After(in.expiresAt) {
+		status, reason = payments.IntentFailed, "expired"
+	}
+	in.Status = status
+	eventType := "intent.succeeded"
+	if status == payments.IntentFailed {
+		in.FailureReason = reason
+		eventType = "intent.failed"
+	}
+	event := payments.GatewayEvent{
+		ID:        "evt_" + uuid.New().String(),
+		Type:      eventType,
+		Intent:    in.GatewayIntent,
+		CreatedAt: time.Now(),
+	}
+	callbackURL := in.callbackURL
+	g.mu.Unlock()
+
+	log.Printf("Intent %s settled: %s", id, status)
+	go g.deliver(callbackURL, event)
+	return true
+}
+
+// deliver POSTs a signed webhook, retrying with exponential backoff until
+// the receiver answers 2xx.
+func (g *gateway) deliver(url string, event payments.GatewayEvent) {
+	body, _ := json.Marshal(event)
+	backoff := time.Second
+	for attempt := 1; attempt <= 8; attempt++ {
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(payments.SignatureHeader, payments.Sign(g.secret, time.Now(), body))
+
+		resp, err := g.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				log.Printf("Webhook %s delivered (attempt %d)", event.ID, attempt)
+				return
+			}
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		log.Printf("Webhook %s attempt %d failed: %v", event.ID, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	log.Printf("Webhook %s abandoned", event.ID)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return fallback
+}
@@ -5,14 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"time"
+	_ "time/tzdata"
 
+	"cinema-booking/internal/authz"
 	"cinema-booking/internal/config"
 	"cinema-booking/internal/handlers"
+	"cinema-booking/internal/invoice"
 	"cinema-booking/internal/middleware"
 	"cinema-booking/internal/models"
 	"cinema-booking/internal/mq"
+	"cinema-booking/internal/payments"
 	"cinema-booking/internal/services"
 	"cinema-booking/internal/worker"
 	wsHub "cinema-booking/internal/ws"
@@ -21,6 +24,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func main() {
@@ -34,6 +39,13 @@ func main() {
 	var mqSvc *mq.MQService
 	mqSvc = mq.NewMQService(cfg.RabbitMQURL)
 
+	// Amounts stored in baht must be in satang before any payment is read
+	if n, err := mongoSvc.MigrateMinorUnits(context.Background()); err != nil {
+		log.Fatalf("Failed to migrate amounts to satang: %v", err)
+	} else if n > 0 {
+		log.Printf("Migrated amounts of %d documents to satang", n)
+	}
+
 	// Seed data
 	handlers.SeedData(mongoSvc)
 
@@ -41,11 +53,43 @@ func main() {
 	hub := wsHub.NewHub()
 	go hub.Run()
 
-	// Start timeout worker
+	// Timeout worker and lock reconciler (started once the waitlist hook is
+	// wired)
 	lockTTL := time.Duration(cfg.SeatLockTTL) * time.Second
+	lockMaxHold := time.Duration(cfg.LockMaxHold) * time.Second
 	workerInterval := time.Duration(cfg.WorkerInterval) * time.Second
+	idempotencyTTL := time.Duration(cfg.IdempotencyTTL) * time.Second
+	priceLocation, err := time.LoadLocation(cfg.PricingTimezone)
+	if err != nil {
+		log.Fatalf("Invalid PRICING_TIMEZONE: %v", err)
+	}
+	var paymentProvider payments.Provider
+	switch cfg.PaymentProvider {
+	case "mock":
+		paymentProvider = payments.MockProvider{}
+	case "gateway":
+		paymentProvider = payments.NewGatewayProvider(cfg.PayGatewayURL, cfg.PayGatewaySecret, cfg.PaymentWebhookURL)
+	default:
+		log.Fatalf("Invalid PAYMENT_PROVIDER: %q (want mock or gateway)", cfg.PaymentProvider)
+	}
+	log.Printf("Payment provider: %s", paymentProvider.Name())
+	var promptPay *payments.PromptPayProvider
+	if cfg.PromptPayID != "" {
+		promptPay, err = payments.NewPromptPayProvider(cfg.PromptPayID, cfg.PromptPaySecret)
+		if err != nil {
+			log.Fatalf("Invalid PROMPTPAY_ID: %v", err)
+		}
+		log.Printf("PromptPay enabled")
+	}
 	tw := worker.NewTimeoutWorker(mongoSvc, redisSvc, hub, workerInterval)
-	tw.Start()
+	reconciler := &worker.LockReconciler{
+		Mongo:    mongoSvc,
+		Redis:    redisSvc,
+		Hub:      hub,
+		Interval: time.Duration(cfg.ReconcileInterval) * time.Second,
+		Grace:    time.Duration(cfg.ReconcileGrace) * time.Second,
+		LockTTL:  lockTTL,
+	}
 	emailSvc := services.NewEmailService(
 		cfg.SMTPHost,
 		cfg.SMTPPort,
@@ -54,54 +98,91 @@ func main() {
 		cfg.SMTPFrom,
 	)
 
-	// MQ Consumer - writes audit logs on BookingConfirmed
+	// MQ Consumer - writes audit logs on BookingConfirmed and BookingRefunded
+	// and sends the confirmation email. Events are delivered at least once:
+	// the audit log is written once per event ID however often it arrives,
+	// and the event is marked processed only after the email has gone out,
+	// so a failed send is retried through redelivery.
 	if mqSvc.IsConnected() {
-		mqSvc.Consume(func(event mq.BookingEvent) {
-
-			if event.EventType == "BookingConfirmed" {
-				bookingOID, _ := primitive.ObjectIDFromHex(event.BookingID)
-				userOID, _ := primitive.ObjectIDFromHex(event.UserID)
-				showtimeOID, _ := primitive.ObjectIDFromHex(event.ShowtimeID)
-
-				auditLog := models.AuditLog{
-					ID:         primitive.NewObjectID(),
-					EventType:  "BOOKING_SUCCESS",
-					UserID:     &userOID,
-					ShowtimeID: &showtimeOID,
-					BookingID:  &bookingOID,
-					Payload: map[string]interface{}{
-						"seats":    event.Seats,
-						"event_id": event.EventID,
-					},
-					CreatedAt: time.Now(),
-				}
-				mongoSvc.Collection("audit_logs").InsertOne(context.Background(), auditLog)
-
-				if event.UserEmail != "" {
-					go func(e mq.BookingEvent) {
-						err := emailSvc.SendBookingConfirmation(services.BookingConfirmationData{
-							UserName:   e.UserName,
-							UserEmail:  e.UserEmail,
-							BookingID:  e.BookingID,
-							Seats:      e.Seats,
-							ShowtimeID: e.ShowtimeID,
-							OccurredAt: e.OccurredAt,
-						})
-						if err != nil {
-							log.Printf("Email send error: %v", err)
-						}
-					}(event)
+		mqSvc.Consume(func(event mq.BookingEvent) error {
+			ctx := context.Background()
+			done, err := mongoSvc.EventProcessed(ctx, event.EventID)
+			if err != nil {
+				return err
+			}
+			if done {
+				log.Printf("MQ: skipping duplicate event %s", event.EventID)
+				return nil
+			}
+
+			var eventType string
+			switch event.EventType {
+			case "BookingRefunded":
+				eventType = "BOOKING_REFUNDED"
+			case "BookingConfirmed":
+				eventType = "BOOKING_SUCCESS"
+			default:
+				return nil
+			}
+
+			bookingOID, _ := primitive.ObjectIDFromHex(event.BookingID)
+			userOID, _ := primitive.ObjectIDFromHex(event.UserID)
+			showtimeOID, _ := primitive.ObjectIDFromHex(event.ShowtimeID)
+
+			auditLog := models.AuditLog{
+				ID:         primitive.NewObjectID(),
+				EventType:  eventType,
+				UserID:     &userOID,
+				ShowtimeID: &showtimeOID,
+				BookingID:  &bookingOID,
+				Payload: map[string]interface{}{
+					"seats": event.Seats,
+				},
+				EventID:   event.EventID,
+				CreatedAt: time.Now(),
+			}
+			_, err = mongoSvc.Collection("audit_logs").UpdateOne(ctx,
+				bson.M{"event_id": event.EventID},
+				bson.M{"$setOnInsert": auditLog},
+				options.Update().SetUpsert(true),
+			)
+			if err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+
+			if event.EventType == "BookingConfirmed" && event.UserEmail != "" {
+				err := emailSvc.SendBookingConfirmation(services.BookingConfirmationData{
+					UserName:      event.UserName,
+					UserEmail:     event.UserEmail,
+					BookingID:     event.BookingID,
+					Seats:         event.Seats,
+					ShowtimeID:    event.ShowtimeID,
+					OccurredAt:    event.OccurredAt,
+					InvoiceNumber: event.InvoiceNumber,
+					Amount:        event.Amount,
+				})
+				if err != nil {
+					return err
 				}
 			}
+			return mongoSvc.MarkEventProcessed(ctx, event.EventID)
 		})
 	}
 
+	// Outbox relay - publishes events written by the booking handlers
+	outboxRelay := &worker.OutboxRelay{
+		Mongo:    mongoSvc,
+		MQ:       mqSvc,
+		Interval: time.Duration(cfg.OutboxInterval) * time.Second,
+	}
+	outboxRelay.Start()
+
 	// Setup Gin router
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middleware.IdempotencyHeader},
 		AllowCredentials: true,
 	}))
 
@@ -110,49 +191,62 @@ func main() {
 	log.Printf("Firebase Auth initialized for project: %s", cfg.FirebaseProjectID)
 
 	// Handlers
+	authorizer := &authz.Authorizer{Mongo: mongoSvc}
 	authHandler := &handlers.AuthHandler{Mongo: mongoSvc, JWTSecret: cfg.JWTSecret, Firebase: firebaseAuth}
 	movieHandler := &handlers.MovieHandler{Mongo: mongoSvc}
 	showtimeHandler := &handlers.ShowtimeHandler{Mongo: mongoSvc}
 	bookingHandler := &handlers.BookingHandler{
-		Mongo:   mongoSvc,
-		Redis:   redisSvc,
-		Hub:     hub,
-		MQ:      mqSvc,
-		LockTTL: lockTTL,
+		Mongo:              mongoSvc,
+		Redis:              redisSvc,
+		Hub:                hub,
+		LockTTL:            lockTTL,
+		LockMaxExtensions:  cfg.LockMaxExtensions,
+		LockMaxHold:        lockMaxHold,
+		GapRuleDefault:     cfg.SeatGapRule,
+		RefundCutoff:       time.Duration(cfg.RefundCutoffHours) * time.Hour,
+		PriceLocation:      priceLocation,
+		Payments:           paymentProvider,
+		PromptPay:          promptPay,
+		PaymentMaxAttempts: cfg.PaymentMaxAttempts,
+		Seller: models.InvoiceParty{
+			Name:    cfg.InvoiceSellerName,
+			TaxID:   cfg.InvoiceSellerTaxID,
+			Branch:  cfg.InvoiceSellerBranch,
+			Address: cfg.InvoiceSellerAddress,
+		},
+		VATRate:  cfg.VATRate,
+		Invoices: &invoice.Renderer{FontPath: cfg.InvoiceFontPath, Location: priceLocation},
+		Limits: models.PurchaseLimits{
+			MaxSeatsPerBooking:  cfg.MaxSeatsPerBooking,
+			MaxLockedBookings:   cfg.MaxLockedBookings,
+			MaxSeatsPerShowtime: cfg.MaxSeatsPerShowtime,
+		},
+		Authz: authorizer,
 	}
-	adminHandler := &handlers.AdminHandler{Mongo: mongoSvc}
-
-	// Public routes
-	r.POST("/api/auth/login", authHandler.Login)
-	r.POST("/api/auth/google", authHandler.GoogleLogin)
-
-	// Auth protected routes
-	auth := r.Group("/api")
-	auth.Use(middleware.AuthMiddleware(cfg.JWTSecret))
-	{
-		auth.GET("/movies", movieHandler.ListMovies)
-		auth.GET("/movies/:id", movieHandler.GetMovie)
-		auth.GET("/showtimes", showtimeHandler.ListShowtimes)
-		auth.GET("/showtimes/:id/seats", showtimeHandler.GetSeats)
-
-		auth.POST("/showtimes/:id/seats/lock", bookingHandler.LockSeats)
-		auth.POST("/bookings/:id/pay", bookingHandler.MockPayment)
-		auth.POST("/bookings/:id/confirm", bookingHandler.ConfirmBooking)
-		auth.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
-		auth.GET("/bookings/:id", bookingHandler.GetBooking)
+	paymentHandler := &handlers.PaymentHandler{Mongo: mongoSvc, Provider: paymentProvider, Bookings: bookingHandler}
+	adminHandler := &handlers.AdminHandler{Mongo: mongoSvc, Hub: hub, Reconciler: reconciler}
+	waitlistHandler := &handlers.WaitlistHandler{
+		Mongo:    mongoSvc,
+		Email:    emailSvc,
+		Bookings: bookingHandler,
+		OfferTTL: time.Duration(cfg.WaitlistOfferTTL) * time.Second,
 	}
+	transferHandler := &handlers.TransferHandler{Mongo: mongoSvc, Email: emailSvc, Authz: authorizer}
+	bookingHandler.OnSeatsReleased = waitlistHandler.OfferReleasedSeats
+	adminHandler.OnSeatsReleased = waitlistHandler.OfferReleasedSeats
+	tw.OnSeatsReleased = waitlistHandler.OfferReleasedSeats
+	tw.VoidPayments = bookingHandler.VoidAbandonedPayments
+	reconciler.OnSeatsReleased = waitlistHandler.OfferReleasedSeats
+	tw.Start()
+	reconciler.Start()
 
-	// Admin routes
-	admin := r.Group("/api/admin")
-	admin.Use(middleware.AuthMiddleware(cfg.JWTSecret))
-	admin.Use(middleware.AdminMiddleware())
-	{
-		admin.GET("/bookings", adminHandler.ListBookings)
-		admin.GET("/audit-logs", adminHandler.ListAuditLogs)
+	var promptPayHandler *handlers.PaymentHandler
+	if promptPay != nil {
+		promptPayHandler = &handlers.PaymentHandler{Mongo: mongoSvc, Provider: promptPay, Bookings: bookingHandler}
 	}
 
 	// WebSocket route
-	r.GET("/ws/showtimes/:showtimeId", func(c *gin.Context) {
+	serveSeats := func(c *gin.Context) {
 		showtimeId := c.Param("showtimeId")
 
 		// Upgrade connection
@@ -201,12 +295,23 @@ func main() {
 		}()
 
 		client.ReadPump()
-	})
+	}
 
-	// Health check
-	r.GET("/api/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+	routes{
+		Auth:         authHandler,
+		Movies:       movieHandler,
+		Showtimes:    showtimeHandler,
+		Bookings:     bookingHandler,
+		Payments:     paymentHandler,
+		PromptPay:    promptPayHandler,
+		Admin:        adminHandler,
+		Waitlist:     waitlistHandler,
+		Transfers:    transferHandler,
+		SeatUpdates:  serveSeats,
+		Authenticate: middleware.AuthMiddleware(cfg.JWTSecret),
+		RequireAdmin: middleware.AdminMiddleware(authorizer),
+		Idempotent:   middleware.IdempotencyMiddleware(redisSvc, idempotencyTTL),
+	}.register(r)
 
 	port := cfg.BackendPort
 	log.Printf("Server starting on port %s", port)
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+
+	"cinema-booking/internal/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routes wires the handlers and middleware that make up the HTTP API.
+// PromptPay is nil when PromptPay is not configured.
+type routes struct {
+	Auth      *handlers.AuthHandler
+	Movies    *handlers.MovieHandler
+	Showtimes *handlers.ShowtimeHandler
+	Bookings  *handlers.BookingHandler
+	Payments  *handlers.PaymentHandler
+	PromptPay *handlers.PaymentHandler
+	Admin     *handlers.AdminHandler
+	Waitlist  *handlers.WaitlistHandler
+	Transfers *handlers.TransferHandler
+
+	SeatUpdates  gin.HandlerFunc
+	Authenticate gin.HandlerFunc
+	RequireAdmin gin.HandlerFunc
+	Idempotent   gin.HandlerFunc
+}
+
+func (rt routes) register(r *gin.Engine) {
+	// Public routes
+	r.POST("/api/auth/login", rt.Auth.Login)
+	r.POST("/api/auth/google", rt.Auth.GoogleLogin)
+	r.POST("/api/payments/webhook", rt.Payments.Webhook)
+	if rt.PromptPay != nil {
+		r.POST("/api/payments/promptpay/callback", rt.PromptPay.Webhook)
+	}
+
+	// Auth protected routes
+	auth := r.Group("/api")
+	auth.Use(rt.Authenticate)
+	{
+		auth.GET("/movies", rt.Movies.ListMovies)
+		auth.GET("/movies/:id", rt.Movies.GetMovie)
+		auth.GET("/showtimes", rt.Showtimes.ListShowtimes)
+		auth.GET("/showtimes/:id/seats", rt.Showtimes.GetSeats)
+		auth.POST("/showtimes/:id/waitlist", rt.Waitlist.JoinWaitlist)
+		auth.GET("/showtimes/:id/waitlist", rt.Waitlist.GetWaitlistStatus)
+		auth.DELETE("/showtimes/:id/waitlist", rt.Waitlist.LeaveWaitlist)
+
+		idempotent := rt.Idempotent
+		auth.POST("/showtimes/:id/seats/lock", idempotent, rt.Bookings.LockSeats)
+		auth.POST("/showtimes/:id/seats/auto-lock", idempotent, rt.Bookings.AutoLockSeats)
+		auth.POST("/bookings/:id/pay", idempotent, rt.Bookings.Pay)
+		auth.GET("/bookings/:id/payment", rt.Bookings.GetPayment)
+		auth.GET("/bookings/:id/payments", rt.Bookings.ListPayments)
+		auth.GET("/bookings/:id/invoice", rt.Bookings.GetInvoice)
+		auth.POST("/bookings/:id/invoice/full", rt.Bookings.RequestTaxInvoice)
+		auth.GET("/bookings/:id/payment/qr", rt.Bookings.GetPaymentQR)
+		auth.POST("/bookings/:id/confirm", idempotent, rt.Bookings.ConfirmBooking)
+		auth.POST("/bookings/:id/cancel", rt.Bookings.CancelBooking)
+		auth.POST("/bookings/:id/extend", rt.Bookings.ExtendLock)
+		auth.PATCH("/bookings/:id/seats", rt.Bookings.ModifySeats)
+		auth.POST("/bookings/:id/promo", rt.Bookings.ApplyPromo)
+		auth.DELETE("/bookings/:id/promo", rt.Bookings.RemovePromo)
+		auth.POST("/bookings/:id/refund", idempotent, rt.Bookings.RefundBooking)
+		auth.POST("/bookings/:id/exchange", idempotent, rt.Bookings.ExchangeSeats)
+		auth.GET("/bookings/:id", rt.Bookings.GetBooking)
+		auth.GET("/me/bookings", rt.Bookings.ListMyBookings)
+		auth.POST("/bookings/:id/transfers", rt.Transfers.CreateTransfer)
+		auth.GET("/transfers", rt.Transfers.ListTransfers)
+		auth.POST("/transfers/:id/accept", rt.Transfers.AcceptTransfer)
+		auth.POST("/transfers/:id/cancel", rt.Transfers.CancelTransfer)
+	}
+
+	// Admin routes
+	admin := r.Group("/api/admin")
+	admin.Use(rt.Authenticate)
+	admin.Use(rt.RequireAdmin)
+	{
+		admin.GET("/bookings", rt.Admin.ListBookings)
+		admin.GET("/audit-logs", rt.Admin.ListAuditLogs)
+		admin.PUT("/showtimes/:id/gap-rule", rt.Admin.SetGapRule)
+		admin.PUT("/showtimes/:id/limits", rt.Admin.SetPurchaseLimits)
+		admin.GET("/showtimes/:id/seats", rt.Admin.GetSeats)
+		admin.POST("/showtimes/:id/seats/block", rt.Admin.BlockSeats)
+		admin.POST("/showtimes/:id/seats/unblock", rt.Admin.UnblockSeats)
+		admin.GET("/showtimes/:id/seats/:seatCode/history", rt.Admin.GetSeatHistory)
+		admin.POST("/seats/block", rt.Admin.BlockSeatsRange)
+		admin.POST("/seats/unblock", rt.Admin.UnblockSeatsRange)
+		admin.GET("/price-lists", rt.Admin.ListPriceLists)
+		admin.POST("/price-lists", rt.Admin.CreatePriceList)
+		admin.PUT("/price-lists/:id", rt.Admin.UpdatePriceList)
+		admin.DELETE("/price-lists/:id", rt.Admin.DeletePriceList)
+		admin.PUT("/movies/:id/surcharges", rt.Admin.SetMovieSurcharges)
+		admin.GET("/promotions", rt.Admin.ListPromotions)
+		admin.POST("/promotions", rt.Admin.CreatePromotion)
+		admin.PUT("/promotions/:id", rt.Admin.UpdatePromotion)
+		admin.GET("/refunds", rt.Admin.ListRefunds)
+		admin.POST("/refunds/:id/confirm", rt.Admin.ConfirmRefund)
+		admin.POST("/reconcile", rt.Admin.Reconcile)
+		admin.GET("/ledger/balances", rt.Admin.GetLedgerBalances)
+		admin.GET("/ledger/entries", rt.Admin.ListLedgerEntries)
+		admin.POST("/ledger/entries", rt.Admin.PostLedgerEntry)
+		admin.GET("/ledger/check", rt.Admin.CheckLedger)
+		admin.POST("/ledger/backfill", rt.Admin.BackfillLedger)
+		admin.GET("/metrics", gin.WrapH(expvar.Handler()))
+	}
+
+	// WebSocket route
+	r.GET("/ws/showtimes/:showtimeId", rt.SeatUpdates)
+
+	// Health check
+	r.GET("/api/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+}
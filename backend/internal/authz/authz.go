@@ -0,0 +1,157 @@
+// Package authz decides whether a subject may perform an action on a
+// resource. Handlers load the resource, describe it with a Resource and ask
+// the Authorizer; the policy itself lives in one table so ownership and role
+// rules are not scattered across queries.
+package authz
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+type Action string
+
+const (
+	ActionReadBooking    Action = "booking:read"
+	ActionPayBooking     Action = "booking:pay"
+	ActionConfirmBooking Action = "booking:confirm"
+	ActionCancelBooking  Action = "booking:cancel"
+	ActionExtendBooking  Action = "booking:extend"
+	ActionModifySeats    Action = "booking:modify-seats"
+	ActionRefundBooking  Action = "booking:refund"
+	ActionExchangeSeats  Action = "booking:exchange"
+	ActionTransferSeats  Action = "booking:transfer"
+	ActionApplyPromo     Action = "booking:promo"
+	ActionRequestInvoice Action = "booking:invoice"
+
+	ActionAcceptTransfer Action = "transfer:accept"
+	ActionCancelTransfer Action = "transfer:cancel"
+
+	// ActionAdminister covers every /api/admin route.
+	ActionAdminister Action = "admin:*"
+)
+
+const (
+	KindBooking  = "booking"
+	KindTransfer = "transfer"
+	KindSystem   = "system"
+)
+
+type Subject struct {
+	UserID primitive.ObjectID
+	Role   string
+}
+
+// Resource describes the object being acted on. OwnerID is the user who
+// owns it; RecipientID is set for resources handed from one user to another
+// (transfers).
+type Resource struct {
+	Kind        string
+	ID          primitive.ObjectID
+	OwnerID     primitive.ObjectID
+	RecipientID primitive.ObjectID
+}
+
+// System is the resource for actions that are not tied to one document.
+var System = Resource{Kind: KindSystem}
+
+type rule struct {
+	owner     bool
+	recipient bool
+	roles     []string
+}
+
+// policy is deny-by-default: an action missing from the table is refused.
+var policy = map[Action]rule{
+	ActionReadBooking:    {owner: true, roles: []string{models.RoleAdmin, models.RoleStaff}},
+	ActionPayBooking:     {owner: true},
+	ActionConfirmBooking: {owner: true},
+	ActionCancelBooking:  {owner: true, roles: []string{models.RoleAdmin}},
+	ActionExtendBooking:  {owner: true},
+	ActionModifySeats:    {owner: true},
+	ActionRefundBooking:  {owner: true, roles: []string{models.RoleAdmin}},
+	ActionExchangeSeats:  {owner: true},
+	ActionTransferSeats:  {owner: true},
+	ActionApplyPromo:     {owner: true},
+	ActionRequestInvoice: {owner: true, roles: []string{models.RoleAdmin}},
+
+	ActionAcceptTransfer: {recipient: true},
+	ActionCancelTransfer: {owner: true, recipient: true},
+
+	ActionAdminister: {roles: []string{models.RoleAdmin}},
+}
+
+// Allowed reports whether sub may perform action on res.
+func Allowed(sub Subject, action Action, res Resource) bool {
+	r, ok := policy[action]
+	if !ok || sub.UserID.IsZero() {
+		return false
+	}
+	if r.owner && !res.OwnerID.IsZero() && res.OwnerID == sub.UserID {
+		return true
+	}
+	if r.recipient && !res.RecipientID.IsZero() && res.RecipientID == sub.UserID {
+		return true
+	}
+	for _, role := range r.roles {
+		if sub.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+type Authorizer struct {
+	Mongo *services.MongoService
+}
+
+// Authorize returns ErrForbidden when sub may not perform action on res and
+// records the attempt as an ACCESS_DENIED audit log.
+func (a *Authorizer) Authorize(ctx context.Context, sub Subject, action Action, res Resource) error {
+	if Allowed(sub, action, res) {
+		return nil
+	}
+
+	auditLog := models.AuditLog{
+		ID:        primitive.NewObjectID(),
+		EventType: "ACCESS_DENIED",
+		Payload: map[string]interface{}{
+			"action":        string(action),
+			"resource_kind": res.Kind,
+			"role":          sub.Role,
+		},
+		CreatedAt: time.Now(),
+	}
+	if !sub.UserID.IsZero() {
+		auditLog.UserID = &sub.UserID
+	}
+	if !res.ID.IsZero() {
+		auditLog.Payload["resource_id"] = res.ID.Hex()
+		if res.Kind == KindBooking {
+			auditLog.BookingID = &res.ID
+		}
+	}
+	if _, err := a.Mongo.Collection("audit_logs").InsertOne(ctx, auditLog); err != nil {
+		log.Printf("authz: failed to write ACCESS_DENIED audit log: %v", err)
+	}
+	return ErrForbidden
+}
+
+// Booking describes a booking as a Resource.
+func Booking(b models.Booking) Resource {
+	return Resource{Kind: KindBooking, ID: b.ID, OwnerID: b.UserID}
+}
+
+// Transfer describes a transfer as a Resource owned by its sender.
+func Transfer(t models.Transfer) Resource {
+	return Resource{Kind: KindTransfer, ID: t.ID, OwnerID: t.FromUserID, RecipientID: t.ToUserID}
+}
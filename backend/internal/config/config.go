@@ -16,7 +16,43 @@ type Config struct {
 	FirebaseProjectID string
 	BackendPort       string
 	SeatLockTTL       int
+	LockMaxExtensions int
+	LockMaxHold       int
+	SeatGapRule       bool
 	WorkerInterval    int
+	IdempotencyTTL    int
+	WaitlistOfferTTL  int
+	RefundCutoffHours int
+	PricingTimezone   string
+
+	// Lock reconciler
+	ReconcileInterval int
+	ReconcileGrace    int
+
+	// Outbox relay
+	OutboxInterval int
+
+	// Payments
+	PaymentProvider    string
+	PayGatewayURL      string
+	PayGatewaySecret   string
+	PaymentWebhookURL  string
+	PromptPayID        string
+	PromptPaySecret    string
+	PaymentMaxAttempts int
+
+	// Tax invoices
+	VATRate              int
+	InvoiceSellerName    string
+	InvoiceSellerTaxID   string
+	InvoiceSellerBranch  string
+	InvoiceSellerAddress string
+	InvoiceFontPath      string
+
+	// Purchase limits
+	MaxSeatsPerBooking  int
+	MaxLockedBookings   int
+	MaxSeatsPerShowtime int
 
 	// Email (SMTP)
 	SMTPHost     string
@@ -28,7 +64,7 @@ type Config struct {
 
 func Load() *Config {
 	return &Config{
-		MongoURI:          getEnv("MONGO_URI", "mongodb://mongo:27017/cinema"),
+		MongoURI:          getEnv("MONGO_URI", "mongodb://mongo:27017/cinema?replicaSet=rs0"),
 		MongoDB:           getEnv("MONGO_DB", "cinema"),
 		RedisAddr:         getEnv("REDIS_ADDR", "redis:6379"),
 		RedisPassword:     getEnv("REDIS_PASSWORD", ""),
@@ -38,7 +74,43 @@ func Load() *Config {
 		FirebaseProjectID: getEnv("FIREBASE_PROJECT_ID", "cinema-25e75"),
 		BackendPort:       getEnv("BACKEND_PORT", "8080"),
 		SeatLockTTL:       getEnvInt("SEAT_LOCK_TTL", 300),
+		LockMaxExtensions: getEnvInt("LOCK_MAX_EXTENSIONS", 2),
+		LockMaxHold:       getEnvInt("LOCK_MAX_HOLD", 900),
+		SeatGapRule:       getEnvBool("SEAT_GAP_RULE", false),
 		WorkerInterval:    getEnvInt("WORKER_INTERVAL", 5),
+		IdempotencyTTL:    getEnvInt("IDEMPOTENCY_TTL", 86400),
+		WaitlistOfferTTL:  getEnvInt("WAITLIST_OFFER_TTL", 600),
+		RefundCutoffHours: getEnvInt("REFUND_CUTOFF_HOURS", 2),
+		PricingTimezone:   getEnv("PRICING_TIMEZONE", "Asia/Bangkok"),
+
+		// Lock reconciler
+		ReconcileInterval: getEnvInt("RECONCILE_INTERVAL", 60),
+		ReconcileGrace:    getEnvInt("RECONCILE_GRACE", 30),
+
+		// Outbox relay
+		OutboxInterval: getEnvInt("OUTBOX_INTERVAL", 2),
+
+		// Payments
+		PaymentProvider:    getEnv("PAYMENT_PROVIDER", "mock"),
+		PayGatewayURL:      getEnv("PAYGATEWAY_URL", "http://paygateway:8090"),
+		PayGatewaySecret:   getEnv("PAYGATEWAY_SECRET", "change-me-in-production"),
+		PaymentWebhookURL:  getEnv("PAYMENT_WEBHOOK_URL", "http://backend:8080/api/payments/webhook"),
+		PromptPayID:        getEnv("PROMPTPAY_ID", ""),
+		PromptPaySecret:    getEnv("PROMPTPAY_CALLBACK_SECRET", "change-me-in-production"),
+		PaymentMaxAttempts: getEnvInt("PAYMENT_MAX_ATTEMPTS", 5),
+
+		// Tax invoices
+		VATRate:              getEnvInt("VAT_RATE", 7),
+		InvoiceSellerName:    getEnv("INVOICE_SELLER_NAME", "Cinema Booking Co., Ltd."),
+		InvoiceSellerTaxID:   getEnv("INVOICE_SELLER_TAX_ID", ""),
+		InvoiceSellerBranch:  getEnv("INVOICE_SELLER_BRANCH", "00000"),
+		InvoiceSellerAddress: getEnv("INVOICE_SELLER_ADDRESS", ""),
+		InvoiceFontPath:      getEnv("INVOICE_FONT_PATH", ""),
+
+		// Purchase limits
+		MaxSeatsPerBooking:  getEnvInt("MAX_SEATS_PER_BOOKING", 10),
+		MaxLockedBookings:   getEnvInt("MAX_LOCKED_BOOKINGS_PER_USER", 2),
+		MaxSeatsPerShowtime: getEnvInt("MAX_SEATS_PER_USER_PER_SHOWTIME", 10),
 
 		// Email config
 		SMTPHost:     getEnv("SMTP_HOST", ""),
@@ -64,3 +136,12 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvBool(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
@@ -7,6 +7,8 @@ import (
 
 	"cinema-booking/internal/models"
 	"cinema-booking/internal/services"
+	"cinema-booking/internal/worker"
+	wsHub "cinema-booking/internal/ws"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,6 +18,12 @@ import (
 
 type AdminHandler struct {
 	Mongo *services.MongoService
+	Hub   *wsHub.Hub
+
+	// OnSeatsReleased is called after unblocked seats go back to AVAILABLE.
+	OnSeatsReleased func(showtimeID primitive.ObjectID)
+
+	Reconciler *worker.LockReconciler
 }
 
 func (h *AdminHandler) ListBookings(c *gin.Context) {
@@ -103,3 +111,128 @@ func (h *AdminHandler) ListAuditLogs(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, logs)
 }
+
+type GapRuleRequest struct {
+	// Enabled switches the single-seat gap rule for one showtime. A null
+	// value removes the override so the server default applies again.
+	Enabled *bool `json:"enabled"`
+}
+
+func (h *AdminHandler) SetGapRule(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	var req GapRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"gap_rule": ""}}
+	if req.Enabled != nil {
+		update = bson.M{"$set": bson.M{"gap_rule": *req.Enabled}}
+	}
+
+	result, err := h.Mongo.Collection("showtimes").UpdateOne(context.Background(), bson.M{"_id": showtimeID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update showtime"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"showtimeId": showtimeID.Hex(), "gapRule": req.Enabled})
+}
+
+func (h *AdminHandler) SetPurchaseLimits(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	var req models.PurchaseLimits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxSeatsPerBooking < 0 || req.MaxLockedBookings < 0 || req.MaxSeatsPerShowtime < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limits must not be negative"})
+		return
+	}
+
+	// An all-zero body removes the override
+	update := bson.M{"$set": bson.M{"limits": req}}
+	if req == (models.PurchaseLimits{}) {
+		update = bson.M{"$unset": bson.M{"limits": ""}}
+	}
+
+	result, err := h.Mongo.Collection("showtimes").UpdateOne(context.Background(), bson.M{"_id": showtimeID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update showtime"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"showtimeId": showtimeID.Hex(), "limits": req})
+}
+
+// GetSeatHistory replays every recorded transition of one seat in one
+// showtime, oldest first, together with its current state.
+func (h *AdminHandler) GetSeatHistory(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+	seatCode := c.Param("seatCode")
+
+	ctx := context.Background()
+	var current models.SeatReservation
+	err = h.Mongo.Collection("seat_reservations").FindOne(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"seat_code":   seatCode,
+	}).Decode(&current)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "seat not found"})
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := h.Mongo.Collection("seat_history").Find(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"seat_code":   seatCode,
+	}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch seat history"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	history := []models.SeatHistory{}
+	if err := cursor.All(ctx, &history); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"current": current, "history": history})
+}
+
+// Reconcile runs a Redis/Mongo lock reconciliation pass immediately and
+// returns what it repaired.
+func (h *AdminHandler) Reconcile(c *gin.Context) {
+	report, err := h.Reconciler.Run(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "reconciliation failed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"cinema-booking/internal/authz"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// subject builds the authz subject from the claims set by AuthMiddleware.
+func subject(c *gin.Context) authz.Subject {
+	userIdStr, _ := c.Get("user_id")
+	role, _ := c.Get("user_role")
+
+	id, _ := userIdStr.(string)
+	userOID, _ := primitive.ObjectIDFromHex(id)
+	roleStr, _ := role.(string)
+	return authz.Subject{UserID: userOID, Role: roleStr}
+}
+
+// authorize asks the policy whether the caller may perform action on res and
+// writes a 403 when it may not.
+func authorize(c *gin.Context, az *authz.Authorizer, action authz.Action, res authz.Resource) bool {
+	if err := az.Authorize(context.Background(), subject(c), action, res); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to access this resource"})
+		return false
+	}
+	return true
+}
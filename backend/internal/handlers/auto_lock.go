@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/seating"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// autoLockAttempts is how many of the best blocks are tried when another
+// user grabs the top pick between reading the seat map and locking it.
+const autoLockAttempts = 3
+
+type AutoLockRequest struct {
+	PartySize int    `json:"partySize" binding:"required,min=1"`
+	SeatType  string `json:"seatType"`
+}
+
+func (h *BookingHandler) AutoLockSeats(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	userIdStr, _ := c.Get("user_id")
+	userId := userIdStr.(string)
+
+	var req AutoLockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": showtimeID}).Decode(&showtime); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+
+	// Reject oversized parties before searching: the split search is
+	// quadratic in the party size.
+	if max := h.purchaseLimits(showtime).MaxSeatsPerBooking; max > 0 && req.PartySize > max {
+		userOID, _ := primitive.ObjectIDFromHex(userId)
+		respondLockError(c, h.limitExceeded(showtime, userOID, "max_seats_per_booking", max, req.PartySize), "failed to create booking")
+		return
+	}
+
+	seatmap, err := h.loadSeatmap(ctx, showtime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "seatmap not found"})
+		return
+	}
+
+	available, err := h.availableSeats(ctx, showtimeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch seats"})
+		return
+	}
+	if req.PartySize > len(available) {
+		c.JSON(http.StatusConflict, gin.H{"error": "not enough seats available for this party size"})
+		return
+	}
+	isAvailable := func(code string) bool { return available[code] }
+
+	blocks := seating.BestBlocks(seatmap, isAvailable, req.PartySize, req.SeatType)
+	if h.gapRuleEnabled(showtime) {
+		blocks = withoutGaps(seatmap, available, blocks)
+	}
+	if len(blocks) == 0 {
+		resp := gin.H{"error": "no contiguous block of seats available for this party size"}
+		if split := seating.SplitSuggestion(seatmap, isAvailable, req.PartySize, req.SeatType); split != nil {
+			resp["alternatives"] = split
+		}
+		c.JSON(http.StatusConflict, resp)
+		return
+	}
+
+	// Lock the best block; if it was taken in the meantime, try the next one
+	for i, block := range blocks {
+		if i == autoLockAttempts {
+			break
+		}
+
+		booking, err := h.lockSeats(ctx, showtime, userId, block.Seats)
+		if err != nil {
+			// Only failures tied to this block are worth retrying on the
+			// next one; a purchase limit fails every block the same way.
+			var conflict *seatConflictError
+			var rule *bookingRuleError
+			if errors.As(err, &conflict) || errors.As(err, &rule) && rule.Code == ruleCodeSingleSeatGap {
+				continue
+			}
+			respondLockError(c, err, "failed to create booking")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"bookingId":     booking.ID.Hex(),
+			"lockExpiresAt": booking.LockExpiresAt.Format(time.RFC3339),
+			"prices":        booking.Prices,
+			"amount":        bookingAmount(*booking),
+			"block":         block,
+		})
+		return
+	}
+
+	c.JSON(http.StatusConflict, gin.H{"error": "seats were taken while selecting, please try again"})
+}
+
+// withoutGaps drops blocks that would break the single-seat gap rule.
+func withoutGaps(seatmap models.Seatmap, available map[string]bool, blocks []seating.Block) []seating.Block {
+	var kept []seating.Block
+	for _, block := range blocks {
+		picked := make(map[string]bool, len(block.Seats))
+		for _, seat := range block.Seats {
+			picked[seat] = true
+		}
+		after := func(code string) bool { return available[code] && !picked[code] }
+		if len(seating.SingleSeatGaps(seatmap, after, block.Seats)) == 0 {
+			kept = append(kept, block)
+		}
+	}
+	return kept
+}
+
+// availableSeats returns the seat codes that are currently AVAILABLE for a
+// showtime.
+func (h *BookingHandler) availableSeats(ctx context.Context, showtimeID primitive.ObjectID) (map[string]bool, error) {
+	cursor, err := h.Mongo.Collection("seat_reservations").Find(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"state":       models.SeatStateAvailable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var seats []models.SeatReservation
+	if err := cursor.All(ctx, &seats); err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]bool, len(seats))
+	for _, seat := range seats {
+		available[seat.SeatCode] = true
+	}
+	return available, nil
+}
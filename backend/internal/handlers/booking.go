@@ -3,26 +3,138 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/invoice"
+	"cinema-booking/internal/ledger"
 	"cinema-booking/internal/models"
 	"cinema-booking/internal/mq"
+	"cinema-booking/internal/payments"
+	"cinema-booking/internal/pricing"
 	"cinema-booking/internal/services"
 	wsHub "cinema-booking/internal/ws"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type BookingHandler struct {
 	Mongo   *services.MongoService
 	Redis   *services.RedisService
 	Hub     *wsHub.Hub
-	MQ      *mq.MQService
 	LockTTL time.Duration
+
+	// LockMaxExtensions caps how many times a hold can be extended, and
+	// LockMaxHold caps the total hold time measured from booking creation.
+	LockMaxExtensions int
+	LockMaxHold       time.Duration
+
+	// GapRuleDefault and Limits apply to showtimes that do not override
+	// them.
+	GapRuleDefault bool
+	Limits         models.PurchaseLimits
+
+	// RefundCutoff is how long before the showtime refunds stop being
+	// accepted.
+	RefundCutoff time.Duration
+
+	// PriceLocation is the timezone price list days and time bands are
+	// evaluated in.
+	PriceLocation *time.Location
+
+	// OnSeatsReleased is called after seats of a showtime go back to
+	// AVAILABLE, e.g. to offer them to the waitlist.
+	OnSeatsReleased func(showtimeID primitive.ObjectID)
+
+	// PaymentMaxAttempts caps how many payments can be tried for one
+	// booking; 0 means no cap.
+	PaymentMaxAttempts int
+
+	// Payments authorizes, captures and refunds card payments. PromptPay
+	// takes PROMPTPAY payments; nil disables the method.
+	Payments  payments.Provider
+	PromptPay *payments.PromptPayProvider
+
+	// Seller is named on every tax invoice, with VAT at VATRate percent.
+	// Invoices renders them as PDF.
+	Seller   models.InvoiceParty
+	VATRate  int
+	Invoices *invoice.Renderer
+
+	Authz *authz.Authorizer
+}
+
+var errBookingStateChanged = errors.New("booking is no longer in LOCKED state")
+
+// seatConflictError reports seats that could not be locked or confirmed.
+type seatConflictError struct {
+	Seats  []string
+	Reason string
+}
+
+func (e *seatConflictError) Error() string {
+	if len(e.Seats) == 1 {
+		return "seat " + e.Seats[0] + " is " + e.Reason
+	}
+	return "seats " + strings.Join(e.Seats, ", ") + " are " + e.Reason
+}
+
+// lockSeatReservations moves seats from AVAILABLE to LOCKED for a booking.
+// It fails with a seatConflictError on the first seat that is not available.
+func (h *BookingHandler) lockSeatReservations(ctx context.Context, showtimeID, userOID, bookingID primitive.ObjectID, seats []string, expiresAt time.Time) error {
+	for _, seat := range seats {
+		filter := bson.M{
+			"showtime_id": showtimeID,
+			"seat_code":   seat,
+			"state":       models.SeatStateAvailable,
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"state":             models.SeatStateLocked,
+				"locked_by_user_id": userOID,
+				"lock_expires_at":   expiresAt,
+				"booking_id":        bookingID,
+				"updated_at":        time.Now(),
+			},
+		}
+		result, err := h.Mongo.Collection("seat_reservations").UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return &seatConflictError{Seats: []string{seat}, Reason: "not available"}
+		}
+	}
+	return nil
+}
+
+// releaseSeatReservations moves a booking's LOCKED seats back to AVAILABLE.
+// A nil seats slice releases every seat held by the booking.
+func (h *BookingHandler) releaseSeatReservations(ctx context.Context, showtimeID, bookingID primitive.ObjectID, seats []string) error {
+	filter := bson.M{
+		"showtime_id": showtimeID,
+		"booking_id":  bookingID,
+		"state":       models.SeatStateLocked,
+	}
+	if seats != nil {
+		filter["seat_code"] = bson.M{"$in": seats}
+	}
+	_, err := h.Mongo.Collection("seat_reservations").UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"state":             models.SeatStateAvailable,
+		"locked_by_user_id": nil,
+		"lock_expires_at":   nil,
+		"booking_id":        nil,
+		"updated_at":        time.Now(),
+	}})
+	return err
 }
 
 type LockRequest struct {
@@ -30,8 +142,7 @@ type LockRequest struct {
 }
 
 func (h *BookingHandler) LockSeats(c *gin.Context) {
-	showtimeIdStr := c.Param("id")
-	showtimeID, err := primitive.ObjectIDFromHex(showtimeIdStr)
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
 		return
@@ -39,7 +150,6 @@ func (h *BookingHandler) LockSeats(c *gin.Context) {
 
 	userIdStr, _ := c.Get("user_id")
 	userId := userIdStr.(string)
-	userOID, _ := primitive.ObjectIDFromHex(userId)
 
 	var req LockRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -54,82 +164,109 @@ func (h *BookingHandler) LockSeats(c *gin.Context) {
 
 	ctx := context.Background()
 
-	// Try to acquire Redis locks for all seats
-	var lockedSeats []string
-	for _, seat := range req.Seats {
-		ok, err := h.Redis.AcquireLock(ctx, showtimeIdStr, seat, userId, h.LockTTL)
-		if err != nil || !ok {
-			// Release all acquired locks
-			for _, ls := range lockedSeats {
-				h.Redis.ReleaseLock(ctx, showtimeIdStr, ls, userId)
-			}
-			c.JSON(http.StatusConflict, gin.H{"error": "seat " + seat + " is already locked"})
-			return
-		}
-		lockedSeats = append(lockedSeats, seat)
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": showtimeID}).Decode(&showtime); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
 	}
 
-	lockExpiresAt := time.Now().Add(h.LockTTL)
+	booking, err := h.lockSeats(ctx, showtime, userId, req.Seats)
+	if err != nil {
+		respondLockError(c, err, "failed to create booking")
+		return
+	}
 
-	// Update seat_reservations in MongoDB
-	for _, seat := range req.Seats {
-		filter := bson.M{
-			"showtime_id": showtimeID,
-			"seat_code":   seat,
-			"state":       models.SeatStateAvailable,
-		}
-		update := bson.M{
-			"$set": bson.M{
-				"state":             models.SeatStateLocked,
-				"locked_by_user_id": userOID,
-				"lock_expires_at":   lockExpiresAt,
-				"updated_at":        time.Now(),
-			},
-		}
-		result, err := h.Mongo.Collection("seat_reservations").UpdateOne(ctx, filter, update)
-		if err != nil || result.MatchedCount == 0 {
-			// Rollback: release Redis locks and revert any MongoDB changes
-			for _, ls := range req.Seats {
-				h.Redis.ReleaseLock(ctx, showtimeIdStr, ls, userId)
-				h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
-					bson.M{"showtime_id": showtimeID, "seat_code": ls, "locked_by_user_id": userOID},
-					bson.M{"$set": bson.M{"state": models.SeatStateAvailable, "locked_by_user_id": nil, "lock_expires_at": nil}},
-				)
-			}
-			c.JSON(http.StatusConflict, gin.H{"error": "seat " + seat + " is not available"})
-			return
-		}
+	c.JSON(http.StatusOK, gin.H{
+		"bookingId":     booking.ID.Hex(),
+		"lockExpiresAt": booking.LockExpiresAt.Format(time.RFC3339),
+		"prices":        booking.Prices,
+		"amount":        bookingAmount(*booking),
+	})
+}
+
+// lockSeats is the shared locking path: it checks the booking rules, takes
+// the Redis locks for all seats, marks them LOCKED in Mongo together with a
+// new booking, and broadcasts SEAT_LOCKED. On any failure nothing stays
+// locked.
+func (h *BookingHandler) lockSeats(ctx context.Context, showtime models.Showtime, userId string, seats []string) (*models.Booking, error) {
+	userOID, _ := primitive.ObjectIDFromHex(userId)
+
+	if err := h.checkPurchaseLimits(ctx, showtime, userOID, nil, len(seats)); err != nil {
+		return nil, err
+	}
+	if err := h.checkSeatRules(ctx, showtime, seats, nil); err != nil {
+		return nil, err
 	}
 
-	// Create booking
-	bookingID := primitive.NewObjectID()
+	return h.holdSeats(ctx, showtime, userId, seats, h.LockTTL, models.SeatChangeLock)
+}
+
+// holdSeats locks seats for userId for ttl without applying the seat rules,
+// quoting their prices on the new booking. It is used by lockSeats and by
+// waitlist offers; reason is recorded in the seat history. The purchase
+// limits are re-checked before the booking commits.
+func (h *BookingHandler) holdSeats(ctx context.Context, showtime models.Showtime, userId string, seats []string, ttl time.Duration, reason string) (*models.Booking, error) {
+	showtimeID := showtime.ID
+	showtimeIdStr := showtimeID.Hex()
+	userOID, _ := primitive.ObjectIDFromHex(userId)
+
+	prices, err := h.quoteSeats(ctx, showtime, seats)
+	if err != nil {
+		return nil, err
+	}
+
+	// Acquire Redis locks for all seats at once (all-or-nothing)
+	conflicts, err := h.Redis.AcquireLocks(ctx, showtimeIdStr, seats, userId, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return nil, &seatConflictError{Seats: conflicts, Reason: "already locked"}
+	}
+
+	lockExpiresAt := time.Now().Add(ttl)
 	booking := models.Booking{
-		ID:            bookingID,
+		ID:            primitive.NewObjectID(),
 		UserID:        userOID,
 		ShowtimeID:    showtimeID,
-		Seats:         req.Seats,
+		Seats:         seats,
 		Status:        models.BookingStatusLocked,
 		LockExpiresAt: &lockExpiresAt,
+		Prices:        prices,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
 
-	_, err = h.Mongo.Collection("bookings").InsertOne(ctx, booking)
+	// Lock seat_reservations and create the booking in one transaction
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if err := h.lockSeatReservations(sc, showtimeID, userOID, booking.ID, seats, lockExpiresAt); err != nil {
+			return err
+		}
+		if _, err := h.Mongo.Collection("bookings").InsertOne(sc, booking); err != nil {
+			return err
+		}
+		return h.recheckPurchaseLimits(sc, showtime, userOID, nil, booking.ID, len(seats))
+	}, func(ctx context.Context) {
+		h.releaseSeatReservations(ctx, showtimeID, booking.ID, nil)
+		h.Mongo.Collection("bookings").DeleteOne(ctx, bson.M{"_id": booking.ID})
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create booking"})
-		return
+		h.Redis.ReleaseLocks(ctx, showtimeIdStr, seats, userId)
+		return nil, err
 	}
 
-	// Update seat_reservations with booking_id
-	for _, seat := range req.Seats {
-		h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
-			bson.M{"showtime_id": showtimeID, "seat_code": seat, "locked_by_user_id": userOID},
-			bson.M{"$set": bson.M{"booking_id": bookingID}},
-		)
-	}
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: showtimeID,
+		Seats:      seats,
+		From:       models.SeatStateAvailable,
+		To:         models.SeatStateLocked,
+		Reason:     reason,
+		Actor:      &userOID,
+		BookingID:  &booking.ID,
+	})
 
 	// Broadcast SEAT_LOCKED for each seat
-	for _, seat := range req.Seats {
+	for _, seat := range seats {
 		msg, _ := json.Marshal(map[string]interface{}{
 			"type":           "SEAT_LOCKED",
 			"seatCode":       seat,
@@ -139,13 +276,19 @@ func (h *BookingHandler) LockSeats(c *gin.Context) {
 		h.Hub.BroadcastToRoom(showtimeIdStr, msg)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"bookingId":     bookingID.Hex(),
-		"lockExpiresAt": lockExpiresAt.Format(time.RFC3339),
-	})
+	return &booking, nil
+}
+
+type PayRequest struct {
+	// Method is CARD (the default) or PROMPTPAY.
+	Method string `json:"method"`
 }
 
-func (h *BookingHandler) MockPayment(c *gin.Context) {
+// Pay creates a payment for a LOCKED booking and asks the payment provider
+// to authorize it. A provider that settles asynchronously leaves the payment
+// PENDING (202) until its webhook arrives; clients poll
+// GET /api/bookings/:id/payment before confirming.
+func (h *BookingHandler) Pay(c *gin.Context) {
 	bookingIdStr := c.Param("id")
 	bookingID, err := primitive.ObjectIDFromHex(bookingIdStr)
 	if err != nil {
@@ -153,48 +296,198 @@ func (h *BookingHandler) MockPayment(c *gin.Context) {
 		return
 	}
 
+	var req PayRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var provider payments.Provider
+	switch req.Method {
+	case "", models.PaymentMethodCard:
+		req.Method = models.PaymentMethodCard
+		provider = h.Payments
+	case models.PaymentMethodPromptPay:
+		if h.PromptPay == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "PromptPay is not available"})
+			return
+		}
+		provider = h.PromptPay
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown payment method " + req.Method})
+		return
+	}
+
 	ctx := context.Background()
 
 	// Verify booking exists and belongs to user
-	userIdStr, _ := c.Get("user_id")
-	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
-
 	var booking models.Booking
-	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": bookingID, "user_id": userOID}).Decode(&booking)
+	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    bookingID,
+		"status": models.BookingStatusLocked,
+	}).Decode(&booking)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in LOCKED state"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionPayBooking, authz.Booking(booking)) {
+		return
+	}
+	if booking.LockExpiresAt != nil && booking.LockExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock has expired"})
+		return
+	}
+	if booking.PaymentID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking already has a payment"})
+		return
+	}
+	if h.PaymentMaxAttempts > 0 && len(booking.PaymentIDs) >= h.PaymentMaxAttempts {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("no more than %d payment attempts per booking", h.PaymentMaxAttempts)})
+		return
+	}
+
+	// Recalculate the promo discount against the current seats and terms
+	var discount int64
+	if booking.Promo != nil {
+		var promo models.Promotion
+		err := h.Mongo.Collection("promotions").FindOne(ctx, bson.M{"_id": booking.Promo.PromotionID}).Decode(&promo)
+		if err == nil {
+			discount, err = h.promoDiscount(ctx, promo, booking)
+		}
+		if err != nil {
+			respondLockError(c, err, "failed to apply promo code")
+			return
+		}
+		booking.Promo.Discount = discount
+	}
+
+	amount := bookingAmount(booking) - discount
+	if req.Method == models.PaymentMethodPromptPay && amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to pay by PromptPay"})
 		return
 	}
 
-	// Create mock payment (always succeeds)
 	paymentID := primitive.NewObjectID()
 	payment := models.Payment{
 		ID:        paymentID,
 		BookingID: bookingID,
-		Amount:    float64(len(booking.Seats)) * 250.0, // 250 per seat
-		Status:    models.PaymentStatusSuccess,
-		Provider:  "MOCK",
+		Amount:    amount,
+		Discount:  discount,
+		Status:    models.PaymentStatusPending,
+		Method:    req.Method,
+		Provider:  provider.Name(),
+		Transitions: []models.PaymentTransition{
+			{To: models.PaymentStatusPending, Reason: "created", At: time.Now()},
+		},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	_, err = h.Mongo.Collection("payments").InsertOne(ctx, payment)
+	set := bson.M{"payment_id": paymentID, "updated_at": time.Now()}
+	if booking.Promo != nil {
+		set["promo"] = booking.Promo
+	}
+	// Earlier attempts stay in payment_ids; the filter also enforces the
+	// attempt cap against concurrent requests
+	filter := bson.M{"_id": bookingID, "status": models.BookingStatusLocked, "payment_id": bson.M{"$exists": false}}
+	if h.PaymentMaxAttempts > 0 {
+		filter[fmt.Sprintf("payment_ids.%d", h.PaymentMaxAttempts-1)] = bson.M{"$exists": false}
+	}
+
+	// Insert payment and link it to the booking together, before the
+	// provider can report on it
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if _, err := h.Mongo.Collection("payments").InsertOne(sc, payment); err != nil {
+			return err
+		}
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc, filter,
+			bson.M{"$set": set, "$push": bson.M{"payment_ids": paymentID}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+		return nil
+	}, func(ctx context.Context) {
+		h.Mongo.Collection("payments").DeleteOne(ctx, bson.M{"_id": paymentID})
+	})
 	if err != nil {
+		if errors.Is(err, errBookingStateChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "booking already has a payment, has used up its payment attempts or is no longer LOCKED"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create payment"})
 		return
 	}
 
-	// Update booking with payment_id
-	h.Mongo.Collection("bookings").UpdateOne(ctx,
-		bson.M{"_id": bookingID},
-		bson.M{"$set": bson.M{"payment_id": paymentID, "updated_at": time.Now()}},
-	)
+	var expiresAt time.Time
+	if booking.LockExpiresAt != nil {
+		expiresAt = *booking.LockExpiresAt
+	}
+	intent, err := provider.CreateIntent(ctx, payments.IntentRequest{
+		Reference: paymentID.Hex(),
+		BookingID: bookingIdStr,
+		Amount:    ledger.FromMinor(payment.Amount),
+		Currency:  paymentCurrency,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("Payment %s: %s failed to create intent: %v", paymentID.Hex(), provider.Name(), err)
+		if _, err := settlePayment(ctx, h.Mongo, payment, models.PaymentStatusFailed, "", "provider_unavailable"); err != nil {
+			log.Printf("Failed to mark payment %s as failed: %v", paymentID.Hex(), err)
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider is unavailable, try again"})
+		return
+	}
+
+	payment.ProviderRef = intent.ID
+	payment.QRPayload = intent.QRPayload
+	if status, settled := paymentStatus(intent.Status); settled {
+		if _, err := settlePayment(ctx, h.Mongo, payment, status, intent.ID, intent.FailureReason); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payment"})
+			return
+		}
+		payment.Status = status
+	} else {
+		set := bson.M{"provider_ref": intent.ID, "updated_at": time.Now()}
+		if intent.QRPayload != "" {
+			set["qr_payload"] = intent.QRPayload
+		}
+		h.Mongo.Collection("payments").UpdateOne(ctx, bson.M{"_id": paymentID}, bson.M{"$set": set})
+	}
+
+	code := http.StatusOK
+	switch payment.Status {
+	case models.PaymentStatusPending:
+		code = http.StatusAccepted
+	case models.PaymentStatusFailed:
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":     "payment was declined",
+			"paymentId": paymentID.Hex(),
+			"status":    payment.Status,
+			"reason":    intent.FailureReason,
+		})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
+	resp := gin.H{
 		"paymentId": paymentID.Hex(),
-		"status":    models.PaymentStatusSuccess,
+		"method":    payment.Method,
+		"status":    payment.Status,
 		"amount":    payment.Amount,
-	})
+		"discount":  payment.Discount,
+	}
+	if payment.QRPayload != "" {
+		resp["qr"] = gin.H{
+			"payload":   payment.QRPayload,
+			"image":     "/api/bookings/" + bookingIdStr + "/payment/qr",
+			"expiresAt": booking.LockExpiresAt,
+		}
+	}
+	c.JSON(code, resp)
 }
 
 func (h *BookingHandler) ConfirmBooking(c *gin.Context) {
@@ -213,14 +506,16 @@ func (h *BookingHandler) ConfirmBooking(c *gin.Context) {
 	// Get booking
 	var booking models.Booking
 	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
-		"_id":     bookingID,
-		"user_id": userOID,
-		"status":  models.BookingStatusLocked,
+		"_id":    bookingID,
+		"status": models.BookingStatusLocked,
 	}).Decode(&booking)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in LOCKED state"})
 		return
 	}
+	if !authorize(c, h.Authz, authz.ActionConfirmBooking, authz.Booking(booking)) {
+		return
+	}
 
 	// Check lock not expired
 	if booking.LockExpiresAt != nil && booking.LockExpiresAt.Before(time.Now()) {
@@ -235,46 +530,156 @@ func (h *BookingHandler) ConfirmBooking(c *gin.Context) {
 	}
 
 	var payment models.Payment
-	err = h.Mongo.Collection("payments").FindOne(ctx, bson.M{
-		"_id":    *booking.PaymentID,
-		"status": models.PaymentStatusSuccess,
-	}).Decode(&payment)
+	err = h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": *booking.PaymentID}).Decode(&payment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payment not found"})
+		return
+	}
+	switch payment.Status {
+	case models.PaymentStatusSuccess:
+	case models.PaymentStatusPending:
+		c.JSON(http.StatusConflict, gin.H{"error": "payment is still pending"})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payment not successful"})
+		return
+	}
+
+	// ✅ ดึงข้อมูล User
+	var user models.User
+	err = h.Mongo.Collection("users").FindOne(ctx, bson.M{
+		"_id": userOID,
+	}).Decode(&user)
+
+	if err != nil {
+		log.Printf("Warning: Failed to get user: %v", err)
+		// Fallback: ส่ง event โดยไม่มี email/name
+		user.Email = ""
+		user.Name = "Unknown User"
+	}
+
+	receipt, err := h.newReceipt(ctx, booking, payment)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "payment not found or not successful"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm booking"})
 		return
 	}
 
-	// Atomic update: seat_reservations -> BOOKED
+	// BookingConfirmed is written to the outbox with the booking change,
+	// once the invoice number is known
 	showtimeIdStr := booking.ShowtimeID.Hex()
-	for _, seat := range booking.Seats {
-		result, err := h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
-			bson.M{
-				"showtime_id":       booking.ShowtimeID,
-				"seat_code":         seat,
-				"state":             models.SeatStateLocked,
-				"locked_by_user_id": userOID,
-			},
-			bson.M{"$set": bson.M{
-				"state":      models.SeatStateBooked,
-				"updated_at": time.Now(),
-			}},
+	event := mq.NewBookingConfirmedEvent(bookingIdStr, userId, user.Email, user.Name, showtimeIdStr, booking.Seats)
+	event.Amount = payment.Amount
+	var outboxMsg models.OutboxMessage
+
+	unlock, err := h.lockInvoiceSeries(ctx, receipt.Type)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "invoices are busy, please try again"})
+		return
+	}
+
+	// Transaction: seat_reservations -> BOOKED, booking -> BOOKED, the
+	// invoice and the outbox entry
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		for _, seat := range booking.Seats {
+			result, err := h.Mongo.Collection("seat_reservations").UpdateOne(sc,
+				bson.M{
+					"showtime_id":       booking.ShowtimeID,
+					"seat_code":         seat,
+					"state":             models.SeatStateLocked,
+					"locked_by_user_id": userOID,
+					"booking_id":        bookingID,
+				},
+				bson.M{"$set": bson.M{
+					"state":      models.SeatStateBooked,
+					"updated_at": time.Now(),
+				}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return &seatConflictError{Seats: []string{seat}, Reason: "no longer locked"}
+			}
+		}
+
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": bookingID, "status": models.BookingStatusLocked, "payment_id": payment.ID},
+			bson.M{"$set": bson.M{"status": models.BookingStatusBooked, "updated_at": time.Now()}},
 		)
-		if err != nil || result.MatchedCount == 0 {
-			c.JSON(http.StatusConflict, gin.H{"error": "seat " + seat + " confirmation failed - may have been released"})
-			return
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+
+		if booking.Promo != nil {
+			if err := h.redeemPromo(sc, booking); err != nil {
+				return err
+			}
+		}
+
+		if err := h.insertInvoice(sc, &receipt); err != nil {
+			return err
+		}
+		event.InvoiceNumber = receipt.Number
+		outboxMsg, err = newOutboxMessage(event)
+		if err != nil {
+			return err
 		}
+		_, err = h.Mongo.Collection("outbox").InsertOne(sc, outboxMsg)
+		return err
+	}, func(ctx context.Context) {
+		h.Mongo.Collection("outbox").DeleteOne(ctx, bson.M{"_id": outboxMsg.ID})
+		h.deleteInvoice(ctx, receipt)
+		if booking.Promo != nil {
+			h.unredeemPromo(ctx, booking)
+		}
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": bookingID, "status": models.BookingStatusBooked},
+			bson.M{"$set": bson.M{"status": models.BookingStatusLocked, "updated_at": time.Now()}},
+		)
+		h.Mongo.Collection("seat_reservations").UpdateMany(ctx,
+			bson.M{"showtime_id": booking.ShowtimeID, "booking_id": bookingID, "state": models.SeatStateBooked},
+			bson.M{"$set": bson.M{"state": models.SeatStateLocked, "updated_at": time.Now()}},
+		)
+	})
+	unlock()
+	if err != nil {
+		var conflict *seatConflictError
+		switch {
+		case errors.As(err, &conflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "seat " + strings.Join(conflict.Seats, ", ") + " confirmation failed - may have been released"})
+		case errors.Is(err, errBookingStateChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, errPromoUnavailable):
+			if err := h.voidPromoPayment(ctx, booking, payment); err != nil {
+				log.Printf("Failed to refund payment %s after promo ran out: %v", payment.ID.Hex(), err)
+			}
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "promo code is no longer available; the payment was refunded, pay again without it",
+				"code":  ruleCodePromoUnavailable,
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm booking"})
+		}
+		return
 	}
 
-	// Update booking -> BOOKED
-	h.Mongo.Collection("bookings").UpdateOne(ctx,
-		bson.M{"_id": bookingID},
-		bson.M{"$set": bson.M{"status": models.BookingStatusBooked, "updated_at": time.Now()}},
-	)
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: booking.ShowtimeID,
+		Seats:      booking.Seats,
+		From:       models.SeatStateLocked,
+		To:         models.SeatStateBooked,
+		Reason:     models.SeatChangeConfirm,
+		Actor:      &userOID,
+		BookingID:  &bookingID,
+	})
 
 	// Release Redis locks
-	for _, seat := range booking.Seats {
-		h.Redis.ReleaseLock(ctx, showtimeIdStr, seat, userId)
-	}
+	h.Redis.ReleaseLocks(ctx, showtimeIdStr, booking.Seats, userId)
+
+	h.capturePayment(ctx, payment)
 
 	// Broadcast SEAT_BOOKED
 	for _, seat := range booking.Seats {
@@ -285,30 +690,120 @@ func (h *BookingHandler) ConfirmBooking(c *gin.Context) {
 		h.Hub.BroadcastToRoom(showtimeIdStr, msg)
 	}
 
-	// ✅ ดึงข้อมูล User
-	var user models.User
-	err = h.Mongo.Collection("users").FindOne(ctx, bson.M{
-		"_id": userOID,
-	}).Decode(&user)
+	c.JSON(http.StatusOK, gin.H{"status": "BOOKED", "invoiceNumber": receipt.Number})
+}
 
+func (h *BookingHandler) CancelBooking(c *gin.Context) {
+	bookingIdStr := c.Param("id")
+	bookingID, err := primitive.ObjectIDFromHex(bookingIdStr)
 	if err != nil {
-		log.Printf("Warning: Failed to get user: %v", err)
-		// Fallback: ส่ง event โดยไม่มี email/name
-		user.Email = ""
-		user.Name = "Unknown User"
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
 	}
 
-	// ตอนนี้ใช้ได้แล้ว
-	log.Printf("User: %s (%s)", user.Name, user.Email)
+	ctx := context.Background()
 
-	// Publish MQ event
-	event := mq.NewBookingConfirmedEvent(bookingIdStr, userId, user.Email, user.Name, showtimeIdStr, booking.Seats)
-	h.MQ.SafePublish(event)
+	var booking models.Booking
+	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    bookingID,
+		"status": models.BookingStatusLocked,
+	}).Decode(&booking)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in LOCKED state"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionCancelBooking, authz.Booking(booking)) {
+		return
+	}
+
+	actor := subject(c).UserID
+	if err := h.releaseHold(ctx, booking, &actor, models.SeatChangeCancel); err != nil {
+		if errors.Is(err, errBookingStateChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel booking"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "BOOKED"})
+	h.seatsReleased(booking.ShowtimeID)
+
+	c.JSON(http.StatusOK, gin.H{"status": "CANCELLED"})
 }
 
-func (h *BookingHandler) CancelBooking(c *gin.Context) {
+// releaseHold cancels a LOCKED booking: the booking becomes CANCELLED, its
+// seats go back to AVAILABLE, the Redis locks are dropped and SEAT_RELEASED
+// is broadcast.
+func (h *BookingHandler) releaseHold(ctx context.Context, booking models.Booking, actor *primitive.ObjectID, reason string) error {
+	showtimeIdStr := booking.ShowtimeID.Hex()
+
+	// Transaction: booking -> CANCELLED and seat_reservations -> AVAILABLE
+	err := h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusLocked},
+			bson.M{"$set": bson.M{"status": models.BookingStatusCancelled, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+
+		return h.releaseSeatReservations(sc, booking.ShowtimeID, booking.ID, nil)
+	}, func(ctx context.Context) {
+		// Seats another user has taken since cannot be given back
+		h.Mongo.Collection("seat_reservations").UpdateMany(ctx,
+			bson.M{"showtime_id": booking.ShowtimeID, "seat_code": bson.M{"$in": booking.Seats}, "state": models.SeatStateAvailable},
+			bson.M{"$set": bson.M{
+				"state":             models.SeatStateLocked,
+				"locked_by_user_id": booking.UserID,
+				"lock_expires_at":   booking.LockExpiresAt,
+				"booking_id":        booking.ID,
+				"updated_at":        time.Now(),
+			}},
+		)
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusCancelled},
+			bson.M{"$set": bson.M{"status": models.BookingStatusLocked, "updated_at": time.Now()}},
+		)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.Redis.ReleaseLocks(ctx, showtimeIdStr, booking.Seats, booking.UserID.Hex())
+
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: booking.ShowtimeID,
+		Seats:      booking.Seats,
+		From:       models.SeatStateLocked,
+		To:         models.SeatStateAvailable,
+		Reason:     reason,
+		Actor:      actor,
+		BookingID:  &booking.ID,
+	})
+
+	// Broadcast SEAT_RELEASED
+	for _, seat := range booking.Seats {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":     "SEAT_RELEASED",
+			"seatCode": seat,
+		})
+		h.Hub.BroadcastToRoom(showtimeIdStr, msg)
+	}
+	return nil
+}
+
+// seatsReleased notifies OnSeatsReleased, if set, without blocking the
+// request.
+func (h *BookingHandler) seatsReleased(showtimeID primitive.ObjectID) {
+	if h.OnSeatsReleased != nil {
+		go h.OnSeatsReleased(showtimeID)
+	}
+}
+
+func (h *BookingHandler) ExtendLock(c *gin.Context) {
 	bookingIdStr := c.Param("id")
 	bookingID, err := primitive.ObjectIDFromHex(bookingIdStr)
 	if err != nil {
@@ -319,44 +814,330 @@ func (h *BookingHandler) CancelBooking(c *gin.Context) {
 	ctx := context.Background()
 	userIdStr, _ := c.Get("user_id")
 	userId := userIdStr.(string)
-	userOID, _ := primitive.ObjectIDFromHex(userId)
 
 	var booking models.Booking
 	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
-		"_id":     bookingID,
-		"user_id": userOID,
-		"status":  models.BookingStatusLocked,
+		"_id":    bookingID,
+		"status": models.BookingStatusLocked,
 	}).Decode(&booking)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in LOCKED state"})
 		return
 	}
+	if !authorize(c, h.Authz, authz.ActionExtendBooking, authz.Booking(booking)) {
+		return
+	}
+
+	now := time.Now()
+	if booking.LockExpiresAt == nil || booking.LockExpiresAt.Before(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock has expired"})
+		return
+	}
+	if booking.Extensions >= h.LockMaxExtensions {
+		c.JSON(http.StatusConflict, gin.H{"error": "maximum number of extensions reached"})
+		return
+	}
+
+	// New expiry is a full TTL from now, capped by the total hold limit
+	newExpiresAt := now.Add(h.LockTTL)
+	if holdCap := booking.CreatedAt.Add(h.LockMaxHold); newExpiresAt.After(holdCap) {
+		newExpiresAt = holdCap
+	}
+	if !newExpiresAt.After(*booking.LockExpiresAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "maximum hold time reached"})
+		return
+	}
 
 	showtimeIdStr := booking.ShowtimeID.Hex()
+	lost, err := h.Redis.ExtendLocks(ctx, showtimeIdStr, booking.Seats, userId, newExpiresAt.Sub(now))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to extend seat locks"})
+		return
+	}
+	if len(lost) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "seat locks lost for " + strings.Join(lost, ", "),
+			"seats": lost,
+		})
+		return
+	}
 
-	// Release Redis locks + update MongoDB
-	for _, seat := range booking.Seats {
-		h.Redis.ReleaseLock(ctx, showtimeIdStr, seat, userId)
-		h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
-			bson.M{"showtime_id": booking.ShowtimeID, "seat_code": seat},
-			bson.M{"$set": bson.M{
-				"state":             models.SeatStateAvailable,
-				"locked_by_user_id": nil,
-				"lock_expires_at":   nil,
-				"booking_id":        nil,
-				"updated_at":        time.Now(),
-			}},
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{
+				"_id":             bookingID,
+				"status":          models.BookingStatusLocked,
+				"lock_expires_at": booking.LockExpiresAt,
+			},
+			bson.M{
+				"$set": bson.M{"lock_expires_at": newExpiresAt, "updated_at": time.Now()},
+				"$inc": bson.M{"extensions": 1},
+			},
 		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+
+		_, err = h.Mongo.Collection("seat_reservations").UpdateMany(sc,
+			bson.M{"showtime_id": booking.ShowtimeID, "booking_id": bookingID, "state": models.SeatStateLocked},
+			bson.M{"$set": bson.M{"lock_expires_at": newExpiresAt, "updated_at": time.Now()}},
+		)
+		return err
+	}, func(ctx context.Context) {
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": bookingID, "lock_expires_at": newExpiresAt},
+			bson.M{
+				"$set": bson.M{"lock_expires_at": booking.LockExpiresAt, "updated_at": time.Now()},
+				"$inc": bson.M{"extensions": -1},
+			},
+		)
+	})
+	if err != nil {
+		if errors.Is(err, errBookingStateChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to extend booking"})
+		return
 	}
 
-	// Update booking -> CANCELLED
-	h.Mongo.Collection("bookings").UpdateOne(ctx,
-		bson.M{"_id": bookingID},
-		bson.M{"$set": bson.M{"status": models.BookingStatusCancelled, "updated_at": time.Now()}},
-	)
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: booking.ShowtimeID,
+		Seats:      booking.Seats,
+		From:       models.SeatStateLocked,
+		To:         models.SeatStateLocked,
+		Reason:     models.SeatChangeExtend,
+		Note:       "lock extended to " + newExpiresAt.Format(time.RFC3339),
+		Actor:      &booking.UserID,
+		BookingID:  &bookingID,
+	})
 
-	// Broadcast SEAT_RELEASED
+	// Broadcast SEAT_LOCK_EXTENDED so other viewers update their countdowns
+	for _, seat := range booking.Seats {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":           "SEAT_LOCK_EXTENDED",
+			"seatCode":       seat,
+			"lockedByUserId": userId,
+			"lockExpiresAt":  newExpiresAt.Format(time.RFC3339),
+		})
+		h.Hub.BroadcastToRoom(showtimeIdStr, msg)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bookingId":           bookingIdStr,
+		"lockExpiresAt":       newExpiresAt.Format(time.RFC3339),
+		"extensionsRemaining": h.LockMaxExtensions - booking.Extensions - 1,
+	})
+}
+
+type ModifySeatsRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+func (h *BookingHandler) ModifySeats(c *gin.Context) {
+	bookingIdStr := c.Param("id")
+	bookingID, err := primitive.ObjectIDFromHex(bookingIdStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	var req ModifySeatsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no seats to add or remove"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userId := userIdStr.(string)
+	userOID, _ := primitive.ObjectIDFromHex(userId)
+
+	var booking models.Booking
+	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    bookingID,
+		"status": models.BookingStatusLocked,
+	}).Decode(&booking)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in LOCKED state"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionModifySeats, authz.Booking(booking)) {
+		return
+	}
+
+	if booking.LockExpiresAt == nil || booking.LockExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock has expired"})
+		return
+	}
+	if booking.PaymentID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot change seats after payment"})
+		return
+	}
+
+	// Work out the new seat set, ignoring no-op adds and removes
+	held := make(map[string]bool, len(booking.Seats))
 	for _, seat := range booking.Seats {
+		held[seat] = true
+	}
+	var added, removed []string
+	for _, seat := range req.Remove {
+		if !held[seat] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seat " + seat + " is not part of this booking"})
+			return
+		}
+		held[seat] = false
+		removed = append(removed, seat)
+	}
+	for _, seat := range req.Add {
+		if _, ok := held[seat]; ok {
+			if !held[seat] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "seat " + seat + " cannot be both added and removed"})
+				return
+			}
+			continue
+		}
+		held[seat] = true
+		added = append(added, seat)
+	}
+
+	var newSeats []string
+	for _, seat := range booking.Seats {
+		if held[seat] {
+			newSeats = append(newSeats, seat)
+		}
+	}
+	newSeats = append(newSeats, added...)
+	if len(newSeats) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "booking must keep at least one seat, cancel it instead"})
+		return
+	}
+
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": booking.ShowtimeID}).Decode(&showtime); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+	if err := h.checkPurchaseLimits(ctx, showtime, userOID, &booking, len(added)-len(removed)); err != nil {
+		respondLockError(c, err, "failed to validate seats")
+		return
+	}
+	if err := h.checkSeatRules(ctx, showtime, added, removed); err != nil {
+		respondLockError(c, err, "failed to validate seats")
+		return
+	}
+
+	// Kept seats keep the price they were quoted; added seats are quoted now
+	newPrices := pricesFor(booking, newSeats[:len(newSeats)-len(added)])
+	if len(added) > 0 {
+		addedPrices, err := h.quoteSeats(ctx, showtime, added)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to price seats"})
+			return
+		}
+		newPrices = append(newPrices, addedPrices...)
+	}
+
+	// Added seats share the booking's original expiry
+	showtimeIdStr := booking.ShowtimeID.Hex()
+	lockExpiresAt := *booking.LockExpiresAt
+	if len(added) > 0 {
+		conflicts, err := h.Redis.AcquireLocks(ctx, showtimeIdStr, added, userId, time.Until(lockExpiresAt))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to acquire seat locks"})
+			return
+		}
+		if len(conflicts) > 0 {
+			respondLockError(c, &seatConflictError{Seats: conflicts, Reason: "already locked"}, "")
+			return
+		}
+	}
+
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if err := h.lockSeatReservations(sc, booking.ShowtimeID, userOID, bookingID, added, lockExpiresAt); err != nil {
+			return err
+		}
+		if len(removed) > 0 {
+			if err := h.releaseSeatReservations(sc, booking.ShowtimeID, bookingID, removed); err != nil {
+				return err
+			}
+		}
+
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": bookingID, "status": models.BookingStatusLocked, "seats": booking.Seats},
+			bson.M{"$set": bson.M{"seats": newSeats, "prices": newPrices, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+		return h.recheckPurchaseLimits(sc, showtime, userOID, &booking, bookingID, len(added)-len(removed))
+	}, func(ctx context.Context) {
+		h.releaseSeatReservations(ctx, booking.ShowtimeID, bookingID, added)
+		for _, seat := range removed {
+			h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
+				bson.M{"showtime_id": booking.ShowtimeID, "seat_code": seat, "state": models.SeatStateAvailable},
+				bson.M{"$set": bson.M{
+					"state":             models.SeatStateLocked,
+					"locked_by_user_id": userOID,
+					"lock_expires_at":   lockExpiresAt,
+					"booking_id":        bookingID,
+					"updated_at":        time.Now(),
+				}},
+			)
+		}
+	})
+	if err != nil {
+		h.Redis.ReleaseLocks(ctx, showtimeIdStr, added, userId)
+		respondLockError(c, err, "failed to update booking seats")
+		return
+	}
+
+	h.Redis.ReleaseLocks(ctx, showtimeIdStr, removed, userId)
+	if len(removed) > 0 {
+		h.seatsReleased(booking.ShowtimeID)
+	}
+
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: booking.ShowtimeID,
+		Seats:      added,
+		From:       models.SeatStateAvailable,
+		To:         models.SeatStateLocked,
+		Reason:     models.SeatChangeModify,
+		Actor:      &userOID,
+		BookingID:  &bookingID,
+	})
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: booking.ShowtimeID,
+		Seats:      removed,
+		From:       models.SeatStateLocked,
+		To:         models.SeatStateAvailable,
+		Reason:     models.SeatChangeModify,
+		Actor:      &userOID,
+		BookingID:  &bookingID,
+	})
+
+	// Broadcast only the seats that changed
+	for _, seat := range added {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":           "SEAT_LOCKED",
+			"seatCode":       seat,
+			"lockedByUserId": userId,
+			"lockExpiresAt":  lockExpiresAt.Format(time.RFC3339),
+		})
+		h.Hub.BroadcastToRoom(showtimeIdStr, msg)
+	}
+	for _, seat := range removed {
 		msg, _ := json.Marshal(map[string]interface{}{
 			"type":     "SEAT_RELEASED",
 			"seatCode": seat,
@@ -364,7 +1145,15 @@ func (h *BookingHandler) CancelBooking(c *gin.Context) {
 		h.Hub.BroadcastToRoom(showtimeIdStr, msg)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "CANCELLED"})
+	c.JSON(http.StatusOK, gin.H{
+		"bookingId":     bookingIdStr,
+		"seats":         newSeats,
+		"added":         added,
+		"removed":       removed,
+		"lockExpiresAt": lockExpiresAt.Format(time.RFC3339),
+		"prices":        newPrices,
+		"amount":        pricing.Total(newPrices),
+	})
 }
 
 func (h *BookingHandler) GetBooking(c *gin.Context) {
@@ -381,6 +1170,9 @@ func (h *BookingHandler) GetBooking(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
 		return
 	}
+	if !authorize(c, h.Authz, authz.ActionReadBooking, authz.Booking(booking)) {
+		return
+	}
 
 	c.JSON(http.StatusOK, booking)
 }
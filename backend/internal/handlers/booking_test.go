@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/invoice"
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/payments"
+	"cinema-booking/internal/services"
+	wsHub "cinema-booking/internal/ws"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The tests below run against the MongoDB at TEST_MONGO_URI, each in a
+// database of its own. A replica set runs both the transaction and the
+// fallback mode; a standalone server only the fallback mode.
+
+func testMongo(t *testing.T, transactions bool) *services.MongoService {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+
+	svc := services.NewMongoService(uri, fmt.Sprintf("cinema_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		svc.DB.Drop(context.Background())
+		svc.Client.Disconnect(context.Background())
+	})
+	if transactions && !svc.SupportsTransactions {
+		t.Skip("MongoDB at TEST_MONGO_URI is not a replica set")
+	}
+	svc.SupportsTransactions = transactions
+	return svc
+}
+
+// rejectWrites makes every write to collection that leaves a document not
+// matching validator fail.
+func rejectWrites(t *testing.T, svc *services.MongoService, collection string, validator bson.M) {
+	t.Helper()
+	err := svc.DB.RunCommand(context.Background(), bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "validator", Value: validator},
+	}).Err()
+	if err != nil {
+		t.Fatalf("collMod %s: %v", collection, err)
+	}
+}
+
+// heldBooking is a paid booking holding seats A1 and A2, ready to confirm.
+type heldBooking struct {
+	user    primitive.ObjectID
+	booking models.Booking
+}
+
+func seedHeldBooking(t *testing.T, svc *services.MongoService) heldBooking {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	expires := now.Add(10 * time.Minute)
+
+	user := models.User{ID: primitive.NewObjectID(), Email: "user@example.com", Name: "User", Role: models.RoleUser, CreatedAt: now}
+	movie := models.Movie{ID: primitive.NewObjectID(), Title: "Test Movie", DurationMin: 120, CreatedAt: now}
+	showtime := models.Showtime{ID: primitive.NewObjectID(), MovieID: movie.ID, StartTime: now.Add(24 * time.Hour), CreatedAt: now}
+	booking := models.Booking{
+		ID:            primitive.NewObjectID(),
+		UserID:        user.ID,
+		ShowtimeID:    showtime.ID,
+		Seats:         []string{"A1", "A2"},
+		Status:        models.BookingStatusLocked,
+		LockExpiresAt: &expires,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	payment := models.Payment{
+		ID:        primitive.NewObjectID(),
+		BookingID: booking.ID,
+		Amount:    40000,
+		Status:    models.PaymentStatusSuccess,
+		Provider:  "MOCK",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	booking.PaymentID = &payment.ID
+	booking.PaymentIDs = []primitive.ObjectID{payment.ID}
+
+	inserts := []struct {
+		collection string
+		doc        any
+	}{
+		{"users", user},
+		{"movies", movie},
+		{"showtimes", showtime},
+		{"bookings", booking},
+		{"payments", payment},
+	}
+	for _, seat := range booking.Seats {
+		inserts = append(inserts, struct {
+			collection string
+			doc        any
+		}{"seat_reservations", models.SeatReservation{
+			ID:             primitive.NewObjectID(),
+			ShowtimeID:     showtime.ID,
+			SeatCode:       seat,
+			State:          models.SeatStateLocked,
+			LockedByUserID: &user.ID,
+			LockExpiresAt:  &expires,
+			BookingID:      &booking.ID,
+			UpdatedAt:      now,
+		}})
+	}
+	for _, in := range inserts {
+		if _, err := svc.Collection(in.collection).InsertOne(ctx, in.doc); err != nil {
+			t.Fatalf("seed %s: %v", in.collection, err)
+		}
+	}
+	return heldBooking{user: user.ID, booking: booking}
+}
+
+func testBookingHandler(svc *services.MongoService) *BookingHandler {
+	return &BookingHandler{
+		Mongo:         svc,
+		Hub:           wsHub.NewHub(),
+		Payments:      payments.MockProvider{},
+		Authz:         &authz.Authorizer{Mongo: svc},
+		PriceLocation: time.UTC,
+		VATRate:       7,
+	}
+}
+
+func confirm(h *BookingHandler, held heldBooking) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/bookings/"+held.booking.ID.Hex()+"/confirm", nil)
+	c.Params = gin.Params{{Key: "id", Value: held.booking.ID.Hex()}}
+	c.Set("user_id", held.user.Hex())
+	c.Set("user_role", models.RoleUser)
+	h.ConfirmBooking(c)
+	return w
+}
+
+// assertStillHeld fails unless the booking and its seats are exactly as
+// seeded: nothing booked, invoiced or queued, and no invoice number used.
+func assertStillHeld(t *testing.T, svc *services.MongoService, held heldBooking) {
+	t.Helper()
+	ctx := context.Background()
+
+	var booking models.Booking
+	if err := svc.Collection("bookings").FindOne(ctx, bson.M{"_id": held.booking.ID}).Decode(&booking); err != nil {
+		t.Fatalf("load booking: %v", err)
+	}
+	if booking.Status != models.BookingStatusLocked {
+		t.Errorf("booking status = %s, want %s", booking.Status, models.BookingStatusLocked)
+	}
+
+	var seats []models.SeatReservation
+	cursor, err := svc.Collection("seat_reservations").Find(ctx, bson.M{"showtime_id": held.booking.ShowtimeID})
+	if err != nil {
+		t.Fatalf("load seats: %v", err)
+	}
+	if err := cursor.All(ctx, &seats); err != nil {
+		t.Fatalf("load seats: %v", err)
+	}
+	if len(seats) != len(held.booking.Seats) {
+		t.Fatalf("got %d seats, want %d", len(seats), len(held.booking.Seats))
+	}
+	for _, seat := range seats {
+		if seat.State != models.SeatStateLocked || seat.BookingID == nil || *seat.BookingID != held.booking.ID {
+			t.Errorf("seat %s is %s, want LOCKED by the booking", seat.SeatCode, seat.State)
+		}
+	}
+
+	for _, collection := range []string{"invoices", "outbox"} {
+		n, err := svc.Collection(collection).CountDocuments(ctx, bson.M{})
+		if err != nil {
+			t.Fatalf("count %s: %v", collection, err)
+		}
+		if n != 0 {
+			t.Errorf("%s has %d documents, want 0", collection, n)
+		}
+	}
+
+	n, err := svc.Collection("counters").CountDocuments(ctx, bson.M{"seq": bson.M{"$gt": 0}})
+	if err != nil {
+		t.Fatalf("count counters: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("an invoice number was used up")
+	}
+}
+
+func TestConfirmBookingFailureLeavesNothingApplied(t *testing.T) {
+	cases := []struct {
+		name       string
+		collection string
+		validator  bson.M
+	}{
+		// A1 is booked before A2 fails
+		{"seat", "seat_reservations", bson.M{"$or": bson.A{
+			bson.M{"seat_code": bson.M{"$ne": "A2"}},
+			bson.M{"state": bson.M{"$ne": models.SeatStateBooked}},
+		}}},
+		{"booking", "bookings", bson.M{"status": bson.M{"$ne": models.BookingStatusBooked}}},
+		{"invoice", "invoices", bson.M{"never": bson.M{"$exists": true}}},
+		{"outbox", "outbox", bson.M{"never": bson.M{"$exists": true}}},
+	}
+
+	for _, mode := range []struct {
+		name         string
+		transactions bool
+	}{{"transaction", true}, {"fallback", false}} {
+		for _, tc := range cases {
+			t.Run(mode.name+"/"+tc.name, func(t *testing.T) {
+				svc := testMongo(t, mode.transactions)
+				held := seedHeldBooking(t, svc)
+				rejectWrites(t, svc, tc.collection, tc.validator)
+
+				w := confirm(testBookingHandler(svc), held)
+				if w.Code == http.StatusOK {
+					t.Fatalf("confirm succeeded despite failing %s write", tc.name)
+				}
+				assertStillHeld(t, svc, held)
+			})
+		}
+	}
+}
+
+func TestConfirmBookingSeatTakenLeavesNothingApplied(t *testing.T) {
+	for _, mode := range []struct {
+		name         string
+		transactions bool
+	}{{"transaction", true}, {"fallback", false}} {
+		t.Run(mode.name, func(t *testing.T) {
+			svc := testMongo(t, mode.transactions)
+			held := seedHeldBooking(t, svc)
+
+			// A2 was released and is now held by someone else
+			other := primitive.NewObjectID()
+			_, err := svc.Collection("seat_reservations").UpdateOne(context.Background(),
+				bson.M{"showtime_id": held.booking.ShowtimeID, "seat_code": "A2"},
+				bson.M{"$set": bson.M{"locked_by_user_id": other, "booking_id": other}},
+			)
+			if err != nil {
+				t.Fatalf("take seat: %v", err)
+			}
+
+			w := confirm(testBookingHandler(svc), held)
+			if w.Code != http.StatusConflict {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusConflict)
+			}
+
+			var a1 models.SeatReservation
+			err = svc.Collection("seat_reservations").FindOne(context.Background(),
+				bson.M{"showtime_id": held.booking.ShowtimeID, "seat_code": "A1"},
+			).Decode(&a1)
+			if err != nil {
+				t.Fatalf("load A1: %v", err)
+			}
+			if a1.State != models.SeatStateLocked {
+				t.Errorf("A1 is %s, want LOCKED", a1.State)
+			}
+			var booking models.Booking
+			if err := svc.Collection("bookings").FindOne(context.Background(), bson.M{"_id": held.booking.ID}).Decode(&booking); err != nil {
+				t.Fatalf("load booking: %v", err)
+			}
+			if booking.Status != models.BookingStatusLocked {
+				t.Errorf("booking status = %s, want %s", booking.Status, models.BookingStatusLocked)
+			}
+		})
+	}
+}
+
+func TestReleaseHoldFailureLeavesNothingApplied(t *testing.T) {
+	for _, mode := range []struct {
+		name         string
+		transactions bool
+	}{{"transaction", true}, {"fallback", false}} {
+		t.Run(mode.name, func(t *testing.T) {
+			svc := testMongo(t, mode.transactions)
+			held := seedHeldBooking(t, svc)
+			rejectWrites(t, svc, "seat_reservations", bson.M{"$or": bson.A{
+				bson.M{"seat_code": bson.M{"$ne": "A2"}},
+				bson.M{"state": bson.M{"$ne": models.SeatStateAvailable}},
+			}})
+
+			err := testBookingHandler(svc).releaseHold(context.Background(), held.booking, &held.user, models.SeatChangeCancel)
+			if err == nil {
+				t.Fatal("releaseHold succeeded despite failing seat write")
+			}
+			assertStillHeld(t, svc, held)
+		})
+	}
+}
+
+// bookedCopies returns n BOOKED bookings paid with held's payment, each
+// with an ID of its own, for issuing invoices without confirming.
+func bookedCopies(held heldBooking, n int) []models.Booking {
+	bookings := make([]models.Booking, n)
+	for i := range bookings {
+		b := held.booking
+		b.ID = primitive.NewObjectID()
+		b.Status = models.BookingStatusBooked
+		bookings[i] = b
+	}
+	return bookings
+}
+
+func TestInvoiceFallbackReclaimsLostNumber(t *testing.T) {
+	svc := testMongo(t, false)
+	held := seedHeldBooking(t, svc)
+	h := testBookingHandler(svc)
+	ctx := context.Background()
+
+	// A writer died after taking numbers 1 to 3 without storing invoices
+	year := time.Now().In(h.PriceLocation).Year()
+	seq, err := svc.NextInvoiceSeq(ctx, invoice.Series(models.InvoiceTypeAbbreviated), year)
+	for i := 1; i < 3 && err == nil; i++ {
+		seq, err = svc.NextInvoiceSeq(ctx, invoice.Series(models.InvoiceTypeAbbreviated), year)
+	}
+	if err != nil || seq != 3 {
+		t.Fatalf("take numbers: seq %d, %v", seq, err)
+	}
+
+	inv, err := h.currentInvoice(ctx, bookedCopies(held, 1)[0])
+	if err != nil {
+		t.Fatalf("issue invoice: %v", err)
+	}
+	if inv.Seq != 1 {
+		t.Errorf("invoice %s has seq %d, want 1", inv.Number, inv.Seq)
+	}
+}
+
+func TestInvoiceFallbackFailureLeavesNoGap(t *testing.T) {
+	svc := testMongo(t, false)
+	held := seedHeldBooking(t, svc)
+	h := testBookingHandler(svc)
+	ctx := context.Background()
+
+	bookings := bookedCopies(held, 6)
+	failing := bookings[2].ID
+	rejectWrites(t, svc, "invoices", bson.M{"booking_id": bson.M{"$ne": failing}})
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(bookings))
+	for i, b := range bookings {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = h.currentInvoice(ctx, b)
+		}()
+	}
+	wg.Wait()
+
+	for i, b := range bookings {
+		if (b.ID == failing) != (errs[i] != nil) {
+			t.Errorf("booking %d: err = %v", i, errs[i])
+		}
+	}
+
+	var issued []models.Invoice
+	cursor, err := svc.Collection("invoices").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		t.Fatalf("load invoices: %v", err)
+	}
+	if err := cursor.All(ctx, &issued); err != nil {
+		t.Fatalf("load invoices: %v", err)
+	}
+	if len(issued) != len(bookings)-1 {
+		t.Fatalf("got %d invoices, want %d", len(issued), len(bookings)-1)
+	}
+	for i, inv := range issued {
+		if inv.Seq != int64(i+1) {
+			t.Errorf("invoice %d is %s, want seq %d", i, inv.Number, i+1)
+		}
+	}
+}
@@ -0,0 +1,780 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/ledger"
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/payments"
+	"cinema-booking/internal/pricing"
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// exchangeLockTTL guards the new seats in Redis while an exchange that
+// needs no payment commits.
+const exchangeLockTTL = 30 * time.Second
+
+var errExchangePending = errors.New("a seat exchange is waiting for payment")
+
+type ExchangeRequest struct {
+	Seats []string `json:"seats" binding:"required"`
+	// ShowtimeID optionally moves the booking to another showtime of the
+	// same movie.
+	ShowtimeID string `json:"showtimeId"`
+}
+
+func (h *BookingHandler) ExchangeSeats(c *gin.Context) {
+	bookingIdStr := c.Param("id")
+	bookingID, err := primitive.ObjectIDFromHex(bookingIdStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	var req ExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Seats) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no seats selected"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userId := userIdStr.(string)
+	userOID, _ := primitive.ObjectIDFromHex(userId)
+
+	var booking models.Booking
+	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    bookingID,
+		"status": models.BookingStatusBooked,
+	}).Decode(&booking)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in BOOKED state"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionExchangeSeats, authz.Booking(booking)) {
+		return
+	}
+	if booking.Exchange != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": errExchangePending.Error(), "paymentId": booking.Exchange.PaymentID.Hex()})
+		return
+	}
+
+	var fromShowtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": booking.ShowtimeID}).Decode(&fromShowtime); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+	if err := h.checkRefundPolicy(fromShowtime); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "exchange not allowed: " + err.Error()})
+		return
+	}
+
+	toShowtime := fromShowtime
+	if req.ShowtimeID != "" && req.ShowtimeID != fromShowtime.ID.Hex() {
+		toID, err := primitive.ObjectIDFromHex(req.ShowtimeID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+			return
+		}
+		if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": toID}).Decode(&toShowtime); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "target showtime not found"})
+			return
+		}
+		if toShowtime.MovieID != fromShowtime.MovieID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seats can only be exchanged for a showtime of the same movie"})
+			return
+		}
+		if !toShowtime.StartTime.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "target showtime has already started"})
+			return
+		}
+	}
+	sameShowtime := toShowtime.ID == fromShowtime.ID
+
+	// Within the same showtime, seats kept in both sets stay BOOKED untouched
+	old := make(map[string]bool, len(booking.Seats))
+	for _, seat := range booking.Seats {
+		old[seat] = true
+	}
+	requested := make(map[string]bool, len(req.Seats))
+	var added, removed []string
+	for _, seat := range req.Seats {
+		if requested[seat] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seat " + seat + " is listed twice"})
+			return
+		}
+		requested[seat] = true
+		if !sameShowtime || !old[seat] {
+			added = append(added, seat)
+		}
+	}
+	for _, seat := range booking.Seats {
+		if !sameShowtime || !requested[seat] {
+			removed = append(removed, seat)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new seats are the same as the current seats"})
+		return
+	}
+
+	if limit := h.purchaseLimits(toShowtime).MaxSeatsPerBooking; limit > 0 && len(req.Seats) > limit {
+		respondLockError(c, h.limitExceeded(toShowtime, userOID, "max_seats_per_booking", limit, len(req.Seats)), "")
+		return
+	}
+	if countsTowardLimits(booking, req.Seats, toShowtime.ID) {
+		moved := booking
+		moved.Seats = req.Seats
+		if err := h.checkPurchaseLimits(ctx, toShowtime, booking.UserID, &moved, 0); err != nil {
+			respondLockError(c, err, "failed to check purchase limits")
+			return
+		}
+	}
+	rulesRemoved := removed
+	if !sameShowtime {
+		rulesRemoved = nil
+	}
+	if err := h.checkSeatRules(ctx, toShowtime, added, rulesRemoved); err != nil {
+		respondLockError(c, err, "failed to validate seats")
+		return
+	}
+
+	// Seats kept within the same showtime keep the price they were quoted;
+	// new seats are quoted for the target showtime
+	var addedPrices []models.SeatPrice
+	if len(added) > 0 {
+		addedPrices, err = h.quoteSeats(ctx, toShowtime, added)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to price seats"})
+			return
+		}
+	}
+	quoted := make(map[string]models.SeatPrice, len(addedPrices))
+	for _, p := range addedPrices {
+		quoted[p.SeatCode] = p
+	}
+	newPrices := pricesFor(booking, req.Seats)
+	for i, p := range newPrices {
+		if q, ok := quoted[p.SeatCode]; ok {
+			newPrices[i] = q
+		}
+	}
+
+	oldAmount := bookingAmount(booking)
+	newAmount := pricing.Total(newPrices)
+	ex := models.PendingExchange{
+		ShowtimeID:  toShowtime.ID,
+		Seats:       req.Seats,
+		Added:       added,
+		Removed:     removed,
+		Prices:      newPrices,
+		Difference:  newAmount - oldAmount,
+		RequestedBy: userOID,
+	}
+
+	// A higher price is paid first; the seats move once the payment succeeds
+	if ex.Difference > 0 {
+		h.exchangeWithPayment(c, booking, ex)
+		return
+	}
+
+	// Keep other users from locking the new seats while we commit
+	toShowtimeIdStr := toShowtime.ID.Hex()
+	if len(added) > 0 {
+		conflicts, err := h.Redis.AcquireLocks(ctx, toShowtimeIdStr, added, userId, exchangeLockTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to acquire seat locks"})
+			return
+		}
+		if len(conflicts) > 0 {
+			respondLockError(c, &seatConflictError{Seats: conflicts, Reason: "already locked"}, "")
+			return
+		}
+		defer h.Redis.ReleaseLocks(ctx, toShowtimeIdStr, added, userId)
+	}
+
+	// A lower price is refunded against the original payment. The refund is
+	// recorded PENDING with the exchange and paid out once it commits.
+	refund, original, err := h.exchangeRefund(ctx, booking, ex)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load payment"})
+		return
+	}
+
+	if err := h.commitExchange(ctx, booking, ex, refund); err != nil {
+		respondExchangeError(c, err)
+		return
+	}
+	h.exchanged(ctx, booking, ex)
+
+	// The seats have moved; a difference the provider rejects is marked
+	// FAILED for someone to retry by hand, and one it cannot pay out at all
+	// is left MANUAL_REQUIRED until an admin confirms the transfer. The
+	// ledger only records refunds that were paid.
+	if refund != nil {
+		*refund, err = h.payOutRefund(ctx, original, *refund)
+		if err != nil {
+			log.Printf("Failed to refund exchange difference %s: %v", refund.ID.Hex(), err)
+			refund.Status = models.RefundStatusFailed
+			h.Mongo.Collection("refunds").UpdateOne(ctx,
+				bson.M{"_id": refund.ID, "status": models.RefundStatusPending},
+				bson.M{"$set": bson.M{"status": models.RefundStatusFailed}},
+			)
+		} else {
+			if err := h.completeRefund(ctx, *refund); err != nil {
+				log.Printf("Failed to record exchange refund %s: %v", refund.ID.Hex(), err)
+			}
+			if err := h.Mongo.PostJournalEntry(ctx, refundEntry(*refund, original)); err != nil {
+				log.Printf("Failed to post exchange refund %s to the ledger: %v", refund.ID.Hex(), err)
+			}
+		}
+	}
+
+	resp := exchangeResponse(booking, ex)
+	if refund != nil {
+		resp["refundId"] = refund.ID.Hex()
+		resp["refundStatus"] = refund.Status
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// exchangeRefund builds the refund of a lower exchange price against the
+// booking's original payment, and returns it with that payment. A promo
+// discount was never paid, so the refund is capped at what is left of the
+// net amount paid once earlier refunds are taken off. It returns a nil
+// refund when there is nothing to refund.
+func (h *BookingHandler) exchangeRefund(ctx context.Context, booking models.Booking, ex models.PendingExchange) (*models.Refund, models.Payment, error) {
+	var original models.Payment
+	if ex.Difference >= 0 || booking.PaymentID == nil {
+		return nil, original, nil
+	}
+	if err := h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": *booking.PaymentID}).Decode(&original); err != nil {
+		return nil, original, err
+	}
+	refunded, err := h.refundedAmounts(ctx, []primitive.ObjectID{original.ID})
+	if err != nil {
+		return nil, original, err
+	}
+	amount := min(-ex.Difference, original.Amount-refunded[original.ID])
+	if amount <= 0 {
+		return nil, original, nil
+	}
+
+	refundID := primitive.NewObjectID()
+	return &models.Refund{
+		ID:             refundID,
+		BookingID:      booking.ID,
+		PaymentID:      original.ID,
+		Amount:         amount,
+		Reason:         "seat exchange",
+		Status:         models.RefundStatusPending,
+		IdempotencyKey: "exchange:" + refundID.Hex(),
+		RequestedBy:    ex.RequestedBy,
+		CreatedAt:      time.Now(),
+	}, original, nil
+}
+
+func exchangeResponse(booking models.Booking, ex models.PendingExchange) gin.H {
+	return gin.H{
+		"bookingId":       booking.ID.Hex(),
+		"showtimeId":      ex.ShowtimeID.Hex(),
+		"seats":           ex.Seats,
+		"prices":          ex.Prices,
+		"priceDifference": ex.Difference,
+	}
+}
+
+func respondExchangeError(c *gin.Context, err error) {
+	if errors.Is(err, errBookingStateChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking is no longer in BOOKED state"})
+		return
+	}
+	respondLockError(c, err, "failed to exchange seats")
+}
+
+// exchangeWithPayment starts an exchange that costs more than the booking:
+// it holds the added seats for the booking until the hold runs out, charges
+// the difference as a new PENDING payment linked in payment_ids and records
+// the exchange on the booking. The seats are swapped by settleExchange once
+// the provider reports the payment.
+func (h *BookingHandler) exchangeWithPayment(c *gin.Context, booking models.Booking, ex models.PendingExchange) {
+	ctx := context.Background()
+	owner := ex.RequestedBy.Hex()
+	toShowtimeIdStr := ex.ShowtimeID.Hex()
+
+	conflicts, err := h.Redis.AcquireLocks(ctx, toShowtimeIdStr, ex.Added, owner, h.LockTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to acquire seat locks"})
+		return
+	}
+	if len(conflicts) > 0 {
+		respondLockError(c, &seatConflictError{Seats: conflicts, Reason: "already locked"}, "")
+		return
+	}
+
+	now := time.Now()
+	ex.ExpiresAt = now.Add(h.LockTTL)
+	payment := models.Payment{
+		ID:        primitive.NewObjectID(),
+		BookingID: booking.ID,
+		Amount:    ex.Difference,
+		Status:    models.PaymentStatusPending,
+		Method:    models.PaymentMethodCard,
+		Purpose:   models.PaymentPurposeExchange,
+		Provider:  h.Payments.Name(),
+		Transitions: []models.PaymentTransition{
+			{To: models.PaymentStatusPending, Reason: "exchange", At: now},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	ex.PaymentID = payment.ID
+
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if err := h.lockSeatReservations(sc, ex.ShowtimeID, ex.RequestedBy, booking.ID, ex.Added, ex.ExpiresAt); err != nil {
+			return err
+		}
+		if _, err := h.Mongo.Collection("payments").InsertOne(sc, payment); err != nil {
+			return err
+		}
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusBooked, "seats": booking.Seats, "exchange": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"exchange": ex, "updated_at": time.Now()}, "$push": bson.M{"payment_ids": payment.ID}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+		return nil
+	}, func(ctx context.Context) {
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "exchange.payment_id": payment.ID},
+			bson.M{"$unset": bson.M{"exchange": ""}, "$pull": bson.M{"payment_ids": payment.ID}},
+		)
+		h.Mongo.Collection("payments").DeleteOne(ctx, bson.M{"_id": payment.ID})
+		h.releaseSeatReservations(ctx, ex.ShowtimeID, booking.ID, ex.Added)
+	})
+	if err != nil {
+		h.Redis.ReleaseLocks(ctx, toShowtimeIdStr, ex.Added, owner)
+		respondExchangeError(c, err)
+		return
+	}
+
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: ex.ShowtimeID,
+		Seats:      ex.Added,
+		From:       models.SeatStateAvailable,
+		To:         models.SeatStateLocked,
+		Reason:     models.SeatChangeExchange,
+		Actor:      &ex.RequestedBy,
+		BookingID:  &booking.ID,
+	})
+	for _, seat := range ex.Added {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":           "SEAT_LOCKED",
+			"seatCode":       seat,
+			"lockedByUserId": owner,
+			"lockExpiresAt":  ex.ExpiresAt.Format(time.RFC3339),
+		})
+		h.Hub.BroadcastToRoom(toShowtimeIdStr, msg)
+	}
+
+	booking.Exchange = &ex
+	intent, err := h.Payments.CreateIntent(ctx, payments.IntentRequest{
+		Reference: payment.ID.Hex(),
+		BookingID: booking.ID.Hex(),
+		Amount:    ledger.FromMinor(payment.Amount),
+		Currency:  paymentCurrency,
+		ExpiresAt: ex.ExpiresAt,
+	})
+	if err != nil {
+		log.Printf("Payment %s: %s failed to create intent: %v", payment.ID.Hex(), h.Payments.Name(), err)
+		if _, err := settlePayment(ctx, h.Mongo, payment, models.PaymentStatusFailed, "", "provider_unavailable"); err != nil {
+			log.Printf("Failed to mark payment %s as failed: %v", payment.ID.Hex(), err)
+		}
+		payment.Status = models.PaymentStatusFailed
+		if err := h.settleExchange(ctx, booking, payment); err != nil {
+			log.Printf("Failed to cancel exchange of booking %s: %v", booking.ID.Hex(), err)
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider is unavailable, try again"})
+		return
+	}
+
+	payment.ProviderRef = intent.ID
+	if status, settled := paymentStatus(intent.Status); settled {
+		if _, err := settlePayment(ctx, h.Mongo, payment, status, intent.ID, intent.FailureReason); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payment"})
+			return
+		}
+		payment.Status = status
+		if err := h.settleExchange(ctx, booking, payment); err != nil {
+			// VoidAbandonedPayments finishes it once the hold runs out
+			log.Printf("Failed to settle exchange of booking %s: %v", booking.ID.Hex(), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to exchange seats"})
+			return
+		}
+	} else {
+		h.Mongo.Collection("payments").UpdateOne(ctx,
+			bson.M{"_id": payment.ID},
+			bson.M{"$set": bson.M{"provider_ref": intent.ID, "updated_at": time.Now()}},
+		)
+	}
+
+	resp := exchangeResponse(booking, ex)
+	resp["paymentId"] = payment.ID.Hex()
+	resp["paymentStatus"] = payment.Status
+	switch payment.Status {
+	case models.PaymentStatusPending:
+		resp["holdExpiresAt"] = ex.ExpiresAt.Format(time.RFC3339)
+		c.JSON(http.StatusAccepted, resp)
+	case models.PaymentStatusFailed:
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":     "payment was declined",
+			"paymentId": payment.ID.Hex(),
+			"status":    payment.Status,
+			"reason":    intent.FailureReason,
+		})
+	default:
+		var current models.Booking
+		err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": booking.ID}).Decode(&current)
+		if err != nil || current.Exchange != nil || !slices.Equal(current.Seats, ex.Seats) {
+			// The seats were lost and the payment refunded
+			c.JSON(http.StatusConflict, gin.H{"error": "seats are no longer available; the payment was refunded"})
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// settleExchange finishes the pending exchange of booking once its payment
+// is settled: a successful payment swaps the seats, a failed or expired one
+// releases the held seats. A payment that succeeded after the held seats
+// were lost, or once the exchange would break a purchase limit, is refunded.
+func (h *BookingHandler) settleExchange(ctx context.Context, booking models.Booking, payment models.Payment) error {
+	ex := *booking.Exchange
+	switch payment.Status {
+	case models.PaymentStatusSuccess:
+		err := h.commitExchange(ctx, booking, ex, nil)
+		if err == nil {
+			h.exchanged(ctx, booking, ex)
+			return nil
+		}
+		var conflict *seatConflictError
+		var rule *bookingRuleError
+		if !errors.As(err, &conflict) && !errors.As(err, &rule) && !errors.Is(err, errBookingStateChanged) {
+			return err
+		}
+		cancelled, err := h.cancelExchange(ctx, booking, ex)
+		if err != nil || !cancelled {
+			// Settled by a concurrent call
+			return err
+		}
+		booking.Exchange = nil
+		return h.voidPayment(ctx, booking, payment, "exchange_failed")
+
+	case models.PaymentStatusFailed, models.PaymentStatusExpired:
+		_, err := h.cancelExchange(ctx, booking, ex)
+		return err
+	}
+	return nil
+}
+
+// commitExchange moves booking onto the seats of ex in one transaction:
+// added seats become BOOKED for it, removed seats AVAILABLE, and the booking
+// takes the new showtime, seats and prices. Added seats are AVAILABLE, or
+// held LOCKED by the booking when ex waited for a payment, which clears the
+// pending exchange. refund, if any, is recorded alongside. A booking moving
+// to another showtime or growing is held to the purchase limits there.
+func (h *BookingHandler) commitExchange(ctx context.Context, booking models.Booking, ex models.PendingExchange, refund *models.Refund) error {
+	held := !ex.PaymentID.IsZero()
+	recheck := countsTowardLimits(booking, ex.Seats, ex.ShowtimeID)
+	moved := booking
+	moved.ShowtimeID = ex.ShowtimeID
+	moved.Seats = ex.Seats
+	var toShowtime models.Showtime
+	if recheck {
+		if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": ex.ShowtimeID}).Decode(&toShowtime); err != nil {
+			return err
+		}
+	}
+	payload := map[string]interface{}{
+		"from_showtime_id": booking.ShowtimeID.Hex(),
+		"from_seats":       booking.Seats,
+		"to_showtime_id":   ex.ShowtimeID.Hex(),
+		"to_seats":         ex.Seats,
+		"price_difference": ex.Difference,
+	}
+	if held {
+		payload["payment_id"] = ex.PaymentID.Hex()
+	}
+	auditLog := models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		EventType:  "SEAT_EXCHANGED",
+		UserID:     &ex.RequestedBy,
+		ShowtimeID: &ex.ShowtimeID,
+		BookingID:  &booking.ID,
+		Payload:    payload,
+		CreatedAt:  time.Now(),
+	}
+
+	bookingFilter := bson.M{"_id": booking.ID, "status": models.BookingStatusBooked, "seats": booking.Seats}
+	bookingUpdate := bson.M{"$set": bson.M{"showtime_id": ex.ShowtimeID, "seats": ex.Seats, "prices": ex.Prices, "updated_at": time.Now()}}
+	if held {
+		bookingFilter["exchange.payment_id"] = ex.PaymentID
+		bookingUpdate["$unset"] = bson.M{"exchange": ""}
+	}
+
+	var bookedAdded []string
+	return h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		bookedAdded = bookedAdded[:0]
+		for _, seat := range ex.Added {
+			filter := bson.M{"showtime_id": ex.ShowtimeID, "seat_code": seat, "state": models.SeatStateAvailable}
+			if held {
+				filter["state"] = models.SeatStateLocked
+				filter["booking_id"] = booking.ID
+			}
+			result, err := h.Mongo.Collection("seat_reservations").UpdateOne(sc, filter,
+				bson.M{"$set": bson.M{
+					"state":             models.SeatStateBooked,
+					"locked_by_user_id": ex.RequestedBy,
+					"lock_expires_at":   nil,
+					"booking_id":        booking.ID,
+					"updated_at":        time.Now(),
+				}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return &seatConflictError{Seats: []string{seat}, Reason: "not available"}
+			}
+			bookedAdded = append(bookedAdded, seat)
+		}
+
+		if len(ex.Removed) > 0 {
+			_, err := h.Mongo.Collection("seat_reservations").UpdateMany(sc,
+				bson.M{
+					"showtime_id": booking.ShowtimeID,
+					"seat_code":   bson.M{"$in": ex.Removed},
+					"booking_id":  booking.ID,
+					"state":       models.SeatStateBooked,
+				},
+				bson.M{"$set": bson.M{
+					"state":             models.SeatStateAvailable,
+					"locked_by_user_id": nil,
+					"lock_expires_at":   nil,
+					"booking_id":        nil,
+					"updated_at":        time.Now(),
+				}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc, bookingFilter, bookingUpdate)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+		if recheck {
+			if err := h.recheckPurchaseLimits(sc, toShowtime, booking.UserID, &moved, booking.ID, 0); err != nil {
+				return err
+			}
+		}
+
+		if refund != nil {
+			if _, err := h.Mongo.Collection("refunds").InsertOne(sc, refund); err != nil {
+				return err
+			}
+		}
+		_, err = h.Mongo.Collection("audit_logs").InsertOne(sc, auditLog)
+		return err
+	}, func(ctx context.Context) {
+		for _, seat := range bookedAdded {
+			revert := bson.M{
+				"state":             models.SeatStateAvailable,
+				"locked_by_user_id": nil,
+				"booking_id":        nil,
+				"updated_at":        time.Now(),
+			}
+			if held {
+				revert["state"] = models.SeatStateLocked
+				revert["locked_by_user_id"] = ex.RequestedBy
+				revert["lock_expires_at"] = ex.ExpiresAt
+				revert["booking_id"] = booking.ID
+			}
+			h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
+				bson.M{"showtime_id": ex.ShowtimeID, "seat_code": seat, "booking_id": booking.ID, "state": models.SeatStateBooked},
+				bson.M{"$set": revert},
+			)
+		}
+		for _, seat := range ex.Removed {
+			h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
+				bson.M{"showtime_id": booking.ShowtimeID, "seat_code": seat, "state": models.SeatStateAvailable},
+				bson.M{"$set": bson.M{
+					"state":             models.SeatStateBooked,
+					"locked_by_user_id": ex.RequestedBy,
+					"booking_id":        booking.ID,
+					"updated_at":        time.Now(),
+				}},
+			)
+		}
+		restore := bson.M{"showtime_id": booking.ShowtimeID, "seats": booking.Seats, "prices": booking.Prices, "updated_at": time.Now()}
+		if held {
+			restore["exchange"] = ex
+		}
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "seats": ex.Seats, "showtime_id": ex.ShowtimeID},
+			bson.M{"$set": restore},
+		)
+		if refund != nil {
+			h.Mongo.Collection("refunds").DeleteOne(ctx, bson.M{"_id": refund.ID})
+		}
+		h.Mongo.Collection("audit_logs").DeleteOne(ctx, bson.M{"_id": auditLog.ID})
+	})
+}
+
+// countsTowardLimits reports whether exchanging booking onto seats in the
+// showtime toID can take the user past a purchase limit: it moves the
+// booking to another showtime or adds seats to it.
+func countsTowardLimits(booking models.Booking, seats []string, toID primitive.ObjectID) bool {
+	return toID != booking.ShowtimeID || len(seats) > len(booking.Seats)
+}
+
+// exchanged records and broadcasts the seats commitExchange moved.
+func (h *BookingHandler) exchanged(ctx context.Context, booking models.Booking, ex models.PendingExchange) {
+	from := models.SeatStateAvailable
+	if !ex.PaymentID.IsZero() {
+		from = models.SeatStateLocked
+		h.Redis.ReleaseLocks(ctx, ex.ShowtimeID.Hex(), ex.Added, ex.RequestedBy.Hex())
+	}
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: ex.ShowtimeID,
+		Seats:      ex.Added,
+		From:       from,
+		To:         models.SeatStateBooked,
+		Reason:     models.SeatChangeExchange,
+		Actor:      &ex.RequestedBy,
+		BookingID:  &booking.ID,
+	})
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: booking.ShowtimeID,
+		Seats:      ex.Removed,
+		From:       models.SeatStateBooked,
+		To:         models.SeatStateAvailable,
+		Reason:     models.SeatChangeExchange,
+		Actor:      &ex.RequestedBy,
+		BookingID:  &booking.ID,
+	})
+
+	for _, seat := range ex.Added {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":     "SEAT_BOOKED",
+			"seatCode": seat,
+		})
+		h.Hub.BroadcastToRoom(ex.ShowtimeID.Hex(), msg)
+	}
+	for _, seat := range ex.Removed {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":     "SEAT_RELEASED",
+			"seatCode": seat,
+		})
+		h.Hub.BroadcastToRoom(booking.ShowtimeID.Hex(), msg)
+	}
+	if len(ex.Removed) > 0 {
+		h.seatsReleased(booking.ShowtimeID)
+	}
+}
+
+// cancelExchange drops the pending exchange ex of booking and releases the
+// seats held for it. It reports false when the exchange was no longer
+// pending, e.g. because a concurrent call settled it.
+func (h *BookingHandler) cancelExchange(ctx context.Context, booking models.Booking, ex models.PendingExchange) (bool, error) {
+	cancelled := false
+	err := h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		cancelled = false
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": booking.ID, "exchange.payment_id": ex.PaymentID},
+			bson.M{"$unset": bson.M{"exchange": ""}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil || result.MatchedCount == 0 {
+			return err
+		}
+		cancelled = true
+		return h.releaseSeatReservations(sc, ex.ShowtimeID, booking.ID, ex.Added)
+	}, func(ctx context.Context) {
+		if !cancelled {
+			return
+		}
+		h.Mongo.Collection("seat_reservations").UpdateMany(ctx,
+			bson.M{"showtime_id": ex.ShowtimeID, "seat_code": bson.M{"$in": ex.Added}, "state": models.SeatStateAvailable},
+			bson.M{"$set": bson.M{
+				"state":             models.SeatStateLocked,
+				"locked_by_user_id": ex.RequestedBy,
+				"lock_expires_at":   ex.ExpiresAt,
+				"booking_id":        booking.ID,
+				"updated_at":        time.Now(),
+			}},
+		)
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "exchange": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"exchange": ex}},
+		)
+	})
+	if err != nil || !cancelled {
+		return false, err
+	}
+
+	h.Redis.ReleaseLocks(ctx, ex.ShowtimeID.Hex(), ex.Added, ex.RequestedBy.Hex())
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: ex.ShowtimeID,
+		Seats:      ex.Added,
+		From:       models.SeatStateLocked,
+		To:         models.SeatStateAvailable,
+		Reason:     models.SeatChangeExchange,
+		BookingID:  &booking.ID,
+	})
+	for _, seat := range ex.Added {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":     "SEAT_RELEASED",
+			"seatCode": seat,
+		})
+		h.Hub.BroadcastToRoom(ex.ShowtimeID.Hex(), msg)
+	}
+	h.seatsReleased(ex.ShowtimeID)
+
+	h.Mongo.Collection("audit_logs").InsertOne(ctx, models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		EventType:  "SEAT_EXCHANGE_CANCELLED",
+		UserID:     &ex.RequestedBy,
+		ShowtimeID: &ex.ShowtimeID,
+		BookingID:  &booking.ID,
+		Payload: map[string]interface{}{
+			"payment_id": ex.PaymentID.Hex(),
+			"seats":      ex.Added,
+		},
+		CreatedAt: time.Now(),
+	})
+	return true, nil
+}
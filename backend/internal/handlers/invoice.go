@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/invoice"
+	"cinema-booking/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errNoInvoice       = errors.New("booking has no invoice")
+	errInvoiceReplaced = errors.New("a full tax invoice was already issued")
+)
+
+// newReceipt prices the abbreviated tax invoice of a booking paid with
+// payment. It is numbered when inserted.
+func (h *BookingHandler) newReceipt(ctx context.Context, booking models.Booking, payment models.Payment) (models.Invoice, error) {
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": booking.ShowtimeID}).Decode(&showtime); err != nil {
+		return models.Invoice{}, err
+	}
+	var movie models.Movie
+	if err := h.Mongo.Collection("movies").FindOne(ctx, bson.M{"_id": showtime.MovieID}).Decode(&movie); err != nil {
+		return models.Invoice{}, err
+	}
+
+	description := movie.Title + ", " + showtime.StartTime.In(h.PriceLocation).Format("2 Jan 2006 15:04")
+	return invoice.Build(booking, pricesFor(booking, booking.Seats), payment, h.Seller, description, h.VATRate), nil
+}
+
+// lockInvoiceSeries serializes the numbering of an invoice type when
+// transactions are unavailable; with transactions it does nothing. Hold it
+// around the whole WithTransaction that calls insertInvoice, rollback
+// included, so a number given back is always the last one taken.
+func (h *BookingHandler) lockInvoiceSeries(ctx context.Context, invoiceType string) (func(), error) {
+	if h.Mongo.SupportsTransactions {
+		return func() {}, nil
+	}
+	return h.Mongo.LockInvoiceSeries(ctx, invoice.Series(invoiceType))
+}
+
+// insertInvoice numbers inv and inserts it. Call it in a transaction so an
+// abort gives the number back, or under lockInvoiceSeries without
+// transactions.
+func (h *BookingHandler) insertInvoice(sc context.Context, inv *models.Invoice) error {
+	inv.IssuedAt = time.Now()
+	year := inv.IssuedAt.In(h.PriceLocation).Year()
+	series := invoice.Series(inv.Type)
+
+	if !h.Mongo.SupportsTransactions {
+		// A writer that died between taking a number and storing its
+		// invoice left the counter ahead
+		if err := h.Mongo.ResyncInvoiceSeq(sc, series, year, invoice.NumberPrefix(series, year)); err != nil {
+			return err
+		}
+	}
+	seq, err := h.Mongo.NextInvoiceSeq(sc, series, year)
+	if err != nil {
+		return err
+	}
+	inv.Seq = seq
+	inv.Number = invoice.FormatNumber(series, year, seq)
+	_, err = h.Mongo.Collection("invoices").InsertOne(sc, inv)
+	return err
+}
+
+// deleteInvoice undoes insertInvoice from a rollback when transactions are
+// unavailable.
+func (h *BookingHandler) deleteInvoice(ctx context.Context, inv models.Invoice) {
+	if inv.Seq == 0 {
+		return
+	}
+	h.Mongo.Collection("invoices").DeleteOne(ctx, bson.M{"_id": inv.ID})
+	h.Mongo.ReleaseInvoiceSeq(ctx, invoice.Series(inv.Type), inv.IssuedAt.In(h.PriceLocation).Year(), inv.Seq)
+}
+
+// currentInvoice returns the booking's full tax invoice if one was issued,
+// otherwise its abbreviated one. A BOOKED booking confirmed before invoices
+// were issued gets its abbreviated invoice now.
+func (h *BookingHandler) currentInvoice(ctx context.Context, booking models.Booking) (models.Invoice, error) {
+	var inv models.Invoice
+	err := h.Mongo.Collection("invoices").FindOne(ctx,
+		bson.M{"booking_id": booking.ID, "replaced_by": bson.M{"$exists": false}},
+		options.FindOne().SetSort(bson.D{{Key: "issued_at", Value: -1}}),
+	).Decode(&inv)
+	if err != mongo.ErrNoDocuments {
+		return inv, err
+	}
+	if booking.Status != models.BookingStatusBooked || booking.PaymentID == nil {
+		return inv, errNoInvoice
+	}
+
+	var payment models.Payment
+	if err := h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": *booking.PaymentID}).Decode(&payment); err != nil {
+		return inv, err
+	}
+	inv, err = h.newReceipt(ctx, booking, payment)
+	if err != nil {
+		return inv, err
+	}
+	unlock, err := h.lockInvoiceSeries(ctx, inv.Type)
+	if err != nil {
+		return inv, err
+	}
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		return h.insertInvoice(sc, &inv)
+	}, func(ctx context.Context) {
+		h.deleteInvoice(ctx, inv)
+	})
+	unlock()
+	if mongo.IsDuplicateKeyError(err) {
+		// Issued by a concurrent request
+		return h.currentInvoice(ctx, booking)
+	}
+	return inv, err
+}
+
+// GetInvoice downloads the booking's tax invoice as a PDF, or as JSON with
+// ?format=json.
+func (h *BookingHandler) GetInvoice(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	ctx := context.Background()
+	var booking models.Booking
+	if err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": bookingID}).Decode(&booking); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionReadBooking, authz.Booking(booking)) {
+		return
+	}
+
+	inv, err := h.currentInvoice(ctx, booking)
+	if err != nil {
+		if errors.Is(err, errNoInvoice) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue invoice"})
+		return
+	}
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, inv)
+		return
+	}
+	pdf, err := h.Invoices.PDF(inv)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render invoice"})
+		return
+	}
+	c.Header("Content-Disposition", `inline; filename="`+inv.Number+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+type TaxInvoiceRequest struct {
+	CompanyName string `json:"companyName" binding:"required"`
+	TaxID       string `json:"taxId" binding:"required"`
+	// Branch defaults to the head office, "00000".
+	Branch  string `json:"branch"`
+	Address string `json:"address" binding:"required"`
+}
+
+// RequestTaxInvoice issues a full tax invoice naming the buyer's company in
+// place of the booking's abbreviated one. Only one is issued per booking.
+func (h *BookingHandler) RequestTaxInvoice(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	var req TaxInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Branch == "" {
+		req.Branch = invoice.HeadOffice
+	}
+	if !invoice.ValidTaxID(req.TaxID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "taxId must be a valid 13-digit tax identification number"})
+		return
+	}
+	if !invoice.ValidBranch(req.Branch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "branch must be 5 digits"})
+		return
+	}
+
+	ctx := context.Background()
+	var booking models.Booking
+	if err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": bookingID}).Decode(&booking); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionRequestInvoice, authz.Booking(booking)) {
+		return
+	}
+	if booking.Status != models.BookingStatusBooked {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking is not in BOOKED state"})
+		return
+	}
+
+	receipt, err := h.currentInvoice(ctx, booking)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load invoice"})
+		return
+	}
+	if receipt.Type == models.InvoiceTypeFull {
+		c.JSON(http.StatusConflict, gin.H{"error": errInvoiceReplaced.Error(), "number": receipt.Number})
+		return
+	}
+
+	full := invoice.Full(receipt, models.InvoiceParty{
+		Name:    req.CompanyName,
+		TaxID:   req.TaxID,
+		Branch:  req.Branch,
+		Address: req.Address,
+	})
+	unlock, err := h.lockInvoiceSeries(ctx, full.Type)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "invoices are busy, please try again"})
+		return
+	}
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if err := h.insertInvoice(sc, &full); err != nil {
+			return err
+		}
+		result, err := h.Mongo.Collection("invoices").UpdateOne(sc,
+			bson.M{"_id": receipt.ID, "replaced_by": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"replaced_by": full.Number}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errInvoiceReplaced
+		}
+		return nil
+	}, func(ctx context.Context) {
+		h.Mongo.Collection("invoices").UpdateOne(ctx,
+			bson.M{"_id": receipt.ID, "replaced_by": full.Number},
+			bson.M{"$unset": bson.M{"replaced_by": ""}},
+		)
+		h.deleteInvoice(ctx, full)
+	})
+	unlock()
+	if err != nil {
+		if errors.Is(err, errInvoiceReplaced) || mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": errInvoiceReplaced.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue tax invoice"})
+		return
+	}
+
+	actor := subject(c).UserID
+	h.Mongo.Collection("audit_logs").InsertOne(ctx, models.AuditLog{
+		ID:        primitive.NewObjectID(),
+		EventType: "TAX_INVOICE_ISSUED",
+		UserID:    &actor,
+		BookingID: &booking.ID,
+		Payload: map[string]any{
+			"number":   full.Number,
+			"replaces": receipt.Number,
+			"tax_id":   req.TaxID,
+		},
+		CreatedAt: time.Now(),
+	})
+
+	c.JSON(http.StatusCreated, full)
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/ledger"
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetLedgerBalances returns the debit, credit and balance of each ledger
+// account in minor units, optionally for one account prefix and date range.
+func (h *AdminHandler) GetLedgerBalances(c *gin.Context) {
+	var from, to time.Time
+	if s := c.Query("date_from"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date_from must be YYYY-MM-DD"})
+			return
+		}
+		from = t
+	}
+	if s := c.Query("date_to"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date_to must be YYYY-MM-DD"})
+			return
+		}
+		to = t.Add(24 * time.Hour)
+	}
+
+	balances, err := h.Mongo.LedgerBalances(context.Background(), c.Query("account"), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute balances"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"currency": ledger.Currency, "balances": balances})
+}
+
+func (h *AdminHandler) ListLedgerEntries(c *gin.Context) {
+	ctx := context.Background()
+	filter := bson.M{}
+
+	if kind := c.Query("kind"); kind != "" {
+		filter["kind"] = kind
+	}
+	if account := c.Query("account"); account != "" {
+		filter["lines.account"] = account
+	}
+	if bookingID := c.Query("booking_id"); bookingID != "" {
+		if oid, err := primitive.ObjectIDFromHex(bookingID); err == nil {
+			filter["booking_id"] = oid
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(200)
+	cursor, err := h.Mongo.Collection("ledger_entries").Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch ledger entries"})
+		return
+	}
+	entries := []models.JournalEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+type LedgerEntryRequest struct {
+	Kind string `json:"kind" binding:"required"`
+	// Amount is in minor units (satang).
+	Amount    int64  `json:"amount" binding:"required,gt=0"`
+	Reference string `json:"reference" binding:"required"`
+	// Provider is the payment provider that charged a FEE.
+	Provider  string `json:"provider"`
+	BookingID string `json:"bookingId"`
+	Memo      string `json:"memo"`
+}
+
+// PostLedgerEntry records money that moves outside the booking flow: a
+// provider fee from its statement or a gift card redemption. Reference
+// makes the posting idempotent; posting it again answers 409.
+func (h *AdminHandler) PostLedgerEntry(c *gin.Context) {
+	var req LedgerEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entry models.JournalEntry
+	switch req.Kind {
+	case models.JournalFee:
+		if req.Provider == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "provider is required for a fee"})
+			return
+		}
+		entry = ledger.Fee(req.Provider, req.Reference, req.Amount)
+	case models.JournalGiftCardRedemption:
+		entry = ledger.GiftCardRedemption(req.Reference, req.Amount)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be FEE or GIFT_CARD_REDEMPTION"})
+		return
+	}
+	if req.BookingID != "" {
+		oid, err := primitive.ObjectIDFromHex(req.BookingID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+			return
+		}
+		entry.BookingID = &oid
+	}
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+	entry.ActorID = &userOID
+	entry.Memo = req.Memo
+
+	if err := h.Mongo.PostJournalEntry(context.Background(), entry); err != nil {
+		if errors.Is(err, services.ErrAlreadyPosted) {
+			c.JSON(http.StatusConflict, gin.H{"error": "an entry with this reference is already posted"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to post entry"})
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+// CheckLedger compares the ledger with the payments and refunds collections
+// and lists every disagreement.
+func (h *AdminHandler) CheckLedger(c *gin.Context) {
+	report, err := h.Mongo.CheckLedger(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ledger check failed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// BackfillLedger converts payment and refund amounts left in baht and posts
+// the entries missing for existing payments and refunds.
+func (h *AdminHandler) BackfillLedger(c *gin.Context) {
+	migrated, posted, err := h.Mongo.BackfillLedger(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "backfill failed: " + err.Error(), "migrated": migrated, "posted": posted})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"migrated": migrated, "posted": posted})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	myBookingsDefaultLimit = 20
+	myBookingsMaxLimit     = 100
+)
+
+// MyBooking is a booking joined with the details a user needs to recognise
+// it in a list.
+type MyBooking struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	ShowtimeID    primitive.ObjectID `bson:"showtime_id" json:"showtimeId"`
+	Seats         []string           `bson:"seats" json:"seats"`
+	Status        string             `bson:"status" json:"status"`
+	LockExpiresAt *time.Time         `bson:"lock_expires_at,omitempty" json:"lockExpiresAt,omitempty"`
+	MovieID       primitive.ObjectID `bson:"movie_id" json:"movieId"`
+	MovieTitle    string             `bson:"movie_title" json:"movieTitle"`
+	ShowtimeStart time.Time          `bson:"showtime_start" json:"showtimeStart"`
+	AuditoriumID  string             `bson:"auditorium_id" json:"auditoriumId"`
+	Amount        *int64             `bson:"amount,omitempty" json:"amount,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// ListMyBookings returns the caller's bookings, newest first. Optional query
+// parameters: status (comma separated), when (upcoming|past), limit and
+// cursor (the nextCursor of the previous page).
+func (h *BookingHandler) ListMyBookings(c *gin.Context) {
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	limit := myBookingsDefaultLimit
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, myBookingsMaxLimit)
+	}
+
+	// Served by the (user_id, created_at) index
+	match := bson.M{"user_id": userOID}
+	if s := c.Query("status"); s != "" {
+		match["status"] = bson.M{"$in": strings.Split(strings.ToUpper(s), ",")}
+	}
+	if s := c.Query("cursor"); s != "" {
+		createdAt, id, err := decodeBookingCursor(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		match["$or"] = []bson.M{
+			{"created_at": bson.M{"$lt": createdAt}},
+			{"created_at": createdAt, "_id": bson.M{"$lt": id}},
+		}
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{"$lookup": bson.M{"from": "showtimes", "localField": "showtime_id", "foreignField": "_id", "as": "showtime"}},
+		{"$unwind": "$showtime"},
+	}
+	switch c.Query("when") {
+	case "":
+	case "upcoming":
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"showtime.start_time": bson.M{"$gt": time.Now()}}})
+	case "past":
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"showtime.start_time": bson.M{"$lte": time.Now()}}})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "when must be upcoming or past"})
+		return
+	}
+	pipeline = append(pipeline,
+		bson.M{"$limit": limit + 1},
+		bson.M{"$lookup": bson.M{"from": "movies", "localField": "showtime.movie_id", "foreignField": "_id", "as": "movie"}},
+		bson.M{"$lookup": bson.M{"from": "payments", "localField": "payment_id", "foreignField": "_id", "as": "payment"}},
+		bson.M{"$project": bson.M{
+			"showtime_id":     1,
+			"seats":           1,
+			"status":          1,
+			"lock_expires_at": 1,
+			"created_at":      1,
+			"movie_id":        "$showtime.movie_id",
+			"showtime_start":  "$showtime.start_time",
+			"auditorium_id":   "$showtime.auditorium_id",
+			"movie_title":     bson.M{"$first": "$movie.title"},
+			"amount":          bson.M{"$first": "$payment.amount"},
+		}},
+	)
+
+	ctx := context.Background()
+	cursor, err := h.Mongo.Collection("bookings").Aggregate(ctx, pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch bookings"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	bookings := []MyBooking{}
+	if err := cursor.All(ctx, &bookings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+
+	resp := gin.H{"bookings": bookings}
+	if len(bookings) > limit {
+		bookings = bookings[:limit]
+		last := bookings[limit-1]
+		resp["bookings"] = bookings
+		resp["nextCursor"] = encodeBookingCursor(last.CreatedAt, last.ID)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Cursors are opaque to clients: "<created_at unix ms>:<booking id>" in
+// URL-safe base64.
+func encodeBookingCursor(createdAt time.Time, id primitive.ObjectID) string {
+	raw := fmt.Sprintf("%d:%s", createdAt.UnixMilli(), id.Hex())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeBookingCursor(s string) (time.Time, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, err
+	}
+	ms, hex, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, primitive.NilObjectID, errors.New("malformed cursor")
+	}
+	millis, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, err
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, err
+	}
+	return time.UnixMilli(millis), id, nil
+}
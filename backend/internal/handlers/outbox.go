@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/mq"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newOutboxMessage wraps event for the outbox. Insert it in the same
+// transaction as the change the event describes; the outbox relay publishes
+// it after commit.
+func newOutboxMessage(event mq.BookingEvent) (models.OutboxMessage, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	now := time.Now()
+	return models.OutboxMessage{
+		ID:            primitive.NewObjectID(),
+		EventID:       event.EventID,
+		EventType:     event.EventType,
+		Payload:       payload,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
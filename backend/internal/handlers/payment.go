@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/ledger"
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/payments"
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// paymentCurrency is what every booking is charged in.
+const paymentCurrency = "THB"
+
+// maxWebhookBody bounds how much of a webhook request is read.
+const maxWebhookBody = 64 << 10
+
+// voidBatchSize bounds how many abandoned payments one sweep handles.
+const voidBatchSize = 100
+
+type PaymentHandler struct {
+	Mongo    *services.MongoService
+	Provider payments.Provider
+
+	// Bookings completes seat exchanges once their payment is settled and
+	// refunds payments that succeed after their booking was given up.
+	Bookings *BookingHandler
+}
+
+// Webhook receives the provider's asynchronous payment results. It answers
+// 2xx once the event is handled, including repeats of an event already
+// applied, so the provider stops retrying.
+func (h *PaymentHandler) Webhook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+
+	event, err := h.Provider.VerifyWebhook(c.Request.Header, body)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrNotSupported):
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhooks are not enabled"})
+		case errors.Is(err, payments.ErrInvalidSignature):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook body"})
+		}
+		return
+	}
+
+	status, ok := paymentStatus(event.Status)
+	if !ok {
+		// Captures and refunds are initiated by us; nothing to update
+		c.JSON(http.StatusOK, gin.H{"status": "ignored"})
+		return
+	}
+
+	paymentID, err := primitive.ObjectIDFromHex(event.Reference)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payment reference"})
+		return
+	}
+
+	ctx := context.Background()
+	var payment models.Payment
+	err = h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": paymentID, "provider": h.Provider.Name()}).Decode(&payment)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	if event.Amount > 0 && ledger.ToMinor(event.Amount) != payment.Amount {
+		log.Printf("Payment %s: %s reported %.2f paid, expected %.2f", paymentID.Hex(), h.Provider.Name(), event.Amount, ledger.FromMinor(payment.Amount))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "amount does not match the payment"})
+		return
+	}
+
+	settled, err := settlePayment(ctx, h.Mongo, payment, status, event.IntentID, event.FailureReason)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payment"})
+		return
+	}
+	if !settled {
+		if payment.Status != status {
+			log.Printf("Payment %s: ignoring %s from %s, payment is %s", paymentID.Hex(), event.Status, h.Provider.Name(), payment.Status)
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
+
+	eventType := "PAYMENT_SUCCEEDED"
+	if status == models.PaymentStatusFailed {
+		eventType = "PAYMENT_FAILED"
+	}
+	h.Mongo.Collection("audit_logs").InsertOne(ctx, models.AuditLog{
+		ID:        primitive.NewObjectID(),
+		EventType: eventType,
+		BookingID: &payment.BookingID,
+		Payload: map[string]any{
+			"payment_id":     paymentID.Hex(),
+			"provider":       h.Provider.Name(),
+			"intent_id":      event.IntentID,
+			"event_id":       event.ID,
+			"failure_reason": event.FailureReason,
+		},
+		CreatedAt: time.Now(),
+	})
+
+	payment.Status = status
+	payment.ProviderRef = event.IntentID
+	if err := h.Bookings.paymentSettled(ctx, payment); err != nil {
+		// VoidAbandonedPayments retries it
+		log.Printf("Payment %s: failed to follow up %s: %v", paymentID.Hex(), status, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+// paymentStatus maps a provider's authorization result to a payment status.
+func paymentStatus(intentStatus string) (string, bool) {
+	switch intentStatus {
+	case payments.IntentSucceeded:
+		return models.PaymentStatusSuccess, true
+	case payments.IntentFailed:
+		return models.PaymentStatusFailed, true
+	}
+	return "", false
+}
+
+var errPaymentStateChanged = errors.New("payment status changed")
+
+// settlePayment applies a provider's result to payment: PENDING (or EXPIRED,
+// for a late success) to SUCCESS, or PENDING to FAILED. A failed payment is
+// unlinked from its booking so the user can pay again. It reports false when
+// the payment was no longer in the status it was loaded with, or the state
+// machine does not allow the move (a repeated or contradicting result).
+func settlePayment(ctx context.Context, db *services.MongoService, payment models.Payment, status, intentID, reason string) (bool, error) {
+	if !models.CanTransitionPayment(payment.Status, status) {
+		return false, nil
+	}
+
+	set := bson.M{}
+	if intentID != "" {
+		set["provider_ref"] = intentID
+	}
+	if reason != "" {
+		set["failure_reason"] = reason
+	}
+	transitionReason := "provider"
+	if payment.Status == models.PaymentStatusExpired {
+		transitionReason = "late_success"
+	}
+	charge := ledger.Charge(payment)
+
+	settled, unlinked := false, false
+	err := db.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		settled, unlinked = false, false
+		moved, err := db.TransitionPayment(sc, payment.ID, payment.Status, status, transitionReason, set)
+		if err != nil || !moved {
+			return err
+		}
+		settled = true
+		if status == models.PaymentStatusSuccess {
+			return db.PostJournalEntry(sc, charge)
+		}
+
+		result, err := db.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": payment.BookingID, "payment_id": payment.ID},
+			bson.M{"$unset": bson.M{"payment_id": ""}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		unlinked = err == nil && result.MatchedCount > 0
+		return err
+	}, func(ctx context.Context) {
+		db.DeleteJournalEntry(ctx, charge.ID)
+		if unlinked {
+			db.Collection("bookings").UpdateOne(ctx,
+				bson.M{"_id": payment.BookingID, "payment_id": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"payment_id": payment.ID}},
+			)
+		}
+		if settled {
+			db.RevertPaymentTransition(ctx, payment.ID, payment.Status, status)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	return settled, nil
+}
+
+// paymentSettled follows up a payment the provider has just settled. The
+// payment of a pending seat exchange completes or abandons it; any other
+// payment that succeeded after its booking expired or was cancelled, or
+// after the payment itself had been given up, is refunded.
+func (h *BookingHandler) paymentSettled(ctx context.Context, payment models.Payment) error {
+	var booking models.Booking
+	if err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": payment.BookingID}).Decode(&booking); err != nil {
+		return err
+	}
+	if booking.Exchange != nil && booking.Exchange.PaymentID == payment.ID {
+		return h.settleExchange(ctx, booking, payment)
+	}
+	if payment.Status != models.PaymentStatusSuccess {
+		return nil
+	}
+	live := booking.Status == models.BookingStatusLocked || booking.Status == models.BookingStatusBooked ||
+		booking.Status == models.BookingStatusRefunding
+	if live && booking.PaymentID != nil && *booking.PaymentID == payment.ID {
+		return nil
+	}
+	return h.voidPayment(ctx, booking, payment, "late_success")
+}
+
+// VoidAbandonedPayments settles the payments of bookings that will never be
+// confirmed: bookings that expired or were cancelled while a payment was
+// linked, and payments that succeeded after being given up. It also ends
+// seat exchanges whose payment did not arrive while the seats were held. It
+// is run by the timeout worker and reports how many payments it handled.
+func (h *BookingHandler) VoidAbandonedPayments(ctx context.Context) (int, error) {
+	opts := options.Find().SetLimit(voidBatchSize)
+	cursor, err := h.Mongo.Collection("bookings").Find(ctx, bson.M{
+		"status":     bson.M{"$in": []string{models.BookingStatusExpired, models.BookingStatusCancelled}},
+		"payment_id": bson.M{"$exists": true},
+	}, opts)
+	if err != nil {
+		return 0, err
+	}
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return 0, err
+	}
+
+	handled := 0
+	for _, booking := range bookings {
+		var payment models.Payment
+		if err := h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": *booking.PaymentID}).Decode(&payment); err != nil {
+			log.Printf("Booking %s: failed to load payment: %v", booking.ID.Hex(), err)
+			continue
+		}
+		reason := "booking_" + strings.ToLower(booking.Status)
+		if err := h.voidPayment(ctx, booking, payment, reason); err != nil {
+			log.Printf("Failed to void payment %s: %v", payment.ID.Hex(), err)
+			continue
+		}
+		handled++
+	}
+
+	// Late successes whose refund failed when the webhook arrived
+	cursor, err = h.Mongo.Collection("payments").Find(ctx, bson.M{
+		"status":             models.PaymentStatusSuccess,
+		"transitions.reason": "late_success",
+	}, opts)
+	if err != nil {
+		return handled, err
+	}
+	var late []models.Payment
+	if err := cursor.All(ctx, &late); err != nil {
+		return handled, err
+	}
+	for _, payment := range late {
+		var booking models.Booking
+		if err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": payment.BookingID}).Decode(&booking); err != nil {
+			log.Printf("Payment %s: failed to load booking: %v", payment.ID.Hex(), err)
+			continue
+		}
+		if err := h.voidPayment(ctx, booking, payment, "late_success"); err != nil {
+			log.Printf("Failed to refund late payment %s: %v", payment.ID.Hex(), err)
+			continue
+		}
+		handled++
+	}
+
+	// Exchanges whose hold ran out: a payment still PENDING expires and the
+	// held seats are released
+	cursor, err = h.Mongo.Collection("bookings").Find(ctx, bson.M{
+		"status":              models.BookingStatusBooked,
+		"exchange.expires_at": bson.M{"$lt": time.Now()},
+	}, opts)
+	if err != nil {
+		return handled, err
+	}
+	var exchanging []models.Booking
+	if err := cursor.All(ctx, &exchanging); err != nil {
+		return handled, err
+	}
+	for _, booking := range exchanging {
+		paymentID := booking.Exchange.PaymentID
+		var payment models.Payment
+		if err := h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": paymentID}).Decode(&payment); err != nil {
+			log.Printf("Booking %s: failed to load exchange payment: %v", booking.ID.Hex(), err)
+			continue
+		}
+		if payment.Status == models.PaymentStatusPending {
+			moved, err := h.Mongo.TransitionPayment(ctx, paymentID, models.PaymentStatusPending, models.PaymentStatusExpired, "exchange_expired", nil)
+			if err != nil {
+				log.Printf("Failed to expire exchange payment %s: %v", paymentID.Hex(), err)
+				continue
+			}
+			if !moved {
+				// Settled in the meantime; the next sweep sees the new status
+				continue
+			}
+			payment.Status = models.PaymentStatusExpired
+		}
+		if err := h.settleExchange(ctx, booking, payment); err != nil {
+			log.Printf("Failed to end exchange of booking %s: %v", booking.ID.Hex(), err)
+			continue
+		}
+		handled++
+	}
+	return handled, nil
+}
+
+// voidPayment gives up a payment whose booking will not be confirmed. A
+// PENDING payment expires; a successful one is refunded in full through its
+// provider. The payment is then unlinked from the booking.
+func (h *BookingHandler) voidPayment(ctx context.Context, booking models.Booking, payment models.Payment, reason string) error {
+	from := payment.Status
+	var refunded int64
+	var refundStatus string
+	switch payment.Status {
+	case models.PaymentStatusPending:
+		moved, err := h.Mongo.TransitionPayment(ctx, payment.ID, models.PaymentStatusPending, models.PaymentStatusExpired, reason, nil)
+		if err != nil {
+			return err
+		}
+		if !moved {
+			// Settled in the meantime; the next sweep sees the new status
+			return errPaymentStateChanged
+		}
+
+	case models.PaymentStatusSuccess:
+		refund, err := h.startRefund(ctx, models.Refund{
+			ID:             primitive.NewObjectID(),
+			BookingID:      booking.ID,
+			PaymentID:      payment.ID,
+			Amount:         payment.Amount,
+			Reason:         reason,
+			IdempotencyKey: fullRefundKey(payment.ID),
+			CreatedAt:      time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		refund, err = h.payOutRefund(ctx, payment, refund)
+		if err != nil {
+			return fmt.Errorf("provider refund: %w", err)
+		}
+		entry := refundEntry(refund, payment)
+		moved := false
+		err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+			if err := h.completeRefund(sc, refund); err != nil {
+				return err
+			}
+			var err error
+			moved, err = h.Mongo.TransitionPayment(sc, payment.ID, models.PaymentStatusSuccess, models.PaymentStatusRefunded, reason, nil)
+			if err != nil {
+				return err
+			}
+			if !moved {
+				return errPaymentStateChanged
+			}
+			return h.Mongo.PostJournalEntry(sc, entry)
+		}, func(ctx context.Context) {
+			h.Mongo.DeleteJournalEntry(ctx, entry.ID)
+			h.reopenRefund(ctx, refund.ID)
+			if moved {
+				h.Mongo.RevertPaymentTransition(ctx, payment.ID, models.PaymentStatusSuccess, models.PaymentStatusRefunded)
+			}
+		})
+		if err != nil {
+			log.Printf("Payment %s was refunded by %s but not recorded: %v", payment.ID.Hex(), payment.Provider, err)
+			return err
+		}
+		refunded, refundStatus = refund.Amount, refund.Status
+	}
+
+	h.Mongo.Collection("bookings").UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "payment_id": payment.ID},
+		bson.M{"$unset": bson.M{"payment_id": ""}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+
+	if from == models.PaymentStatusPending || from == models.PaymentStatusSuccess {
+		h.Mongo.Collection("audit_logs").InsertOne(ctx, models.AuditLog{
+			ID:        primitive.NewObjectID(),
+			EventType: "PAYMENT_VOIDED",
+			UserID:    &booking.UserID,
+			BookingID: &booking.ID,
+			Payload: map[string]any{
+				"payment_id":    payment.ID.Hex(),
+				"from":          from,
+				"reason":        reason,
+				"refunded":      refunded,
+				"refund_status": refundStatus,
+			},
+			CreatedAt: time.Now(),
+		})
+	}
+	return nil
+}
+
+// providerFor returns the provider that took payment. MOCK payments, such as
+// those made before a provider was configured, are always settled by the
+// mock.
+func (h *BookingHandler) providerFor(payment models.Payment) (payments.Provider, error) {
+	switch payment.Provider {
+	case h.Payments.Name():
+		return h.Payments, nil
+	case payments.MockProvider{}.Name():
+		return payments.MockProvider{}, nil
+	}
+	if h.PromptPay != nil && payment.Provider == h.PromptPay.Name() {
+		return h.PromptPay, nil
+	}
+	return nil, fmt.Errorf("payment %s was taken by %s, which is not configured", payment.ID.Hex(), payment.Provider)
+}
+
+// refundPayment pays out refund, recorded by startRefund, through the
+// provider that took payment.
+func (h *BookingHandler) refundPayment(ctx context.Context, payment models.Payment, refund models.Refund) error {
+	provider, err := h.providerFor(payment)
+	if err != nil {
+		return err
+	}
+	return provider.Refund(ctx, payment.ProviderRef, ledger.FromMinor(refund.Amount), refund.IdempotencyKey)
+}
+
+// capturePayment captures the payment of a booking that was just confirmed.
+// The booking is already BOOKED, so a failure is only logged; the
+// authorization stays with the provider and can be captured there.
+func (h *BookingHandler) capturePayment(ctx context.Context, payment models.Payment) {
+	provider, err := h.providerFor(payment)
+	if err == nil {
+		err = provider.Capture(ctx, payment.ProviderRef)
+	}
+	if err != nil {
+		log.Printf("Failed to capture payment %s: %v", payment.ID.Hex(), err)
+		return
+	}
+
+	now := time.Now()
+	h.Mongo.Collection("payments").UpdateOne(ctx,
+		bson.M{"_id": payment.ID},
+		bson.M{"$set": bson.M{"captured_at": now, "updated_at": now}},
+	)
+}
+
+// GetPayment returns the booking's current payment, for clients waiting on
+// an asynchronous provider.
+func (h *BookingHandler) GetPayment(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	ctx := context.Background()
+	var booking models.Booking
+	if err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": bookingID}).Decode(&booking); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionReadBooking, authz.Booking(booking)) {
+		return
+	}
+
+	// A failed payment is unlinked from the booking; report the latest one
+	filter := bson.M{"booking_id": bookingID}
+	if booking.PaymentID != nil {
+		filter = bson.M{"_id": *booking.PaymentID}
+	}
+	var payment models.Payment
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if err := h.Mongo.Collection("payments").FindOne(ctx, filter, opts).Decode(&payment); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, payment)
+}
+
+// ListPayments returns every payment attempt made for a booking, oldest
+// first, each with its status history.
+func (h *BookingHandler) ListPayments(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	ctx := context.Background()
+	var booking models.Booking
+	if err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": bookingID}).Decode(&booking); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionReadBooking, authz.Booking(booking)) {
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := h.Mongo.Collection("payments").Find(ctx, bson.M{"booking_id": bookingID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch payments"})
+		return
+	}
+	attempts := []models.Payment{}
+	if err := cursor.All(ctx, &attempts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode payments"})
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// qrImageSize is the width in pixels of payment QR codes.
+const qrImageSize = 512
+
+// GetPaymentQR renders the QR code of the booking's pending PromptPay
+// payment as a PNG. The code stops being served when the seat hold ends.
+func (h *BookingHandler) GetPaymentQR(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	ctx := context.Background()
+	var booking models.Booking
+	if err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": bookingID}).Decode(&booking); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionReadBooking, authz.Booking(booking)) {
+		return
+	}
+	if booking.PaymentID == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking has no payment"})
+		return
+	}
+
+	var payment models.Payment
+	if err := h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": *booking.PaymentID}).Decode(&payment); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	if payment.QRPayload == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment has no QR code"})
+		return
+	}
+	if booking.Status != models.BookingStatusLocked || payment.Status != models.PaymentStatusPending ||
+		(booking.LockExpiresAt != nil && booking.LockExpiresAt.Before(time.Now())) {
+		c.JSON(http.StatusGone, gin.H{"error": "QR code has expired"})
+		return
+	}
+
+	png, err := payments.QRCodePNG(payment.QRPayload, qrImageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render QR code"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/pricing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// quoteSeats prices seats for a showtime from the active price lists and the
+// movie's surcharges.
+func (h *BookingHandler) quoteSeats(ctx context.Context, showtime models.Showtime, seats []string) ([]models.SeatPrice, error) {
+	seatmap, err := h.loadSeatmap(ctx, showtime)
+	if err != nil {
+		return nil, err
+	}
+	seatTypes := make(map[string]string)
+	for _, row := range seatmap.Rows {
+		for _, seat := range row.Seats {
+			seatTypes[seat.SeatCode] = seat.Type
+		}
+	}
+
+	var movie models.Movie
+	if err := h.Mongo.Collection("movies").FindOne(ctx, bson.M{"_id": showtime.MovieID}).Decode(&movie); err != nil {
+		return nil, err
+	}
+
+	cursor, err := h.Mongo.Collection("price_lists").Find(ctx, bson.M{
+		"active": true,
+		"$or": []bson.M{
+			{"showtime_id": showtime.ID},
+			{"showtime_id": bson.M{"$exists": false}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var lists []models.PriceList
+	if err := cursor.All(ctx, &lists); err != nil {
+		return nil, err
+	}
+
+	return pricing.Quote(showtime, movie, seatTypes, lists, seats, h.PriceLocation), nil
+}
+
+// pricesFor returns the quoted prices of seats in booking. Seats without a
+// quote (bookings made before pricing existed) cost pricing.DefaultPrice.
+func pricesFor(booking models.Booking, seats []string) []models.SeatPrice {
+	quoted := make(map[string]models.SeatPrice, len(booking.Prices))
+	for _, p := range booking.Prices {
+		quoted[p.SeatCode] = p
+	}
+
+	prices := make([]models.SeatPrice, 0, len(seats))
+	for _, seat := range seats {
+		p, ok := quoted[seat]
+		if !ok {
+			p = models.SeatPrice{SeatCode: seat, Base: pricing.DefaultPrice, Price: pricing.DefaultPrice}
+		}
+		prices = append(prices, p)
+	}
+	return prices
+}
+
+// bookingAmount is the price in satang of the seats the booking currently
+// holds.
+func bookingAmount(booking models.Booking) int64 {
+	return pricing.Total(pricesFor(booking, booking.Seats))
+}
+
+type SurchargesRequest struct {
+	Surcharges []models.Surcharge `json:"surcharges"`
+}
+
+func (h *AdminHandler) ListPriceLists(c *gin.Context) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := h.Mongo.Collection("price_lists").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch price lists"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	lists := []models.PriceList{}
+	if err := cursor.All(ctx, &lists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"priceLists": lists})
+}
+
+func (h *AdminHandler) CreatePriceList(c *gin.Context) {
+	var list models.PriceList
+	if err := c.ShouldBindJSON(&list); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := pricing.ValidatePriceList(list); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list.ID = primitive.NewObjectID()
+	list.CreatedAt = time.Now()
+	list.UpdatedAt = time.Now()
+	if _, err := h.Mongo.Collection("price_lists").InsertOne(context.Background(), list); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create price list"})
+		return
+	}
+	c.JSON(http.StatusCreated, list)
+}
+
+func (h *AdminHandler) UpdatePriceList(c *gin.Context) {
+	listID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid price list id"})
+		return
+	}
+
+	var list models.PriceList
+	if err := c.ShouldBindJSON(&list); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := pricing.ValidatePriceList(list); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	var existing models.PriceList
+	if err := h.Mongo.Collection("price_lists").FindOne(ctx, bson.M{"_id": listID}).Decode(&existing); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "price list not found"})
+		return
+	}
+
+	// Bookings keep the prices they were quoted; only new locks see this
+	list.ID = listID
+	list.CreatedAt = existing.CreatedAt
+	list.UpdatedAt = time.Now()
+	if _, err := h.Mongo.Collection("price_lists").ReplaceOne(ctx, bson.M{"_id": listID}, list); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update price list"})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (h *AdminHandler) DeletePriceList(c *gin.Context) {
+	listID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid price list id"})
+		return
+	}
+
+	result, err := h.Mongo.Collection("price_lists").DeleteOne(context.Background(), bson.M{"_id": listID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete price list"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "price list not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": listID.Hex()})
+}
+
+// SetMovieSurcharges replaces a movie's surcharges. An empty list removes
+// them.
+func (h *AdminHandler) SetMovieSurcharges(c *gin.Context) {
+	movieID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid movie id"})
+		return
+	}
+
+	var req SurchargesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, s := range req.Surcharges {
+		if s.Name == "" || s.Amount < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "each surcharge needs a name and a non-negative amount"})
+			return
+		}
+	}
+
+	update := bson.M{"$set": bson.M{"surcharges": req.Surcharges}}
+	if len(req.Surcharges) == 0 {
+		update = bson.M{"$unset": bson.M{"surcharges": ""}}
+	}
+
+	result, err := h.Mongo.Collection("movies").UpdateOne(context.Background(), bson.M{"_id": movieID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update movie"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "movie not found"})
+		return
+	}
+
+	if req.Surcharges == nil {
+		req.Surcharges = []models.Surcharge{}
+	}
+	c.JSON(http.StatusOK, gin.H{"movieId": movieID.Hex(), "surcharges": req.Surcharges})
+}
@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/pricing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ruleCodePromoInvalid     = "PROMO_INVALID"
+	ruleCodePromoUnavailable = "PROMO_UNAVAILABLE"
+)
+
+// errPromoUnavailable is returned at confirmation when the code's usage caps
+// were used up by other bookings, or its validity window closed, after it
+// was applied.
+var errPromoUnavailable = errors.New("promo code is no longer available")
+
+type ApplyPromoRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func promoRuleError(message string) error {
+	return &bookingRuleError{Code: ruleCodePromoInvalid, Message: message}
+}
+
+// ApplyPromo attaches a code to a LOCKED, unpaid booking and returns the
+// amount Pay will charge.
+func (h *BookingHandler) ApplyPromo(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	var req ApplyPromoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	booking, ok := h.loadPromoBooking(c, ctx, bookingID)
+	if !ok {
+		return
+	}
+
+	var promo models.Promotion
+	err = h.Mongo.Collection("promotions").FindOne(ctx, bson.M{"code": strings.ToUpper(strings.TrimSpace(req.Code))}).Decode(&promo)
+	if err != nil {
+		respondLockError(c, promoRuleError("promo code not found"), "")
+		return
+	}
+
+	discount, err := h.promoDiscount(ctx, promo, booking)
+	if err != nil {
+		respondLockError(c, err, "failed to apply promo code")
+		return
+	}
+
+	applied := models.AppliedPromo{PromotionID: promo.ID, Code: promo.Code, Discount: discount}
+	result, err := h.Mongo.Collection("bookings").UpdateOne(ctx,
+		bson.M{"_id": bookingID, "status": models.BookingStatusLocked, "payment_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"promo": applied, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply promo code"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking changed, try again"})
+		return
+	}
+
+	subtotal := bookingAmount(booking)
+	c.JSON(http.StatusOK, gin.H{
+		"bookingId": bookingID.Hex(),
+		"promo":     applied,
+		"subtotal":  subtotal,
+		"amount":    subtotal - discount,
+	})
+}
+
+func (h *BookingHandler) RemovePromo(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	ctx := context.Background()
+	booking, ok := h.loadPromoBooking(c, ctx, bookingID)
+	if !ok {
+		return
+	}
+
+	_, err = h.Mongo.Collection("bookings").UpdateOne(ctx,
+		bson.M{"_id": bookingID, "status": models.BookingStatusLocked, "payment_id": bson.M{"$exists": false}},
+		bson.M{"$unset": bson.M{"promo": ""}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove promo code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"bookingId": bookingID.Hex(), "amount": bookingAmount(booking)})
+}
+
+// loadPromoBooking loads a booking whose promo code may still change: LOCKED,
+// not expired and not yet paid. It writes the error response itself.
+func (h *BookingHandler) loadPromoBooking(c *gin.Context, ctx context.Context, bookingID primitive.ObjectID) (models.Booking, bool) {
+	var booking models.Booking
+	err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    bookingID,
+		"status": models.BookingStatusLocked,
+	}).Decode(&booking)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in LOCKED state"})
+		return booking, false
+	}
+	if !authorize(c, h.Authz, authz.ActionApplyPromo, authz.Booking(booking)) {
+		return booking, false
+	}
+	if booking.LockExpiresAt == nil || booking.LockExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock has expired"})
+		return booking, false
+	}
+	if booking.PaymentID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot change promo code after payment"})
+		return booking, false
+	}
+	return booking, true
+}
+
+// promoDiscount checks that promo can be used on booking and returns the
+// discount. The usage caps are checked here only as a courtesy; they are
+// enforced atomically by redeemPromo when the booking is confirmed.
+func (h *BookingHandler) promoDiscount(ctx context.Context, promo models.Promotion, booking models.Booking) (int64, error) {
+	now := time.Now()
+	if !promo.Active {
+		return 0, promoRuleError("promo code is not active")
+	}
+	if promo.ValidFrom != nil && now.Before(*promo.ValidFrom) {
+		return 0, promoRuleError("promo code is not valid yet")
+	}
+	if promo.ValidUntil != nil && !now.Before(*promo.ValidUntil) {
+		return 0, promoRuleError("promo code has expired")
+	}
+
+	if len(promo.ShowtimeIDs) > 0 && !slices.Contains(promo.ShowtimeIDs, booking.ShowtimeID) {
+		return 0, promoRuleError("promo code is not valid for this showtime")
+	}
+	if len(promo.MovieIDs) > 0 {
+		var showtime models.Showtime
+		if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": booking.ShowtimeID}).Decode(&showtime); err != nil {
+			return 0, err
+		}
+		if !slices.Contains(promo.MovieIDs, showtime.MovieID) {
+			return 0, promoRuleError("promo code is not valid for this movie")
+		}
+	}
+
+	if promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions {
+		return 0, &bookingRuleError{Code: ruleCodePromoUnavailable, Message: "promo code has been fully redeemed"}
+	}
+	if promo.MaxPerUser > 0 {
+		var usage struct {
+			Count int `bson:"count"`
+		}
+		err := h.Mongo.Collection("promo_usage").FindOne(ctx, bson.M{"_id": promoUsageID(promo.ID, booking.UserID)}).Decode(&usage)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, err
+		}
+		if usage.Count >= promo.MaxPerUser {
+			return 0, &bookingRuleError{Code: ruleCodePromoUnavailable, Message: "you have already used this promo code"}
+		}
+	}
+
+	discount := pricing.Discount(promo, pricesFor(booking, booking.Seats))
+	if discount <= 0 {
+		return 0, promoRuleError("promo code does not apply to these seats")
+	}
+	return discount, nil
+}
+
+// promoUsageID keys the per-user redemption counter.
+func promoUsageID(promotionID, userID primitive.ObjectID) string {
+	return promotionID.Hex() + ":" + userID.Hex()
+}
+
+// redeemPromo counts one use of the booking's promo code against its global
+// and per-user caps and records the redemption. It runs inside the
+// confirmation transaction; each cap is enforced by a conditional update, so
+// concurrent confirmations cannot push a code past its limits. The validity
+// window is checked again here: the code may have expired since it was
+// applied to the held booking.
+func (h *BookingHandler) redeemPromo(sc mongo.SessionContext, booking models.Booking) error {
+	applied := booking.Promo
+	now := time.Now()
+	result, err := h.Mongo.Collection("promotions").UpdateOne(sc,
+		bson.M{
+			"_id":    applied.PromotionID,
+			"active": true,
+			"$and": []bson.M{
+				{"$or": []bson.M{
+					{"max_redemptions": 0},
+					{"$expr": bson.M{"$lt": bson.A{"$redemptions", "$max_redemptions"}}},
+				}},
+				{"$or": []bson.M{{"valid_from": nil}, {"valid_from": bson.M{"$lte": now}}}},
+				{"$or": []bson.M{{"valid_until": nil}, {"valid_until": bson.M{"$gt": now}}}},
+			},
+		},
+		bson.M{"$inc": bson.M{"redemptions": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errPromoUnavailable
+	}
+
+	var promo models.Promotion
+	if err := h.Mongo.Collection("promotions").FindOne(sc, bson.M{"_id": applied.PromotionID}).Decode(&promo); err != nil {
+		return err
+	}
+
+	// The upsert only matches while the count is under the cap; once it is
+	// reached the insert collides with the existing counter instead
+	usageFilter := bson.M{"_id": promoUsageID(promo.ID, booking.UserID)}
+	if promo.MaxPerUser > 0 {
+		usageFilter["count"] = bson.M{"$lt": promo.MaxPerUser}
+	}
+	_, err = h.Mongo.Collection("promo_usage").UpdateOne(sc, usageFilter,
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"promotion_id": promo.ID, "user_id": booking.UserID},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return errPromoUnavailable
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Mongo.Collection("promo_redemptions").InsertOne(sc, models.PromoRedemption{
+		ID:          primitive.NewObjectID(),
+		PromotionID: promo.ID,
+		Code:        promo.Code,
+		UserID:      booking.UserID,
+		BookingID:   booking.ID,
+		Discount:    applied.Discount,
+		CreatedAt:   time.Now(),
+	})
+	return err
+}
+
+// unredeemPromo undoes redeemPromo on servers without transactions.
+func (h *BookingHandler) unredeemPromo(ctx context.Context, booking models.Booking) {
+	result, _ := h.Mongo.Collection("promo_redemptions").DeleteOne(ctx, bson.M{"booking_id": booking.ID})
+	if result == nil || result.DeletedCount == 0 {
+		return
+	}
+	h.Mongo.Collection("promotions").UpdateOne(ctx,
+		bson.M{"_id": booking.Promo.PromotionID},
+		bson.M{"$inc": bson.M{"redemptions": -1}},
+	)
+	h.Mongo.Collection("promo_usage").UpdateOne(ctx,
+		bson.M{"_id": promoUsageID(booking.Promo.PromotionID, booking.UserID)},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+}
+
+// voidPromoPayment refunds a payment taken at a discount whose code ran out
+// before confirmation, and detaches both from the booking so the user can
+// pay again at full price while the seats are still held.
+func (h *BookingHandler) voidPromoPayment(ctx context.Context, booking models.Booking, payment models.Payment) error {
+	refund, err := h.startRefund(ctx, models.Refund{
+		ID:             primitive.NewObjectID(),
+		BookingID:      booking.ID,
+		PaymentID:      payment.ID,
+		Amount:         payment.Amount,
+		Reason:         errPromoUnavailable.Error(),
+		IdempotencyKey: fullRefundKey(payment.ID),
+		RequestedBy:    booking.UserID,
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	refund, err = h.payOutRefund(ctx, payment, refund)
+	if err != nil {
+		return err
+	}
+
+	entry := refundEntry(refund, payment)
+	return h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusLocked, "payment_id": payment.ID},
+			bson.M{"$unset": bson.M{"payment_id": "", "promo": ""}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+		if err := h.completeRefund(sc, refund); err != nil {
+			return err
+		}
+		moved, err := h.Mongo.TransitionPayment(sc, payment.ID, models.PaymentStatusSuccess, models.PaymentStatusRefunded, "promo_unavailable", nil)
+		if err != nil {
+			return err
+		}
+		if !moved {
+			return errPaymentStateChanged
+		}
+		return h.Mongo.PostJournalEntry(sc, entry)
+	}, func(ctx context.Context) {
+		h.Mongo.DeleteJournalEntry(ctx, entry.ID)
+		h.Mongo.RevertPaymentTransition(ctx, payment.ID, models.PaymentStatusSuccess, models.PaymentStatusRefunded)
+		h.reopenRefund(ctx, refund.ID)
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusLocked},
+			bson.M{"$set": bson.M{"payment_id": payment.ID, "promo": booking.Promo}},
+		)
+	})
+}
+
+func (h *AdminHandler) ListPromotions(c *gin.Context) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := h.Mongo.Collection("promotions").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch promotions"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	promos := []models.Promotion{}
+	if err := cursor.All(ctx, &promos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promotions": promos})
+}
+
+func (h *AdminHandler) CreatePromotion(c *gin.Context) {
+	var promo models.Promotion
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	promo.Code = strings.ToUpper(strings.TrimSpace(promo.Code))
+	if err := pricing.ValidatePromotion(promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promo.ID = primitive.NewObjectID()
+	promo.Redemptions = 0
+	promo.CreatedAt = time.Now()
+	promo.UpdatedAt = time.Now()
+	if _, err := h.Mongo.Collection("promotions").InsertOne(context.Background(), promo); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "promo code already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create promotion"})
+		return
+	}
+	c.JSON(http.StatusCreated, promo)
+}
+
+// UpdatePromotion replaces a promotion's terms. The code and the redemption
+// count cannot be changed.
+func (h *AdminHandler) UpdatePromotion(c *gin.Context) {
+	promoID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid promotion id"})
+		return
+	}
+
+	var promo models.Promotion
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	var existing models.Promotion
+	if err := h.Mongo.Collection("promotions").FindOne(ctx, bson.M{"_id": promoID}).Decode(&existing); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "promotion not found"})
+		return
+	}
+	promo.Code = existing.Code
+	if err := pricing.ValidatePromotion(promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{
+		"description":     promo.Description,
+		"type":            promo.Type,
+		"value":           promo.Value,
+		"buy_quantity":    promo.BuyQuantity,
+		"free_quantity":   promo.FreeQuantity,
+		"movie_ids":       promo.MovieIDs,
+		"showtime_ids":    promo.ShowtimeIDs,
+		"valid_from":      promo.ValidFrom,
+		"valid_until":     promo.ValidUntil,
+		"max_redemptions": promo.MaxRedemptions,
+		"max_per_user":    promo.MaxPerUser,
+		"active":          promo.Active,
+		"updated_at":      time.Now(),
+	}}
+	if err := h.Mongo.Collection("promotions").FindOneAndUpdate(ctx, bson.M{"_id": promoID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&promo); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update promotion"})
+		return
+	}
+	c.JSON(http.StatusOK, promo)
+}
@@ -0,0 +1,545 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/ledger"
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/mq"
+	"cinema-booking/internal/payments"
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errRefundStateChanged = errors.New("refund is no longer PENDING")
+
+type RefundRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *BookingHandler) RefundBooking(c *gin.Context) {
+	bookingIdStr := c.Param("id")
+	bookingID, err := primitive.ObjectIDFromHex(bookingIdStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	var req RefundRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userId := userIdStr.(string)
+	userOID, _ := primitive.ObjectIDFromHex(userId)
+
+	// A REFUNDING booking is one whose earlier refund did not finish; it is
+	// picked up again under the same idempotency keys
+	var booking models.Booking
+	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    bookingID,
+		"status": bson.M{"$in": []string{models.BookingStatusBooked, models.BookingStatusRefunding}},
+	}).Decode(&booking)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in BOOKED state"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionRefundBooking, authz.Booking(booking)) {
+		return
+	}
+
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": booking.ShowtimeID}).Decode(&showtime); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+	if booking.Status == models.BookingStatusBooked {
+		if err := h.checkRefundPolicy(showtime); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if booking.PaymentID == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking has no payment to refund"})
+		return
+	}
+	if booking.Exchange != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": errExchangePending.Error()})
+		return
+	}
+	var payment models.Payment
+	err = h.Mongo.Collection("payments").FindOne(ctx, bson.M{
+		"_id":    *booking.PaymentID,
+		"status": models.PaymentStatusSuccess,
+	}).Decode(&payment)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "payment not found or not refundable"})
+		return
+	}
+
+	refunds, err := h.refundBooking(ctx, booking, payment, userOID, req.Reason)
+	if err != nil {
+		if errors.Is(err, errBookingStateChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "booking is no longer in BOOKED state"})
+			return
+		}
+		if errors.Is(err, errRefundStateChanged) || errors.Is(err, errPaymentStateChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "payment was already refunded"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refund booking"})
+		return
+	}
+
+	refundIDs := make([]string, len(refunds))
+	var amount int64
+	for i, refund := range refunds {
+		refundIDs[i] = refund.ID.Hex()
+		amount += refund.Amount
+	}
+	resp := gin.H{
+		"status":    models.BookingStatusRefunded,
+		"refundIds": refundIDs,
+		"amount":    amount,
+	}
+	// Earlier exchange refunds may already have returned everything paid
+	if len(refunds) > 0 {
+		resp["refundId"] = refundIDs[0]
+		resp["refundStatus"] = refunds[0].Status
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// checkRefundPolicy rejects refunds once the showtime is closer than
+// RefundCutoff.
+func (h *BookingHandler) checkRefundPolicy(showtime models.Showtime) error {
+	deadline := showtime.StartTime.Add(-h.RefundCutoff)
+	if time.Now().After(deadline) {
+		return fmt.Errorf("refunds close %s before the showtime starts", h.RefundCutoff)
+	}
+	return nil
+}
+
+// refundBooking refunds a BOOKED booking against its payments: payment and
+// any price differences paid for seat exchanges. The booking is first
+// claimed as REFUNDING in its own write, so it cannot change while the
+// provider pays out. If a provider call fails before anything was paid out,
+// the booking goes back to BOOKED; otherwise it stays REFUNDING and
+// refunding it again finishes the job under the same idempotency keys. Once
+// the money is out, one transaction moves the booking to REFUNDED, records
+// the refunds and releases the seats. It then broadcasts SEAT_RELEASED.
+// BookingRefunded goes out through the outbox. The refund of payment comes
+// first in the result.
+func (h *BookingHandler) refundBooking(ctx context.Context, booking models.Booking, payment models.Payment, requestedBy primitive.ObjectID, reason string) ([]models.Refund, error) {
+	paid := []models.Payment{payment}
+	cursor, err := h.Mongo.Collection("payments").Find(ctx, bson.M{
+		"_id":     bson.M{"$in": booking.PaymentIDs, "$ne": payment.ID},
+		"purpose": models.PaymentPurposeExchange,
+		"status":  models.PaymentStatusSuccess,
+	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var extras []models.Payment
+	if err := cursor.All(ctx, &extras); err != nil {
+		return nil, err
+	}
+	paid = append(paid, extras...)
+
+	var user models.User
+	if err := h.Mongo.Collection("users").FindOne(ctx, bson.M{"_id": booking.UserID}).Decode(&user); err != nil {
+		log.Printf("Warning: Failed to get user: %v", err)
+	}
+
+	event := mq.NewBookingRefundedEvent(booking.ID.Hex(), booking.UserID.Hex(), user.Email, user.Name, booking.ShowtimeID.Hex(), booking.Seats)
+	outboxMsg, err := newOutboxMessage(event)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := false
+	if booking.Status == models.BookingStatusBooked {
+		result, err := h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusBooked, "exchange": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"status": models.BookingStatusRefunding, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errBookingStateChanged
+		}
+		claimed = true
+	}
+	paidOut := false
+	release := func() {
+		if !claimed || paidOut {
+			return
+		}
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusRefunding},
+			bson.M{"$set": bson.M{"status": models.BookingStatusBooked, "updated_at": time.Now()}},
+		)
+	}
+
+	paymentIDs := make([]primitive.ObjectID, len(paid))
+	for i, p := range paid {
+		paymentIDs[i] = p.ID
+	}
+	refunded, err := h.refundedAmounts(ctx, paymentIDs)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// Seats transferred to other users are not refunded to the payer, so
+	// the payments are refunded in order up to what the seats are worth.
+	// No payment returns more than is left of it after earlier refunds,
+	// such as those of cheaper seat exchanges.
+	remaining := bookingAmount(booking)
+	refunds := make([]models.Refund, 0, len(paid))
+	refundedFrom := make([]models.Payment, 0, len(paid))
+	for _, p := range paid {
+		amount := min(p.Amount-refunded[p.ID], remaining)
+		if amount <= 0 {
+			continue
+		}
+		remaining -= amount
+		refund, err := h.startRefund(ctx, models.Refund{
+			ID:             primitive.NewObjectID(),
+			BookingID:      booking.ID,
+			PaymentID:      p.ID,
+			Amount:         amount,
+			Reason:         reason,
+			IdempotencyKey: fullRefundKey(p.ID),
+			RequestedBy:    requestedBy,
+			CreatedAt:      time.Now(),
+		})
+		if err != nil {
+			release()
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+		refundedFrom = append(refundedFrom, p)
+	}
+	paid = refundedFrom
+
+	// The refunds stay PENDING until the booking is REFUNDED, so a retry
+	// reuses them and their keys
+	entries := make([]models.JournalEntry, len(refunds))
+	for i := range refunds {
+		refunds[i], err = h.payOutRefund(ctx, paid[i], refunds[i])
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("provider refund: %w", err)
+		}
+		paidOut = paidOut || refunds[i].Status == models.RefundStatusSuccess
+		entries[i] = refundEntry(refunds[i], paid[i])
+	}
+
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusRefunding},
+			bson.M{"$set": bson.M{"status": models.BookingStatusRefunded, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+
+		for i, refund := range refunds {
+			if err := h.completeRefund(sc, refund); err != nil {
+				return err
+			}
+			moved, err := h.Mongo.TransitionPayment(sc, refund.PaymentID, models.PaymentStatusSuccess, models.PaymentStatusRefunded, "refund", nil)
+			if err != nil {
+				return err
+			}
+			if !moved {
+				return errPaymentStateChanged
+			}
+			if err := h.Mongo.PostJournalEntry(sc, entries[i]); err != nil {
+				return err
+			}
+		}
+
+		_, err = h.Mongo.Collection("seat_reservations").UpdateMany(sc,
+			bson.M{"showtime_id": booking.ShowtimeID, "booking_id": booking.ID, "state": models.SeatStateBooked},
+			bson.M{"$set": bson.M{
+				"state":             models.SeatStateAvailable,
+				"locked_by_user_id": nil,
+				"lock_expires_at":   nil,
+				"booking_id":        nil,
+				"updated_at":        time.Now(),
+			}},
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = h.Mongo.Collection("outbox").InsertOne(sc, outboxMsg)
+		return err
+	}, func(ctx context.Context) {
+		h.Mongo.Collection("outbox").DeleteOne(ctx, bson.M{"_id": outboxMsg.ID})
+		for i, refund := range refunds {
+			h.Mongo.DeleteJournalEntry(ctx, entries[i].ID)
+			h.reopenRefund(ctx, refund.ID)
+			h.Mongo.RevertPaymentTransition(ctx, refund.PaymentID, models.PaymentStatusSuccess, models.PaymentStatusRefunded)
+		}
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "status": models.BookingStatusRefunded},
+			bson.M{"$set": bson.M{"status": models.BookingStatusRefunding, "updated_at": time.Now()}},
+		)
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: booking.ShowtimeID,
+		Seats:      booking.Seats,
+		From:       models.SeatStateBooked,
+		To:         models.SeatStateAvailable,
+		Reason:     models.SeatChangeRefund,
+		Actor:      &requestedBy,
+		BookingID:  &booking.ID,
+	})
+
+	// Broadcast SEAT_RELEASED
+	showtimeIdStr := booking.ShowtimeID.Hex()
+	for _, seat := range booking.Seats {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":     "SEAT_RELEASED",
+			"seatCode": seat,
+		})
+		h.Hub.BroadcastToRoom(showtimeIdStr, msg)
+	}
+	h.seatsReleased(booking.ShowtimeID)
+
+	return refunds, nil
+}
+
+// refundedAmounts sums the refunds already recorded against each payment,
+// whatever their status: even a FAILED one is still owed and settled by
+// hand. A payment's full refund is left out, since startRefund reuses it
+// when a refund is retried.
+func (h *BookingHandler) refundedAmounts(ctx context.Context, paymentIDs []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	fullKeys := make([]string, len(paymentIDs))
+	for i, id := range paymentIDs {
+		fullKeys[i] = fullRefundKey(id)
+	}
+	cursor, err := h.Mongo.Collection("refunds").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"payment_id":      bson.M{"$in": paymentIDs},
+			"idempotency_key": bson.M{"$nin": fullKeys},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$payment_id", "amount": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sums []struct {
+		PaymentID primitive.ObjectID `bson:"_id"`
+		Amount    int64              `bson:"amount"`
+	}
+	if err := cursor.All(ctx, &sums); err != nil {
+		return nil, err
+	}
+	refunded := make(map[primitive.ObjectID]int64, len(sums))
+	for _, s := range sums {
+		refunded[s.PaymentID] = s.Amount
+	}
+	return refunded, nil
+}
+
+// fullRefundKey is the idempotency key of the refund returning all of a
+// payment. A payment is refunded in full at most once, whichever path does
+// it, so every path shares the key.
+func fullRefundKey(paymentID primitive.ObjectID) string {
+	return "payment:" + paymentID.Hex() + ":refund"
+}
+
+// startRefund records refund as PENDING before the provider is asked for the
+// money. If an earlier attempt already recorded a refund under the same
+// idempotency key, that one is returned instead, so the retry passes the
+// provider the same key and the payer is refunded once.
+func (h *BookingHandler) startRefund(ctx context.Context, refund models.Refund) (models.Refund, error) {
+	refund.Status = models.RefundStatusPending
+	var stored models.Refund
+	err := h.Mongo.Collection("refunds").FindOneAndUpdate(ctx,
+		bson.M{"idempotency_key": refund.IdempotencyKey},
+		bson.M{"$setOnInsert": refund},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	if err != nil {
+		return stored, err
+	}
+	if stored.Status != models.RefundStatusPending {
+		return stored, errRefundStateChanged
+	}
+	return stored, nil
+}
+
+// payOutRefund asks the provider that took payment for the money of
+// refund, recorded by startRefund, and returns it with the status it settles
+// in: SUCCESS, or MANUAL_REQUIRED when the provider cannot pay out and the
+// money has to be transferred back by hand.
+func (h *BookingHandler) payOutRefund(ctx context.Context, payment models.Payment, refund models.Refund) (models.Refund, error) {
+	err := h.refundPayment(ctx, payment, refund)
+	switch {
+	case errors.Is(err, payments.ErrManualRefund):
+		refund.Status = models.RefundStatusManualRequired
+	case err != nil:
+		return refund, err
+	default:
+		refund.Status = models.RefundStatusSuccess
+	}
+	return refund, nil
+}
+
+// refundEntry is the journal entry recording refund. A refund still to be
+// transferred by hand gets an empty entry, which PostJournalEntry skips; it
+// is posted when an admin confirms the transfer.
+func refundEntry(refund models.Refund, payment models.Payment) models.JournalEntry {
+	if refund.Status != models.RefundStatusSuccess {
+		return models.JournalEntry{}
+	}
+	return ledger.Refund(refund, payment)
+}
+
+// completeRefund moves a PENDING refund to the status payOutRefund settled
+// it in, in the transaction that records its effects.
+func (h *BookingHandler) completeRefund(sc context.Context, refund models.Refund) error {
+	result, err := h.Mongo.Collection("refunds").UpdateOne(sc,
+		bson.M{"_id": refund.ID, "status": models.RefundStatusPending},
+		bson.M{"$set": bson.M{"status": refund.Status}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errRefundStateChanged
+	}
+	return nil
+}
+
+// reopenRefund undoes completeRefund from a rollback when transactions are
+// unavailable. The refund stays PENDING for the retry.
+func (h *BookingHandler) reopenRefund(ctx context.Context, refundID primitive.ObjectID) {
+	h.Mongo.Collection("refunds").UpdateOne(ctx,
+		bson.M{"_id": refundID, "status": bson.M{"$in": []string{models.RefundStatusSuccess, models.RefundStatusManualRequired}}},
+		bson.M{"$set": bson.M{"status": models.RefundStatusPending}},
+	)
+}
+
+// ListRefunds returns the latest refunds, optionally filtered by status,
+// e.g. MANUAL_REQUIRED for the transfers still to be made by hand.
+func (h *AdminHandler) ListRefunds(c *gin.Context) {
+	ctx := context.Background()
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100)
+	cursor, err := h.Mongo.Collection("refunds").Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch refunds"})
+		return
+	}
+	refunds := []models.Refund{}
+	if err := cursor.All(ctx, &refunds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+	c.JSON(http.StatusOK, refunds)
+}
+
+// ConfirmRefund records that the money of a MANUAL_REQUIRED refund was
+// transferred back by hand. The refund becomes SUCCESS and is posted to the
+// ledger in the same transaction.
+func (h *AdminHandler) ConfirmRefund(c *gin.Context) {
+	refundID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid refund id"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	adminOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	var refund models.Refund
+	if err := h.Mongo.Collection("refunds").FindOne(ctx, bson.M{"_id": refundID}).Decode(&refund); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "refund not found"})
+		return
+	}
+	if refund.Status != models.RefundStatusManualRequired {
+		c.JSON(http.StatusConflict, gin.H{"error": "refund is not waiting for a manual transfer"})
+		return
+	}
+	var payment models.Payment
+	if err := h.Mongo.Collection("payments").FindOne(ctx, bson.M{"_id": refund.PaymentID}).Decode(&payment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load payment"})
+		return
+	}
+
+	now := time.Now()
+	refund.Status = models.RefundStatusSuccess
+	refund.ConfirmedBy = &adminOID
+	refund.ConfirmedAt = &now
+	entry := ledger.Refund(refund, payment)
+	entry.ActorID = &adminOID
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := h.Mongo.Collection("refunds").UpdateOne(sc,
+			bson.M{"_id": refund.ID, "status": models.RefundStatusManualRequired},
+			bson.M{"$set": bson.M{"status": models.RefundStatusSuccess, "confirmed_by": adminOID, "confirmed_at": now}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errRefundStateChanged
+		}
+		return h.Mongo.PostJournalEntry(sc, entry)
+	}, func(ctx context.Context) {
+		h.Mongo.DeleteJournalEntry(ctx, entry.ID)
+		h.Mongo.Collection("refunds").UpdateOne(ctx,
+			bson.M{"_id": refund.ID, "status": models.RefundStatusSuccess},
+			bson.M{
+				"$set":   bson.M{"status": models.RefundStatusManualRequired},
+				"$unset": bson.M{"confirmed_by": "", "confirmed_at": ""},
+			},
+		)
+	})
+	if err != nil {
+		if errors.Is(err, errRefundStateChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "refund is not waiting for a manual transfer"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm refund"})
+		return
+	}
+	c.JSON(http.StatusOK, refund)
+}
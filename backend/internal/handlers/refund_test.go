@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// bookHeld turns the seeded booking into a confirmed one and returns it
+// with its payment.
+func bookHeld(t *testing.T, svc *services.MongoService, held heldBooking) (models.Booking, models.Payment) {
+	t.Helper()
+	ctx := context.Background()
+	booking := held.booking
+	booking.Status = models.BookingStatusBooked
+	booking.LockExpiresAt = nil
+
+	_, err := svc.Collection("bookings").UpdateOne(ctx,
+		bson.M{"_id": booking.ID},
+		bson.M{"$set": bson.M{"status": booking.Status}, "$unset": bson.M{"lock_expires_at": ""}},
+	)
+	if err == nil {
+		_, err = svc.Collection("seat_reservations").UpdateMany(ctx,
+			bson.M{"booking_id": booking.ID},
+			bson.M{"$set": bson.M{"state": models.SeatStateBooked}, "$unset": bson.M{"lock_expires_at": ""}},
+		)
+	}
+	if err != nil {
+		t.Fatalf("book seats: %v", err)
+	}
+
+	var payment models.Payment
+	if err := svc.Collection("payments").FindOne(ctx, bson.M{"_id": *booking.PaymentID}).Decode(&payment); err != nil {
+		t.Fatalf("load payment: %v", err)
+	}
+	return booking, payment
+}
+
+func bookingStatus(t *testing.T, svc *services.MongoService, booking models.Booking) string {
+	t.Helper()
+	var stored models.Booking
+	if err := svc.Collection("bookings").FindOne(context.Background(), bson.M{"_id": booking.ID}).Decode(&stored); err != nil {
+		t.Fatalf("load booking: %v", err)
+	}
+	return stored.Status
+}
+
+func storedRefunds(t *testing.T, svc *services.MongoService, booking models.Booking) []models.Refund {
+	t.Helper()
+	ctx := context.Background()
+	cursor, err := svc.Collection("refunds").Find(ctx, bson.M{"booking_id": booking.ID})
+	if err != nil {
+		t.Fatalf("load refunds: %v", err)
+	}
+	var refunds []models.Refund
+	if err := cursor.All(ctx, &refunds); err != nil {
+		t.Fatalf("load refunds: %v", err)
+	}
+	return refunds
+}
+
+func TestRefundProviderFailureReleasesClaim(t *testing.T) {
+	svc := testMongo(t, false)
+	booking, payment := bookHeld(t, svc, seedHeldBooking(t, svc))
+	h := testBookingHandler(svc)
+
+	// No provider is configured for the payment, so nothing is paid out
+	payment.Provider = "UNKNOWN"
+	if _, err := h.refundBooking(context.Background(), booking, payment, booking.UserID, ""); err == nil {
+		t.Fatal("refund succeeded without a provider")
+	}
+	if status := bookingStatus(t, svc, booking); status != models.BookingStatusBooked {
+		t.Errorf("booking status = %s, want %s", status, models.BookingStatusBooked)
+	}
+}
+
+func TestRefundFailureAfterPayoutKeepsClaim(t *testing.T) {
+	for _, mode := range []struct {
+		name         string
+		transactions bool
+	}{{"transaction", true}, {"fallback", false}} {
+		t.Run(mode.name, func(t *testing.T) {
+			svc := testMongo(t, mode.transactions)
+			booking, payment := bookHeld(t, svc, seedHeldBooking(t, svc))
+			h := testBookingHandler(svc)
+			ctx := context.Background()
+
+			rejectWrites(t, svc, "seat_reservations", bson.M{"state": bson.M{"$ne": models.SeatStateAvailable}})
+			if _, err := h.refundBooking(ctx, booking, payment, booking.UserID, ""); err == nil {
+				t.Fatal("refund succeeded despite failing seat release")
+			}
+
+			// The money went out, so the customer must not get the seats back
+			if status := bookingStatus(t, svc, booking); status != models.BookingStatusRefunding {
+				t.Fatalf("booking status = %s, want %s", status, models.BookingStatusRefunding)
+			}
+			refunds := storedRefunds(t, svc, booking)
+			if len(refunds) != 1 || refunds[0].Status != models.RefundStatusPending {
+				t.Fatalf("refunds = %+v, want one PENDING", refunds)
+			}
+			first := refunds[0].ID
+
+			// Refunding again finishes the job with the same refund
+			rejectWrites(t, svc, "seat_reservations", bson.M{})
+			booking.Status = models.BookingStatusRefunding
+			if _, err := h.refundBooking(ctx, booking, payment, booking.UserID, ""); err != nil {
+				t.Fatalf("retry refund: %v", err)
+			}
+			if status := bookingStatus(t, svc, booking); status != models.BookingStatusRefunded {
+				t.Errorf("booking status = %s, want %s", status, models.BookingStatusRefunded)
+			}
+			refunds = storedRefunds(t, svc, booking)
+			if len(refunds) != 1 || refunds[0].ID != first || refunds[0].Status != models.RefundStatusSuccess {
+				t.Errorf("refunds = %+v, want %s SUCCESS", refunds, first.Hex())
+			}
+		})
+	}
+}
+
+func TestExchangeDowngradeWithPromoRefundsNoMoreThanPaid(t *testing.T) {
+	svc := testMongo(t, false)
+	held := seedHeldBooking(t, svc)
+	h := testBookingHandler(svc)
+	ctx := context.Background()
+
+	// 500 baht of seats bought for 400 with a 100 baht promo
+	held.booking.Prices = []models.SeatPrice{{SeatCode: "A1", Price: 30000}, {SeatCode: "A2", Price: 20000}}
+	_, err := svc.Collection("bookings").UpdateOne(ctx,
+		bson.M{"_id": held.booking.ID},
+		bson.M{"$set": bson.M{"prices": held.booking.Prices}},
+	)
+	if err == nil {
+		_, err = svc.Collection("payments").UpdateOne(ctx,
+			bson.M{"_id": *held.booking.PaymentID},
+			bson.M{"$set": bson.M{"amount": int64(40000), "discount": int64(10000)}},
+		)
+	}
+	if err != nil {
+		t.Fatalf("seed prices: %v", err)
+	}
+	booking, _ := bookHeld(t, svc, held)
+
+	// Giving up A2 is worth 200 baht
+	ex := models.PendingExchange{
+		ShowtimeID:  booking.ShowtimeID,
+		Seats:       []string{"A1"},
+		Removed:     []string{"A2"},
+		Prices:      booking.Prices[:1],
+		Difference:  -20000,
+		RequestedBy: booking.UserID,
+	}
+	refund, payment, err := h.exchangeRefund(ctx, booking, ex)
+	if err != nil || refund == nil {
+		t.Fatalf("exchange refund = %v, %v", refund, err)
+	}
+	if err := h.commitExchange(ctx, booking, ex, refund); err != nil {
+		t.Fatalf("commit exchange: %v", err)
+	}
+
+	if err := svc.Collection("bookings").FindOne(ctx, bson.M{"_id": booking.ID}).Decode(&booking); err != nil {
+		t.Fatalf("load booking: %v", err)
+	}
+	if _, err := h.refundBooking(ctx, booking, payment, booking.UserID, ""); err != nil {
+		t.Fatalf("refund booking: %v", err)
+	}
+
+	var total int64
+	for _, r := range storedRefunds(t, svc, booking) {
+		total += r.Amount
+	}
+	if total != payment.Amount {
+		t.Errorf("refunded %d in total, want the %d paid", total, payment.Amount)
+	}
+}
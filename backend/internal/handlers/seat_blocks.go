@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type BlockSeatsRequest struct {
+	Seats  []string `json:"seats" binding:"required"`
+	Reason string   `json:"reason"`
+}
+
+// BlockSeatsRangeRequest applies a block to every showtime starting in
+// [From, To), optionally limited to one auditorium.
+type BlockSeatsRangeRequest struct {
+	BlockSeatsRequest
+	From         time.Time `json:"from" binding:"required"`
+	To           time.Time `json:"to" binding:"required"`
+	AuditoriumID string    `json:"auditoriumId"`
+}
+
+// seatBlockResult reports what a block or unblock did to one showtime.
+// Skipped seats were not in a state the operation applies to (for example a
+// seat that is already LOCKED or BOOKED cannot be blocked).
+type seatBlockResult struct {
+	ShowtimeID string   `json:"showtimeId"`
+	Changed    []string `json:"changed"`
+	Skipped    []string `json:"skipped"`
+}
+
+// adminSeat is a seat as admins see it, including the block details that are
+// hidden from customers.
+type adminSeat struct {
+	models.SeatReservation
+	BlockReason string              `json:"blockReason,omitempty"`
+	BlockedBy   *primitive.ObjectID `json:"blockedBy,omitempty"`
+	BlockedAt   *time.Time          `json:"blockedAt,omitempty"`
+}
+
+// GetSeats lists a showtime's seats with their block details.
+func (h *AdminHandler) GetSeats(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	ctx := context.Background()
+	cursor, err := h.Mongo.Collection("seat_reservations").Find(ctx, bson.M{"showtime_id": showtimeID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch seats"})
+		return
+	}
+	var seats []models.SeatReservation
+	if err := cursor.All(ctx, &seats); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+
+	result := make([]adminSeat, len(seats))
+	for i, seat := range seats {
+		result[i] = adminSeat{
+			SeatReservation: seat,
+			BlockReason:     seat.BlockReason,
+			BlockedBy:       seat.BlockedBy,
+			BlockedAt:       seat.BlockedAt,
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *AdminHandler) BlockSeats(c *gin.Context) {
+	h.changeShowtimeBlocks(c, true)
+}
+
+func (h *AdminHandler) UnblockSeats(c *gin.Context) {
+	h.changeShowtimeBlocks(c, false)
+}
+
+func (h *AdminHandler) BlockSeatsRange(c *gin.Context) {
+	h.changeRangeBlocks(c, true)
+}
+
+func (h *AdminHandler) UnblockSeatsRange(c *gin.Context) {
+	h.changeRangeBlocks(c, false)
+}
+
+func (h *AdminHandler) changeShowtimeBlocks(c *gin.Context, block bool) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	var req BlockSeatsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Seats) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no seats selected"})
+		return
+	}
+
+	ctx := context.Background()
+	count, err := h.Mongo.Collection("showtimes").CountDocuments(ctx, bson.M{"_id": showtimeID})
+	if err != nil || count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+
+	adminOID := subject(c).UserID
+	result, err := h.applySeatBlock(ctx, showtimeID, req, adminOID, block)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update seats"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *AdminHandler) changeRangeBlocks(c *gin.Context, block bool) {
+	var req BlockSeatsRangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Seats) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no seats selected"})
+		return
+	}
+	if !req.From.Before(req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	ctx := context.Background()
+	filter := bson.M{"start_time": bson.M{"$gte": req.From, "$lt": req.To}}
+	if req.AuditoriumID != "" {
+		filter["auditorium_id"] = req.AuditoriumID
+	}
+
+	cursor, err := h.Mongo.Collection("showtimes").Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch showtimes"})
+		return
+	}
+	var showtimes []models.Showtime
+	if err := cursor.All(ctx, &showtimes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode"})
+		return
+	}
+
+	adminOID := subject(c).UserID
+	results := []seatBlockResult{}
+	for _, showtime := range showtimes {
+		result, err := h.applySeatBlock(ctx, showtime.ID, req.BlockSeatsRequest, adminOID, block)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update seats", "showtimes": results})
+			return
+		}
+		results = append(results, *result)
+	}
+	c.JSON(http.StatusOK, gin.H{"showtimes": results})
+}
+
+// applySeatBlock moves seats AVAILABLE→BLOCKED (block) or BLOCKED→AVAILABLE
+// (unblock) one at a time so a seat another user is holding is skipped
+// rather than taken from them. Changes are broadcast and audited.
+func (h *AdminHandler) applySeatBlock(ctx context.Context, showtimeID primitive.ObjectID, req BlockSeatsRequest, adminOID primitive.ObjectID, block bool) (*seatBlockResult, error) {
+	result := &seatBlockResult{ShowtimeID: showtimeID.Hex(), Changed: []string{}, Skipped: []string{}}
+
+	from, update := models.SeatStateAvailable, bson.M{
+		"$set": bson.M{
+			"state":        models.SeatStateBlocked,
+			"block_reason": req.Reason,
+			"blocked_by":   adminOID,
+			"blocked_at":   time.Now(),
+			"updated_at":   time.Now(),
+		},
+	}
+	if !block {
+		from, update = models.SeatStateBlocked, bson.M{
+			"$set":   bson.M{"state": models.SeatStateAvailable, "updated_at": time.Now()},
+			"$unset": bson.M{"block_reason": "", "blocked_by": "", "blocked_at": ""},
+		}
+	}
+
+	for _, seat := range req.Seats {
+		res, err := h.Mongo.Collection("seat_reservations").UpdateOne(ctx,
+			bson.M{"showtime_id": showtimeID, "seat_code": seat, "state": from},
+			update,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			result.Skipped = append(result.Skipped, seat)
+			continue
+		}
+		result.Changed = append(result.Changed, seat)
+	}
+	if len(result.Changed) == 0 {
+		return result, nil
+	}
+
+	to, reason := models.SeatStateBlocked, models.SeatChangeBlock
+	eventType, msgType := "SEATS_BLOCKED", "SEAT_BLOCKED"
+	if !block {
+		to, reason = models.SeatStateAvailable, models.SeatChangeUnblock
+		eventType, msgType = "SEATS_UNBLOCKED", "SEAT_RELEASED"
+	}
+
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: showtimeID,
+		Seats:      result.Changed,
+		From:       from,
+		To:         to,
+		Reason:     reason,
+		Note:       req.Reason,
+		Actor:      &adminOID,
+	})
+
+	for _, seat := range result.Changed {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"type":     msgType,
+			"seatCode": seat,
+		})
+		h.Hub.BroadcastToRoom(result.ShowtimeID, msg)
+	}
+
+	auditLog := models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		EventType:  eventType,
+		UserID:     &adminOID,
+		ShowtimeID: &showtimeID,
+		Payload: map[string]interface{}{
+			"seats":  result.Changed,
+			"reason": req.Reason,
+		},
+		CreatedAt: time.Now(),
+	}
+	h.Mongo.Collection("audit_logs").InsertOne(ctx, auditLog)
+
+	if !block && h.OnSeatsReleased != nil {
+		go h.OnSeatsReleased(showtimeID)
+	}
+	return result, nil
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/seating"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ruleCodeSingleSeatGap = "SINGLE_SEAT_GAP"
+	ruleCodePurchaseLimit = "PURCHASE_LIMIT_EXCEEDED"
+)
+
+// bookingRuleError is a business rule violation. It is returned to the
+// client as 422 with a machine-readable code and rule-specific details.
+type bookingRuleError struct {
+	Code    string
+	Message string
+	Details any
+}
+
+func (e *bookingRuleError) Error() string {
+	return e.Message
+}
+
+// respondLockError maps errors from the seat locking path to responses.
+func respondLockError(c *gin.Context, err error, fallback string) {
+	var conflict *seatConflictError
+	var rule *bookingRuleError
+	switch {
+	case errors.As(err, &conflict):
+		c.JSON(http.StatusConflict, gin.H{"error": conflict.Error(), "seats": conflict.Seats})
+	case errors.As(err, &rule):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": rule.Message, "code": rule.Code, "details": rule.Details})
+	case errors.Is(err, errBookingStateChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+func (h *BookingHandler) gapRuleEnabled(showtime models.Showtime) bool {
+	if showtime.GapRule != nil {
+		return *showtime.GapRule
+	}
+	return h.GapRuleDefault
+}
+
+func (h *BookingHandler) loadSeatmap(ctx context.Context, showtime models.Showtime) (models.Seatmap, error) {
+	var seatmap models.Seatmap
+	err := h.Mongo.Collection("seatmaps").FindOne(ctx, bson.M{"_id": showtime.SeatmapID}).Decode(&seatmap)
+	return seatmap, err
+}
+
+// checkSeatRules validates a change to the seats held for a showtime before
+// any lock is taken. added are seats about to be locked, removed are seats
+// about to be released.
+func (h *BookingHandler) checkSeatRules(ctx context.Context, showtime models.Showtime, added, removed []string) error {
+	if err := h.checkBlockedSeats(ctx, showtime.ID, added); err != nil {
+		return err
+	}
+	if !h.gapRuleEnabled(showtime) {
+		return nil
+	}
+
+	seatmap, err := h.loadSeatmap(ctx, showtime)
+	if err != nil {
+		return err
+	}
+	available, err := h.availableSeats(ctx, showtime.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, seat := range added {
+		available[seat] = false
+	}
+	for _, seat := range removed {
+		available[seat] = true
+	}
+
+	changed := append(append([]string{}, added...), removed...)
+	gaps := seating.SingleSeatGaps(seatmap, func(code string) bool { return available[code] }, changed)
+	if len(gaps) > 0 {
+		return &bookingRuleError{
+			Code:    ruleCodeSingleSeatGap,
+			Message: "selection would leave a single empty seat between taken seats",
+			Details: gin.H{"gaps": gaps},
+		}
+	}
+	return nil
+}
+
+// checkBlockedSeats rejects seats an admin has taken out of sale.
+func (h *BookingHandler) checkBlockedSeats(ctx context.Context, showtimeID primitive.ObjectID, seats []string) error {
+	if len(seats) == 0 {
+		return nil
+	}
+
+	cursor, err := h.Mongo.Collection("seat_reservations").Find(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"seat_code":   bson.M{"$in": seats},
+		"state":       models.SeatStateBlocked,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var blocked []models.SeatReservation
+	if err := cursor.All(ctx, &blocked); err != nil {
+		return err
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	codes := make([]string, 0, len(blocked))
+	for _, seat := range blocked {
+		codes = append(codes, seat.SeatCode)
+	}
+	return &seatConflictError{Seats: codes, Reason: "blocked"}
+}
+
+// purchaseLimits returns the limits for a showtime, falling back to the
+// server defaults for every field the showtime does not override.
+func (h *BookingHandler) purchaseLimits(showtime models.Showtime) models.PurchaseLimits {
+	limits := h.Limits
+	if o := showtime.Limits; o != nil {
+		if o.MaxSeatsPerBooking > 0 {
+			limits.MaxSeatsPerBooking = o.MaxSeatsPerBooking
+		}
+		if o.MaxLockedBookings > 0 {
+			limits.MaxLockedBookings = o.MaxLockedBookings
+		}
+		if o.MaxSeatsPerShowtime > 0 {
+			limits.MaxSeatsPerShowtime = o.MaxSeatsPerShowtime
+		}
+	}
+	return limits
+}
+
+// checkPurchaseLimits enforces the purchase limits for a user. booking is
+// the booking being changed, or nil when a new booking is about to be
+// created; added is the number of seats being added to it. Every violation
+// is written to audit_logs.
+//
+// It only fails fast: concurrent requests can all pass it, so the writes it
+// guards repeat it with recheckPurchaseLimits.
+func (h *BookingHandler) checkPurchaseLimits(ctx context.Context, showtime models.Showtime, userOID primitive.ObjectID, booking *models.Booking, added int) error {
+	var self primitive.ObjectID
+	if booking != nil {
+		self = booking.ID
+	}
+	return h.enforcePurchaseLimits(ctx, showtime, userOID, booking, self, added)
+}
+
+// recheckPurchaseLimits repeats checkPurchaseLimits in the transaction sc
+// that wrote the booking with id self, before it commits. booking and added
+// are the values checkPurchaseLimits was given.
+//
+// It first touches the user's purchase guard, so concurrent transactions of
+// the same user conflict and the one retried sees the other's booking.
+// Without transactions each request counts after its own write, so racing
+// requests may both fail but never both succeed.
+func (h *BookingHandler) recheckPurchaseLimits(sc context.Context, showtime models.Showtime, userOID primitive.ObjectID, booking *models.Booking, self primitive.ObjectID, added int) error {
+	limits := h.purchaseLimits(showtime)
+	if limits.MaxLockedBookings == 0 && limits.MaxSeatsPerShowtime == 0 {
+		return nil
+	}
+
+	_, err := h.Mongo.Collection("purchase_guards").UpdateOne(sc,
+		bson.M{"_id": userOID},
+		bson.M{"$set": bson.M{"updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	return h.enforcePurchaseLimits(sc, showtime, userOID, booking, self, added)
+}
+
+// enforcePurchaseLimits counts the user's bookings other than self, then
+// adds booking (or the new booking when nil) and the added seats.
+func (h *BookingHandler) enforcePurchaseLimits(ctx context.Context, showtime models.Showtime, userOID primitive.ObjectID, booking *models.Booking, self primitive.ObjectID, added int) error {
+	limits := h.purchaseLimits(showtime)
+
+	bookingSeats := added
+	if booking != nil {
+		bookingSeats += len(booking.Seats)
+	}
+	if limits.MaxSeatsPerBooking > 0 && bookingSeats > limits.MaxSeatsPerBooking {
+		return h.limitExceeded(showtime, userOID, "max_seats_per_booking", limits.MaxSeatsPerBooking, bookingSeats)
+	}
+
+	if booking == nil && limits.MaxLockedBookings > 0 {
+		locked, err := h.Mongo.Collection("bookings").CountDocuments(ctx, bson.M{
+			"_id":             bson.M{"$ne": self},
+			"user_id":         userOID,
+			"status":          models.BookingStatusLocked,
+			"lock_expires_at": bson.M{"$gt": time.Now()},
+		})
+		if err != nil {
+			return err
+		}
+		if int(locked)+1 > limits.MaxLockedBookings {
+			return h.limitExceeded(showtime, userOID, "max_locked_bookings", limits.MaxLockedBookings, int(locked)+1)
+		}
+	}
+
+	if limits.MaxSeatsPerShowtime > 0 {
+		cursor, err := h.Mongo.Collection("bookings").Find(ctx, bson.M{
+			"_id":         bson.M{"$ne": self},
+			"user_id":     userOID,
+			"showtime_id": showtime.ID,
+			"status":      bson.M{"$in": []string{models.BookingStatusLocked, models.BookingStatusBooked, models.BookingStatusRefunding}},
+		})
+		if err != nil {
+			return err
+		}
+		var bookings []models.Booking
+		if err := cursor.All(ctx, &bookings); err != nil {
+			return err
+		}
+
+		total := bookingSeats
+		for _, b := range bookings {
+			if b.Status == models.BookingStatusLocked && b.LockExpiresAt != nil && b.LockExpiresAt.Before(time.Now()) {
+				continue
+			}
+			total += len(b.Seats)
+		}
+		if total > limits.MaxSeatsPerShowtime {
+			return h.limitExceeded(showtime, userOID, "max_seats_per_showtime", limits.MaxSeatsPerShowtime, total)
+		}
+	}
+
+	return nil
+}
+
+// limitExceeded records a violation and returns its error. The audit log is
+// written outside any transaction so it survives the abort that follows.
+func (h *BookingHandler) limitExceeded(showtime models.Showtime, userOID primitive.ObjectID, limit string, max, requested int) error {
+	auditLog := models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		EventType:  ruleCodePurchaseLimit,
+		UserID:     &userOID,
+		ShowtimeID: &showtime.ID,
+		Payload: map[string]interface{}{
+			"limit":     limit,
+			"max":       max,
+			"requested": requested,
+		},
+		CreatedAt: time.Now(),
+	}
+	h.Mongo.Collection("audit_logs").InsertOne(context.Background(), auditLog)
+
+	return &bookingRuleError{
+		Code:    ruleCodePurchaseLimit,
+		Message: fmt.Sprintf("purchase limit exceeded: %s is %d, requested %d", limit, max, requested),
+		Details: gin.H{"limit": limit, "max": max, "requested": requested},
+	}
+}
@@ -49,6 +49,16 @@ func SeedData(mongo *services.MongoService) {
 
 	mongo.Collection("seatmaps").InsertOne(ctx, seatmap)
 
+	// Default prices for every showtime
+	mongo.Collection("price_lists").InsertOne(ctx, models.PriceList{
+		ID:        primitive.NewObjectID(),
+		Name:      "Standard",
+		Prices:    map[string]int64{"NORMAL": 25000, "VIP": 40000},
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+
 	// Create movies
 	movies := []models.Movie{
 		{ID: primitive.NewObjectID(), Title: "Inception", DurationMin: 148, Rating: "PG-13", CreatedAt: time.Now()},
@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"cinema-booking/internal/authz"
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errTransferStateChanged = errors.New("transfer state changed")
+
+type TransferHandler struct {
+	Mongo *services.MongoService
+	Email *services.EmailService
+	Authz *authz.Authorizer
+}
+
+type CreateTransferRequest struct {
+	Seats   []string `json:"seats" binding:"required"`
+	ToEmail string   `json:"toEmail" binding:"required,email"`
+}
+
+// CreateTransfer starts handing some seats of a BOOKED booking to another
+// registered user. Ownership only moves once the recipient accepts.
+func (h *TransferHandler) CreateTransfer(c *gin.Context) {
+	bookingID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking id"})
+		return
+	}
+
+	var req CreateTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Seats) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no seats selected"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	var booking models.Booking
+	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    bookingID,
+		"status": models.BookingStatusBooked,
+	}).Decode(&booking)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found or not in BOOKED state"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionTransferSeats, authz.Booking(booking)) {
+		return
+	}
+	if booking.Exchange != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": errExchangePending.Error()})
+		return
+	}
+
+	if err := h.checkShowtimeNotStarted(ctx, booking.ShowtimeID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	owned := make(map[string]bool, len(booking.Seats))
+	for _, seat := range booking.Seats {
+		owned[seat] = true
+	}
+	requested := make(map[string]bool, len(req.Seats))
+	for _, seat := range req.Seats {
+		if !owned[seat] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seat " + seat + " is not part of this booking"})
+			return
+		}
+		if requested[seat] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seat " + seat + " is listed twice"})
+			return
+		}
+		requested[seat] = true
+	}
+
+	// A seat may only be in one pending transfer at a time
+	pending, err := h.Mongo.Collection("transfers").CountDocuments(ctx, bson.M{
+		"booking_id": bookingID,
+		"status":     models.TransferStatusPending,
+		"seats":      bson.M{"$in": req.Seats},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check transfers"})
+		return
+	}
+	if pending > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "some seats already have a pending transfer"})
+		return
+	}
+
+	var recipient models.User
+	toEmail := strings.TrimSpace(req.ToEmail)
+	emailFilter := bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(toEmail) + "$", Options: "i"}}
+	if err := h.Mongo.Collection("users").FindOne(ctx, emailFilter).Decode(&recipient); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no user account found for " + toEmail})
+		return
+	}
+	if recipient.ID == booking.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot transfer seats to the booking's owner"})
+		return
+	}
+
+	transfer := models.Transfer{
+		ID:         primitive.NewObjectID(),
+		BookingID:  bookingID,
+		ShowtimeID: booking.ShowtimeID,
+		FromUserID: booking.UserID,
+		ToUserID:   recipient.ID,
+		ToEmail:    recipient.Email,
+		Seats:      req.Seats,
+		Status:     models.TransferStatusPending,
+		CreatedBy:  userOID,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if _, err := h.Mongo.Collection("transfers").InsertOne(ctx, transfer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create transfer"})
+		return
+	}
+
+	h.audit(ctx, "TRANSFER_STARTED", userOID, transfer, nil)
+
+	var sender models.User
+	h.Mongo.Collection("users").FindOne(ctx, bson.M{"_id": booking.UserID}).Decode(&sender)
+	go func() {
+		err := h.Email.SendTransferOffer(services.TransferData{
+			ToEmail:    recipient.Email,
+			FromName:   sender.Name,
+			ToName:     recipient.Name,
+			TransferID: transfer.ID.Hex(),
+			BookingID:  bookingID.Hex(),
+			Seats:      transfer.Seats,
+		})
+		if err != nil {
+			log.Printf("Email send error: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusCreated, transfer)
+}
+
+// ListTransfers returns the pending transfers sent to and by the user.
+func (h *TransferHandler) ListTransfers(c *gin.Context) {
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	find := func(field string) ([]models.Transfer, error) {
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		cursor, err := h.Mongo.Collection("transfers").Find(ctx, bson.M{
+			field:    userOID,
+			"status": models.TransferStatusPending,
+		}, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer cursor.Close(ctx)
+
+		transfers := []models.Transfer{}
+		if err := cursor.All(ctx, &transfers); err != nil {
+			return nil, err
+		}
+		return transfers, nil
+	}
+
+	incoming, err := find("to_user_id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch transfers"})
+		return
+	}
+	outgoing, err := find("from_user_id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"incoming": incoming, "outgoing": outgoing})
+}
+
+// AcceptTransfer moves the transferred seats out of the sender's booking
+// into a new BOOKED booking owned by the recipient. The source booking
+// becomes TRANSFERRED once it has no seats left.
+func (h *TransferHandler) AcceptTransfer(c *gin.Context) {
+	transferID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transfer id"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	var transfer models.Transfer
+	err = h.Mongo.Collection("transfers").FindOne(ctx, bson.M{
+		"_id":    transferID,
+		"status": models.TransferStatusPending,
+	}).Decode(&transfer)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transfer not found or no longer pending"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionAcceptTransfer, authz.Transfer(transfer)) {
+		return
+	}
+
+	if err := h.checkShowtimeNotStarted(ctx, transfer.ShowtimeID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	var source models.Booking
+	err = h.Mongo.Collection("bookings").FindOne(ctx, bson.M{
+		"_id":    transfer.BookingID,
+		"status": models.BookingStatusBooked,
+		"seats":  bson.M{"$all": transfer.Seats},
+	}).Decode(&source)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "the sender's booking no longer holds these seats"})
+		return
+	}
+	if source.Exchange != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": errExchangePending.Error()})
+		return
+	}
+
+	moving := make(map[string]bool, len(transfer.Seats))
+	for _, seat := range transfer.Seats {
+		moving[seat] = true
+	}
+	remaining := []string{}
+	for _, seat := range source.Seats {
+		if !moving[seat] {
+			remaining = append(remaining, seat)
+		}
+	}
+	sourceStatus := models.BookingStatusBooked
+	if len(remaining) == 0 {
+		sourceStatus = models.BookingStatusTransferred
+	}
+
+	now := time.Now()
+	newBookingID := primitive.NewObjectID()
+	record := models.TransferRecord{
+		TransferID:    transfer.ID,
+		FromBookingID: source.ID,
+		ToBookingID:   newBookingID,
+		FromUserID:    transfer.FromUserID,
+		ToUserID:      userOID,
+		Seats:         transfer.Seats,
+		TransferredAt: now,
+	}
+	newBooking := models.Booking{
+		ID:         newBookingID,
+		UserID:     userOID,
+		ShowtimeID: transfer.ShowtimeID,
+		Seats:      transfer.Seats,
+		Status:     models.BookingStatusBooked,
+		Prices:     pricesFor(source, transfer.Seats),
+		Transfers:  []models.TransferRecord{record},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	err = h.Mongo.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := h.Mongo.Collection("transfers").UpdateOne(sc,
+			bson.M{"_id": transfer.ID, "status": models.TransferStatusPending},
+			bson.M{"$set": bson.M{
+				"status":         models.TransferStatusAccepted,
+				"new_booking_id": newBookingID,
+				"updated_at":     now,
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errTransferStateChanged
+		}
+
+		result, err = h.Mongo.Collection("bookings").UpdateOne(sc,
+			bson.M{"_id": source.ID, "status": models.BookingStatusBooked, "seats": source.Seats},
+			bson.M{
+				"$set":  bson.M{"seats": remaining, "status": sourceStatus, "updated_at": now},
+				"$push": bson.M{"transfers": record},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errBookingStateChanged
+		}
+
+		if _, err := h.Mongo.Collection("bookings").InsertOne(sc, newBooking); err != nil {
+			return err
+		}
+
+		result, err = h.Mongo.Collection("seat_reservations").UpdateMany(sc,
+			bson.M{
+				"showtime_id": transfer.ShowtimeID,
+				"seat_code":   bson.M{"$in": transfer.Seats},
+				"booking_id":  source.ID,
+				"state":       models.SeatStateBooked,
+			},
+			bson.M{"$set": bson.M{
+				"locked_by_user_id": userOID,
+				"booking_id":        newBookingID,
+				"updated_at":        now,
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		if int(result.ModifiedCount) != len(transfer.Seats) {
+			return errBookingStateChanged
+		}
+		return nil
+	}, func(ctx context.Context) {
+		h.Mongo.Collection("seat_reservations").UpdateMany(ctx,
+			bson.M{"showtime_id": transfer.ShowtimeID, "booking_id": newBookingID},
+			bson.M{"$set": bson.M{
+				"locked_by_user_id": transfer.FromUserID,
+				"booking_id":        source.ID,
+				"updated_at":        time.Now(),
+			}},
+		)
+		h.Mongo.Collection("bookings").DeleteOne(ctx, bson.M{"_id": newBookingID})
+		h.Mongo.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": source.ID},
+			bson.M{
+				"$set":  bson.M{"seats": source.Seats, "status": source.Status, "updated_at": time.Now()},
+				"$pull": bson.M{"transfers": bson.M{"transfer_id": transfer.ID}},
+			},
+		)
+		h.Mongo.Collection("transfers").UpdateOne(ctx,
+			bson.M{"_id": transfer.ID, "status": models.TransferStatusAccepted},
+			bson.M{
+				"$set":   bson.M{"status": models.TransferStatusPending, "updated_at": time.Now()},
+				"$unset": bson.M{"new_booking_id": ""},
+			},
+		)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errTransferStateChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": "transfer is no longer pending"})
+		case errors.Is(err, errBookingStateChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": "the sender's booking changed, please try again"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept transfer"})
+		}
+		return
+	}
+
+	h.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: transfer.ShowtimeID,
+		Seats:      transfer.Seats,
+		From:       models.SeatStateBooked,
+		To:         models.SeatStateBooked,
+		Reason:     models.SeatChangeTransfer,
+		Note:       "from booking " + source.ID.Hex(),
+		Actor:      &userOID,
+		BookingID:  &newBookingID,
+	})
+
+	transfer.Status = models.TransferStatusAccepted
+	transfer.NewBookingID = &newBookingID
+	h.audit(ctx, "TRANSFER_ACCEPTED", userOID, transfer, map[string]interface{}{
+		"new_booking_id":   newBookingID.Hex(),
+		"remaining_seats":  remaining,
+		"source_status":    sourceStatus,
+		"from_booking_id":  source.ID.Hex(),
+		"transferred_from": transfer.FromUserID.Hex(),
+	})
+	h.notifyAccepted(ctx, transfer)
+
+	c.JSON(http.StatusOK, gin.H{
+		"transferId": transfer.ID.Hex(),
+		"bookingId":  newBookingID.Hex(),
+		"seats":      transfer.Seats,
+		"status":     models.TransferStatusAccepted,
+	})
+}
+
+// CancelTransfer withdraws a pending transfer. Either the sender or the
+// recipient (declining it) may cancel.
+func (h *TransferHandler) CancelTransfer(c *gin.Context) {
+	transferID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transfer id"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	var transfer models.Transfer
+	err = h.Mongo.Collection("transfers").FindOne(ctx, bson.M{
+		"_id":    transferID,
+		"status": models.TransferStatusPending,
+	}).Decode(&transfer)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transfer not found or no longer pending"})
+		return
+	}
+	if !authorize(c, h.Authz, authz.ActionCancelTransfer, authz.Transfer(transfer)) {
+		return
+	}
+
+	result, err := h.Mongo.Collection("transfers").UpdateOne(ctx,
+		bson.M{"_id": transferID, "status": models.TransferStatusPending},
+		bson.M{"$set": bson.M{"status": models.TransferStatusCancelled, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel transfer"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "transfer is no longer pending"})
+		return
+	}
+	transfer.Status = models.TransferStatusCancelled
+
+	h.audit(ctx, "TRANSFER_CANCELLED", userOID, transfer, nil)
+
+	c.JSON(http.StatusOK, gin.H{"status": models.TransferStatusCancelled})
+}
+
+func (h *TransferHandler) checkShowtimeNotStarted(ctx context.Context, showtimeID primitive.ObjectID) error {
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": showtimeID}).Decode(&showtime); err != nil {
+		return errors.New("showtime not found")
+	}
+	if !showtime.StartTime.After(time.Now()) {
+		return errors.New("showtime has already started")
+	}
+	return nil
+}
+
+func (h *TransferHandler) audit(ctx context.Context, eventType string, userOID primitive.ObjectID, transfer models.Transfer, extra map[string]interface{}) {
+	payload := map[string]interface{}{
+		"transfer_id":  transfer.ID.Hex(),
+		"from_user_id": transfer.FromUserID.Hex(),
+		"to_user_id":   transfer.ToUserID.Hex(),
+		"to_email":     transfer.ToEmail,
+		"seats":        transfer.Seats,
+		"created_by":   transfer.CreatedBy.Hex(),
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+
+	auditLog := models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		EventType:  eventType,
+		UserID:     &userOID,
+		ShowtimeID: &transfer.ShowtimeID,
+		BookingID:  &transfer.BookingID,
+		Payload:    payload,
+		CreatedAt:  time.Now(),
+	}
+	if _, err := h.Mongo.Collection("audit_logs").InsertOne(ctx, auditLog); err != nil {
+		log.Printf("Transfer: failed to write %s audit log: %v", eventType, err)
+	}
+}
+
+// notifyAccepted emails both the sender and the recipient.
+func (h *TransferHandler) notifyAccepted(ctx context.Context, transfer models.Transfer) {
+	var sender, recipient models.User
+	if err := h.Mongo.Collection("users").FindOne(ctx, bson.M{"_id": transfer.FromUserID}).Decode(&sender); err != nil {
+		log.Printf("Transfer: failed to get user %s: %v", transfer.FromUserID.Hex(), err)
+		return
+	}
+	if err := h.Mongo.Collection("users").FindOne(ctx, bson.M{"_id": transfer.ToUserID}).Decode(&recipient); err != nil {
+		log.Printf("Transfer: failed to get user %s: %v", transfer.ToUserID.Hex(), err)
+		return
+	}
+
+	data := services.TransferData{
+		ToEmail:    recipient.Email,
+		FromName:   sender.Name,
+		ToName:     recipient.Name,
+		TransferID: transfer.ID.Hex(),
+		BookingID:  transfer.NewBookingID.Hex(),
+		Seats:      transfer.Seats,
+	}
+	go func() {
+		for _, to := range []string{sender.Email, recipient.Email} {
+			if err := h.Email.SendTransferCompleted(to, data); err != nil {
+				log.Printf("Email send error: %v", err)
+			}
+		}
+	}()
+}
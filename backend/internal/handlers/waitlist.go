@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/seating"
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WaitlistHandler struct {
+	Mongo    *services.MongoService
+	Email    *services.EmailService
+	Bookings *BookingHandler
+	OfferTTL time.Duration
+
+	// offerMu serialises offer rounds within this process so they do not
+	// race each other for the same seats. Across processes the Redis locks
+	// and seat reservations still keep two holds off the same seat.
+	offerMu sync.Mutex
+}
+
+type JoinWaitlistRequest struct {
+	PartySize int `json:"partySize" binding:"required,min=1"`
+}
+
+func (h *WaitlistHandler) JoinWaitlist(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	var req JoinWaitlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": showtimeID}).Decode(&showtime); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "showtime not found"})
+		return
+	}
+
+	if limit := h.Bookings.purchaseLimits(showtime).MaxSeatsPerBooking; limit > 0 && req.PartySize > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "party size exceeds the seats allowed per booking"})
+		return
+	}
+
+	available, err := h.Mongo.Collection("seat_reservations").CountDocuments(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"state":       models.SeatStateAvailable,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch seats"})
+		return
+	}
+	if int(available) >= req.PartySize {
+		c.JSON(http.StatusConflict, gin.H{"error": "seats are still available, book them directly"})
+		return
+	}
+
+	count, err := h.Mongo.Collection("waitlist").CountDocuments(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"user_id":     userOID,
+		"status":      bson.M{"$in": []string{models.WaitlistStatusWaiting, models.WaitlistStatusOffered}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check waitlist"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "already on the waitlist for this showtime"})
+		return
+	}
+
+	entry := models.WaitlistEntry{
+		ID:         primitive.NewObjectID(),
+		ShowtimeID: showtimeID,
+		UserID:     userOID,
+		PartySize:  req.PartySize,
+		Status:     models.WaitlistStatusWaiting,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if _, err := h.Mongo.Collection("waitlist").InsertOne(ctx, entry); err != nil {
+		// A concurrent join got in between the check and the insert
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "already on the waitlist for this showtime"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to join waitlist"})
+		return
+	}
+
+	position, _ := h.position(ctx, entry)
+	c.JSON(http.StatusCreated, gin.H{"entry": entry, "position": position})
+}
+
+func (h *WaitlistHandler) GetWaitlistStatus(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	entry, err := h.activeEntry(ctx, showtimeID, userOID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not on the waitlist for this showtime"})
+		return
+	}
+
+	resp := gin.H{"entry": entry}
+	if entry.Status == models.WaitlistStatusWaiting {
+		position, err := h.position(ctx, *entry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute position"})
+			return
+		}
+		resp["position"] = position
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *WaitlistHandler) LeaveWaitlist(c *gin.Context) {
+	showtimeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid showtime id"})
+		return
+	}
+
+	ctx := context.Background()
+	userIdStr, _ := c.Get("user_id")
+	userOID, _ := primitive.ObjectIDFromHex(userIdStr.(string))
+
+	result, err := h.Mongo.Collection("waitlist").UpdateOne(ctx,
+		bson.M{"showtime_id": showtimeID, "user_id": userOID, "status": models.WaitlistStatusWaiting},
+		bson.M{"$set": bson.M{"status": models.WaitlistStatusLeft, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to leave waitlist"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		// An entry that already got an offer is left by cancelling the held booking
+		c.JSON(http.StatusNotFound, gin.H{"error": "no waiting entry for this showtime"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": models.WaitlistStatusLeft})
+}
+
+// activeEntry returns the user's WAITING or OFFERED entry for a showtime,
+// settling the offer first if its booking has been confirmed or released.
+func (h *WaitlistHandler) activeEntry(ctx context.Context, showtimeID, userOID primitive.ObjectID) (*models.WaitlistEntry, error) {
+	h.settleOffers(ctx, showtimeID)
+
+	var entry models.WaitlistEntry
+	err := h.Mongo.Collection("waitlist").FindOne(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"user_id":     userOID,
+		"status":      bson.M{"$in": []string{models.WaitlistStatusWaiting, models.WaitlistStatusOffered}},
+	}).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// position is the 1-based place of a WAITING entry in its showtime queue.
+func (h *WaitlistHandler) position(ctx context.Context, entry models.WaitlistEntry) (int, error) {
+	ahead, err := h.Mongo.Collection("waitlist").CountDocuments(ctx, bson.M{
+		"showtime_id": entry.ShowtimeID,
+		"status":      models.WaitlistStatusWaiting,
+		"created_at":  bson.M{"$lt": entry.CreatedAt},
+	})
+	return int(ahead) + 1, err
+}
+
+// OfferReleasedSeats is called whenever seats of a showtime go back to
+// AVAILABLE. Waiting entries are visited in FIFO order and every entry whose
+// party fits the free seats gets an exclusive hold: a LOCKED booking in the
+// user's name that expires after OfferTTL. An expired offer releases its
+// seats through the timeout worker, which triggers the next round.
+func (h *WaitlistHandler) OfferReleasedSeats(showtimeID primitive.ObjectID) {
+	h.offerMu.Lock()
+	defer h.offerMu.Unlock()
+
+	ctx := context.Background()
+	h.settleOffers(ctx, showtimeID)
+
+	var showtime models.Showtime
+	if err := h.Mongo.Collection("showtimes").FindOne(ctx, bson.M{"_id": showtimeID}).Decode(&showtime); err != nil {
+		return
+	}
+	if showtime.StartTime.Before(time.Now()) {
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := h.Mongo.Collection("waitlist").Find(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"status":      models.WaitlistStatusWaiting,
+	}, opts)
+	if err != nil {
+		log.Printf("Waitlist: failed to query entries: %v", err)
+		return
+	}
+	var entries []models.WaitlistEntry
+	if err := cursor.All(ctx, &entries); err != nil || len(entries) == 0 {
+		return
+	}
+
+	seatmap, err := h.Bookings.loadSeatmap(ctx, showtime)
+	if err != nil {
+		return
+	}
+	available, err := h.Bookings.availableSeats(ctx, showtimeID)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if len(available) == 0 {
+			return
+		}
+
+		// An offer is a booking like any other; skip users it would put
+		// over their purchase limits
+		if err := h.Bookings.checkPurchaseLimits(ctx, showtime, entry.UserID, nil, entry.PartySize); err != nil {
+			continue
+		}
+
+		isAvailable := func(code string) bool { return available[code] }
+		var seats []string
+		if blocks := seating.BestBlocks(seatmap, isAvailable, entry.PartySize, ""); len(blocks) > 0 {
+			seats = blocks[0].Seats
+		} else if split := seating.SplitSuggestion(seatmap, isAvailable, entry.PartySize, ""); split != nil {
+			for _, block := range split {
+				seats = append(seats, block.Seats...)
+			}
+		} else {
+			continue
+		}
+
+		booking, err := h.Bookings.holdSeats(ctx, showtime, entry.UserID.Hex(), seats, h.OfferTTL, models.SeatChangeWaitlistOffer)
+		if err != nil {
+			var conflict *seatConflictError
+			var rule *bookingRuleError
+			if !errors.As(err, &conflict) && !errors.As(err, &rule) {
+				log.Printf("Waitlist: failed to hold seats for entry %s: %v", entry.ID.Hex(), err)
+			}
+			continue
+		}
+		for _, seat := range seats {
+			delete(available, seat)
+		}
+
+		result, err := h.Mongo.Collection("waitlist").UpdateOne(ctx,
+			bson.M{"_id": entry.ID, "status": models.WaitlistStatusWaiting},
+			bson.M{"$set": bson.M{
+				"status":           models.WaitlistStatusOffered,
+				"offer_booking_id": booking.ID,
+				"offered_seats":    seats,
+				"offer_expires_at": booking.LockExpiresAt,
+				"updated_at":       time.Now(),
+			}},
+		)
+		if err != nil || result.MatchedCount == 0 {
+			// The user left while we were holding seats; give them back
+			if err := h.Bookings.releaseHold(ctx, *booking, nil, models.SeatChangeCancel); err != nil {
+				log.Printf("Waitlist: failed to release hold %s: %v", booking.ID.Hex(), err)
+				continue
+			}
+			for _, seat := range seats {
+				available[seat] = true
+			}
+			continue
+		}
+
+		h.notifyOffer(ctx, entry, booking)
+	}
+}
+
+// settleOffers closes OFFERED entries whose hold has been confirmed
+// (FULFILLED) or has run out or been cancelled (EXPIRED).
+func (h *WaitlistHandler) settleOffers(ctx context.Context, showtimeID primitive.ObjectID) {
+	cursor, err := h.Mongo.Collection("waitlist").Find(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"status":      models.WaitlistStatusOffered,
+	})
+	if err != nil {
+		return
+	}
+	var offered []models.WaitlistEntry
+	if err := cursor.All(ctx, &offered); err != nil {
+		return
+	}
+
+	for _, entry := range offered {
+		var booking models.Booking
+		err := h.Mongo.Collection("bookings").FindOne(ctx, bson.M{"_id": entry.OfferBookingID}).Decode(&booking)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+
+		status := ""
+		switch {
+		case err == nil && booking.Status == models.BookingStatusBooked:
+			status = models.WaitlistStatusFulfilled
+		case err != nil || booking.Status != models.BookingStatusLocked:
+			status = models.WaitlistStatusExpired
+		}
+		if status == "" {
+			continue
+		}
+
+		h.Mongo.Collection("waitlist").UpdateOne(ctx,
+			bson.M{"_id": entry.ID, "status": models.WaitlistStatusOffered},
+			bson.M{"$set": bson.M{"status": status, "updated_at": time.Now()}},
+		)
+	}
+}
+
+func (h *WaitlistHandler) notifyOffer(ctx context.Context, entry models.WaitlistEntry, booking *models.Booking) {
+	var user models.User
+	if err := h.Mongo.Collection("users").FindOne(ctx, bson.M{"_id": entry.UserID}).Decode(&user); err != nil {
+		log.Printf("Waitlist: failed to get user %s: %v", entry.UserID.Hex(), err)
+		return
+	}
+
+	go func() {
+		err := h.Email.SendWaitlistOffer(services.WaitlistOfferData{
+			UserName:   user.Name,
+			UserEmail:  user.Email,
+			BookingID:  booking.ID.Hex(),
+			Seats:      booking.Seats,
+			ShowtimeID: booking.ShowtimeID.Hex(),
+			ExpiresAt:  booking.LockExpiresAt.Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Printf("Email send error: %v", err)
+		}
+	}()
+}
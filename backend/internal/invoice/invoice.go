@@ -0,0 +1,145 @@
+package invoice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cinema-booking/internal/ledger"
+	"cinema-booking/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Number prefixes of the two invoice series.
+const (
+	seriesAbbreviated = "RC"
+	seriesFull        = "TI"
+)
+
+// HeadOffice is the branch number of a company's head office.
+const HeadOffice = "00000"
+
+// Series returns the number prefix of an invoice type.
+func Series(invoiceType string) string {
+	if invoiceType == models.InvoiceTypeFull {
+		return seriesFull
+	}
+	return seriesAbbreviated
+}
+
+// FormatNumber renders the seq'th invoice of a series in year, e.g.
+// "TI2025-000042".
+func FormatNumber(series string, year int, seq int64) string {
+	return fmt.Sprintf("%s%06d", NumberPrefix(series, year), seq)
+}
+
+// NumberPrefix is the start shared by every number of a series in year,
+// e.g. "TI2025-".
+func NumberPrefix(series string, year int) string {
+	return fmt.Sprintf("%s%d-", series, year)
+}
+
+// Build prices an abbreviated invoice for booking from its priced seats.
+// The amount paid is authoritative: whatever the seats add up to beyond it
+// is shown as discount. description names the show, e.g. the movie and
+// start time. Number and IssuedAt are left for the caller.
+func Build(booking models.Booking, prices []models.SeatPrice, payment models.Payment, seller models.InvoiceParty, description string, vatRate int) models.Invoice {
+	inv := models.Invoice{
+		ID:        primitive.NewObjectID(),
+		Type:      models.InvoiceTypeAbbreviated,
+		BookingID: booking.ID,
+		UserID:    booking.UserID,
+		PaymentID: payment.ID,
+		Seller:    seller,
+		VATRate:   vatRate,
+		Currency:  ledger.Currency,
+	}
+	for _, p := range prices {
+		text := description + ", seat " + p.SeatCode
+		if p.SeatType != "" {
+			text += " (" + p.SeatType + ")"
+		}
+		inv.Lines = append(inv.Lines, models.InvoiceLine{Description: text, SeatCode: p.SeatCode, Amount: p.Price})
+		inv.Subtotal += p.Price
+	}
+
+	inv.Total = payment.Amount
+	if inv.Subtotal > inv.Total {
+		inv.Discount = inv.Subtotal - inv.Total
+	}
+	inv.Net, inv.VAT = SplitVAT(inv.Total, vatRate)
+	return inv
+}
+
+// SplitVAT splits a VAT-inclusive amount into its net amount and the VAT at
+// rate percent, rounding the VAT half up to the nearest satang.
+func SplitVAT(total int64, rate int) (net, vat int64) {
+	if total <= 0 || rate <= 0 {
+		return total, 0
+	}
+	r := int64(rate)
+	vat = (2*total*r + 100 + r) / (2 * (100 + r))
+	return total - vat, vat
+}
+
+// Full turns a copy of an abbreviated invoice into a full tax invoice for
+// buyer. The copy gets a new ID; its Number is left for the caller.
+func Full(abbreviated models.Invoice, buyer models.InvoiceParty) models.Invoice {
+	full := abbreviated
+	full.ID = primitive.NewObjectID()
+	full.Type = models.InvoiceTypeFull
+	full.Number, full.Seq = "", 0
+	full.Buyer = &buyer
+	full.Replaces = abbreviated.Number
+	full.ReplacedBy = ""
+	full.IssuedAt = time.Time{}
+	full.Lines = append([]models.InvoiceLine(nil), abbreviated.Lines...)
+	return full
+}
+
+// ValidTaxID reports whether id is a 13-digit Thai tax identification
+// number with a correct check digit.
+func ValidTaxID(id string) bool {
+	if len(id) != 13 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(id[i]-'0') * (13 - i)
+		}
+	}
+	return (11-sum%11)%10 == int(id[12]-'0')
+}
+
+// ValidBranch reports whether branch is a five-digit branch number.
+func ValidBranch(branch string) bool {
+	if len(branch) != 5 {
+		return false
+	}
+	_, err := strconv.Atoi(branch)
+	return err == nil
+}
+
+// FormatAmount renders minor units as baht with thousands separators, e.g.
+// "1,234.50".
+func FormatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	whole := strconv.FormatInt(amount/100, 10)
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return fmt.Sprintf("%s%s.%02d", sign, b.String(), amount%100)
+}
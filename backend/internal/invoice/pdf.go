@@ -0,0 +1,106 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"cinema-booking/internal/models"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Renderer draws invoices as A4 PDFs. The built-in Helvetica only covers
+// Latin-1; FontPath, a TrueType font such as Sarabun, is needed to print
+// Thai names and addresses.
+type Renderer struct {
+	FontPath string
+	Location *time.Location
+}
+
+// PDF renders inv.
+func (r *Renderer) PDF(inv models.Invoice) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetTitle(inv.Number, true)
+	pdf.AddPage()
+
+	family, text := "Helvetica", pdf.UnicodeTranslatorFromDescriptor("")
+	if r.FontPath != "" {
+		family, text = "body", func(s string) string { return s }
+		pdf.AddUTF8Font(family, "", r.FontPath)
+		pdf.AddUTF8Font(family, "B", r.FontPath)
+	}
+	loc := r.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	title := "RECEIPT / ABBREVIATED TAX INVOICE"
+	if inv.Type == models.InvoiceTypeFull {
+		title = "TAX INVOICE"
+	}
+	pdf.SetFont(family, "B", 16)
+	pdf.CellFormat(0, 10, title, "", 1, "L", false, 0, "")
+
+	pdf.SetFont(family, "", 10)
+	pdf.CellFormat(0, 6, "No. "+inv.Number, "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Date "+inv.IssuedAt.In(loc).Format("2 Jan 2006 15:04"), "", 1, "L", false, 0, "")
+	if inv.Replaces != "" {
+		pdf.CellFormat(0, 6, "Replaces abbreviated tax invoice "+inv.Replaces, "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	party := func(label string, p models.InvoiceParty) {
+		pdf.SetFont(family, "B", 10)
+		pdf.CellFormat(0, 6, label, "", 1, "L", false, 0, "")
+		pdf.SetFont(family, "", 10)
+		pdf.CellFormat(0, 5, text(p.Name), "", 1, "L", false, 0, "")
+		if p.Address != "" {
+			pdf.MultiCell(0, 5, text(p.Address), "", "L", false)
+		}
+		branch := "Head office"
+		if p.Branch != "" && p.Branch != HeadOffice {
+			branch = "Branch " + p.Branch
+		}
+		pdf.CellFormat(0, 5, fmt.Sprintf("Tax ID %s (%s)", p.TaxID, branch), "", 1, "L", false, 0, "")
+		pdf.Ln(3)
+	}
+	party("Seller", inv.Seller)
+	if inv.Buyer != nil {
+		party("Buyer", *inv.Buyer)
+	}
+
+	pdf.SetFont(family, "B", 10)
+	pdf.CellFormat(130, 7, "Description", "B", 0, "L", false, 0, "")
+	pdf.CellFormat(40, 7, "Amount ("+inv.Currency+")", "B", 1, "R", false, 0, "")
+	pdf.SetFont(family, "", 10)
+	for _, l := range inv.Lines {
+		pdf.CellFormat(130, 6, text(l.Description), "", 0, "L", false, 0, "")
+		pdf.CellFormat(40, 6, FormatAmount(l.Amount), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	total := func(label string, amount int64, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont(family, style, 10)
+		pdf.CellFormat(130, 6, label, "", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 6, FormatAmount(amount), "", 1, "R", false, 0, "")
+	}
+	total("Subtotal", inv.Subtotal, false)
+	if inv.Discount > 0 {
+		total("Discount", -inv.Discount, false)
+	}
+	total(fmt.Sprintf("Total (VAT %d%% included)", inv.VATRate), inv.Total, true)
+	total("Value before VAT", inv.Net, false)
+	total(fmt.Sprintf("VAT %d%%", inv.VATRate), inv.VAT, false)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,174 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"cinema-booking/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Currency is the currency every entry is posted in.
+const Currency = "THB"
+
+// Accounts. Money a payment provider has collected for us sits in that
+// provider's asset account (see ProviderAccount) until it is paid out.
+// Discounts and refunds are contra-revenue: they are debited rather than
+// taken off ticket sales, so gross sales stay visible.
+const (
+	AccountTicketSales = "revenue:tickets"
+	AccountDiscounts   = "revenue:discounts"
+	AccountRefunds     = "revenue:refunds"
+	AccountGiftCards   = "liabilities:gift_cards"
+	AccountFees        = "expenses:payment_fees"
+
+	providerAccountPrefix = "assets:provider:"
+)
+
+var ErrUnbalanced = errors.New("journal entry does not balance")
+
+// ProviderAccount is the asset account of a payment provider, e.g.
+// "assets:provider:gateway".
+func ProviderAccount(provider string) string {
+	return providerAccountPrefix + strings.ToLower(provider)
+}
+
+// ToMinor converts an amount in baht to satang.
+func ToMinor(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// FromMinor converts satang to baht.
+func FromMinor(amount int64) float64 {
+	return float64(amount) / 100
+}
+
+// ChargeKey and RefundKey are the keys of the entries recording a payment
+// and a refund.
+func ChargeKey(paymentID primitive.ObjectID) string { return "charge:" + paymentID.Hex() }
+func RefundKey(refundID primitive.ObjectID) string  { return "refund:" + refundID.Hex() }
+
+// Charge records a successful payment: the amount paid is owed by the
+// provider and the promo discount, if any, makes up the rest of the gross
+// ticket price.
+func Charge(payment models.Payment) models.JournalEntry {
+	paid, discount := payment.Amount, payment.Discount
+	entry := newEntry(ChargeKey(payment.ID), models.JournalCharge)
+	entry.BookingID = &payment.BookingID
+	entry.PaymentID = &payment.ID
+	entry.Lines = lines(
+		debit(ProviderAccount(payment.Provider), paid),
+		debit(AccountDiscounts, discount),
+		credit(AccountTicketSales, paid+discount),
+	)
+	return entry
+}
+
+// Refund records money returned through the provider that took payment.
+func Refund(refund models.Refund, payment models.Payment) models.JournalEntry {
+	amount := refund.Amount
+	entry := newEntry(RefundKey(refund.ID), models.JournalRefund)
+	entry.BookingID = &refund.BookingID
+	entry.PaymentID = &refund.PaymentID
+	entry.RefundID = &refund.ID
+	entry.Lines = lines(
+		debit(AccountRefunds, amount),
+		credit(ProviderAccount(payment.Provider), amount),
+	)
+	return entry
+}
+
+// GiftCardRedemption records tickets paid for with gift card balance.
+// reference identifies the redemption, e.g. the gift card transaction ID.
+func GiftCardRedemption(reference string, amount int64) models.JournalEntry {
+	entry := newEntry("gift_card:"+reference, models.JournalGiftCardRedemption)
+	entry.Lines = lines(
+		debit(AccountGiftCards, amount),
+		credit(AccountTicketSales, amount),
+	)
+	return entry
+}
+
+// Fee records a provider's processing fee, which it keeps out of the money
+// it collected. reference identifies the fee on the provider's statement.
+func Fee(provider, reference string, amount int64) models.JournalEntry {
+	entry := newEntry("fee:"+strings.ToLower(provider)+":"+reference, models.JournalFee)
+	entry.Lines = lines(
+		debit(AccountFees, amount),
+		credit(ProviderAccount(provider), amount),
+	)
+	return entry
+}
+
+// Validate checks that entry has lines, that each line is a positive debit
+// or credit, and that debits equal credits.
+func Validate(entry models.JournalEntry) error {
+	if len(entry.Lines) < 2 {
+		return fmt.Errorf("%w: %s has %d lines", ErrUnbalanced, entry.Key, len(entry.Lines))
+	}
+	var debits, credits int64
+	for _, l := range entry.Lines {
+		if l.Debit < 0 || l.Credit < 0 || (l.Debit == 0) == (l.Credit == 0) {
+			return fmt.Errorf("%w: %s has an invalid line for %s", ErrUnbalanced, entry.Key, l.Account)
+		}
+		debits += l.Debit
+		credits += l.Credit
+	}
+	if debits != credits {
+		return fmt.Errorf("%w: %s debits %d, credits %d", ErrUnbalanced, entry.Key, debits, credits)
+	}
+	return nil
+}
+
+// Total returns what an entry moves: the sum of its debits.
+func Total(entry models.JournalEntry) int64 {
+	var total int64
+	for _, l := range entry.Lines {
+		total += l.Debit
+	}
+	return total
+}
+
+// Posted returns the net amount an entry debits to account.
+func Posted(entry models.JournalEntry, account string) int64 {
+	var net int64
+	for _, l := range entry.Lines {
+		if l.Account == account {
+			net += l.Debit - l.Credit
+		}
+	}
+	return net
+}
+
+func newEntry(key, kind string) models.JournalEntry {
+	return models.JournalEntry{
+		ID:        primitive.NewObjectID(),
+		Key:       key,
+		Kind:      kind,
+		Currency:  Currency,
+		CreatedAt: time.Now(),
+	}
+}
+
+func debit(account string, amount int64) models.JournalLine {
+	return models.JournalLine{Account: account, Debit: amount}
+}
+
+func credit(account string, amount int64) models.JournalLine {
+	return models.JournalLine{Account: account, Credit: amount}
+}
+
+// lines drops zero lines, e.g. the discount of a payment without a promo.
+func lines(all ...models.JournalLine) []models.JournalLine {
+	kept := make([]models.JournalLine, 0, len(all))
+	for _, l := range all {
+		if l.Debit != 0 || l.Credit != 0 {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
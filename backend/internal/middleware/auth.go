@@ -5,8 +5,11 @@ import (
 	"strings"
 	"time"
 
+	"cinema-booking/internal/authz"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
@@ -30,10 +33,16 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// AdminMiddleware lets a request through only when the authz policy allows
+// the caller to administer the system. Denials are audited by the
+// Authorizer.
+func AdminMiddleware(az *authz.Authorizer) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, _ := c.Get("user_role")
-		if role != "ADMIN" {
+		userIdStr := c.GetString("user_id")
+		userOID, _ := primitive.ObjectIDFromHex(userIdStr)
+		sub := authz.Subject{UserID: userOID, Role: c.GetString("user_role")}
+
+		if err := az.Authorize(c.Request.Context(), sub, authz.ActionAdminister, authz.System); err != nil {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin access required"})
 			return
 		}
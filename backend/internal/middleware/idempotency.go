@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"cinema-booking/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const IdempotencyHeader = "Idempotency-Key"
+
+// idempotencyPendingTTL bounds how long the placeholder of a request still
+// being handled blocks its key, so a key whose request died with the process
+// can be retried soon after.
+const idempotencyPendingTTL = time.Minute
+
+type idempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Completed   bool   `json:"completed"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes a route safe to retry. The first response for
+// an Idempotency-Key is stored in Redis for ttl and replayed for later
+// requests with the same key. Reusing a key with a different payload is
+// rejected. Requests without the header pass through unchanged.
+//
+// While the first request is handled its key holds a placeholder that expires
+// after idempotencyPendingTTL; ttl applies once the response is stored.
+func IdempotencyMiddleware(redis *services.RedisService, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		userId := c.GetString("user_id")
+		ctx := context.Background()
+
+		pending, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+		reserved, err := redis.ReserveIdempotencyKey(ctx, userId, key, pending, idempotencyPendingTTL)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "idempotency store unavailable"})
+			return
+		}
+
+		if !reserved {
+			raw, err := redis.GetIdempotencyRecord(ctx, userId, key)
+			if err != nil || raw == nil {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "request with this Idempotency-Key is still in progress"})
+				return
+			}
+
+			var record idempotencyRecord
+			if err := json.Unmarshal(raw, &record); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "corrupt idempotency record"})
+				return
+			}
+			if record.Fingerprint != fingerprint {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+				return
+			}
+			if !record.Completed {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "request with this Idempotency-Key is still in progress"})
+				return
+			}
+
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.Status, record.ContentType, record.Body)
+			c.Abort()
+			return
+		}
+
+		// A panicking handler stores nothing; free the key before Recovery
+		// answers so the client can retry
+		defer func() {
+			if r := recover(); r != nil {
+				redis.DeleteIdempotencyRecord(ctx, userId, key)
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors are not stored so the client can retry with the same key
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			redis.DeleteIdempotencyRecord(ctx, userId, key)
+			return
+		}
+
+		record, _ := json.Marshal(idempotencyRecord{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			Status:      status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		redis.SaveIdempotencyRecord(ctx, userId, key, record, ttl)
+	}
+}
@@ -14,5 +14,7 @@ type AuditLog struct {
 	SeatCode   string              `bson:"seat_code,omitempty" json:"seatCode,omitempty"`
 	BookingID  *primitive.ObjectID `bson:"booking_id,omitempty" json:"bookingId,omitempty"`
 	Payload    map[string]any      `bson:"payload,omitempty" json:"payload,omitempty"`
-	CreatedAt  time.Time           `bson:"created_at" json:"createdAt"`
+	// EventID is set on logs written by the event consumer, one per event.
+	EventID   string    `bson:"event_id,omitempty" json:"eventId,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"createdAt"`
 }
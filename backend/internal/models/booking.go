@@ -7,20 +7,49 @@ import (
 )
 
 const (
-	BookingStatusLocked    = "LOCKED"
-	BookingStatusBooked    = "BOOKED"
-	BookingStatusCancelled = "CANCELLED"
-	BookingStatusExpired   = "EXPIRED"
+	BookingStatusLocked      = "LOCKED"
+	BookingStatusBooked      = "BOOKED"
+	BookingStatusCancelled   = "CANCELLED"
+	BookingStatusExpired     = "EXPIRED"
+	BookingStatusRefunded    = "REFUNDED"
+	BookingStatusTransferred = "TRANSFERRED"
+
+	// BookingStatusRefunding marks a booking whose refund is being paid
+	// out. Nothing else may change it until it is REFUNDED.
+	BookingStatusRefunding = "REFUNDING"
 )
 
 type Booking struct {
-	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
-	UserID        primitive.ObjectID  `bson:"user_id" json:"userId"`
-	ShowtimeID    primitive.ObjectID  `bson:"showtime_id" json:"showtimeId"`
-	Seats         []string            `bson:"seats" json:"seats"`
-	Status        string              `bson:"status" json:"status"`
-	LockExpiresAt *time.Time          `bson:"lock_expires_at,omitempty" json:"lockExpiresAt,omitempty"`
-	PaymentID     *primitive.ObjectID `bson:"payment_id,omitempty" json:"paymentId,omitempty"`
-	CreatedAt     time.Time           `bson:"created_at" json:"createdAt"`
-	UpdatedAt     time.Time           `bson:"updated_at" json:"updatedAt"`
+	ID            primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID   `bson:"user_id" json:"userId"`
+	ShowtimeID    primitive.ObjectID   `bson:"showtime_id" json:"showtimeId"`
+	Seats         []string             `bson:"seats" json:"seats"`
+	Status        string               `bson:"status" json:"status"`
+	LockExpiresAt *time.Time           `bson:"lock_expires_at,omitempty" json:"lockExpiresAt,omitempty"`
+	Extensions    int                  `bson:"extensions" json:"extensions"`
+	PaymentID     *primitive.ObjectID  `bson:"payment_id,omitempty" json:"paymentId,omitempty"`
+	PaymentIDs    []primitive.ObjectID `bson:"payment_ids,omitempty" json:"paymentIds,omitempty"`
+	Prices        []SeatPrice          `bson:"prices,omitempty" json:"prices,omitempty"`
+	Promo         *AppliedPromo        `bson:"promo,omitempty" json:"promo,omitempty"`
+	Transfers     []TransferRecord     `bson:"transfers,omitempty" json:"transfers,omitempty"`
+	Exchange      *PendingExchange     `bson:"exchange,omitempty" json:"exchange,omitempty"`
+	CreatedAt     time.Time            `bson:"created_at" json:"createdAt"`
+	UpdatedAt     time.Time            `bson:"updated_at" json:"updatedAt"`
+}
+
+// PendingExchange is a seat exchange of a BOOKED booking waiting for the
+// payment of its price difference. Added seats are held LOCKED for the
+// booking until ExpiresAt; they replace Removed once the payment succeeds
+// and are released if it fails or does not arrive in time. Difference is in
+// satang.
+type PendingExchange struct {
+	ShowtimeID  primitive.ObjectID `bson:"showtime_id" json:"showtimeId"`
+	Seats       []string           `bson:"seats" json:"seats"`
+	Added       []string           `bson:"added" json:"added"`
+	Removed     []string           `bson:"removed" json:"removed"`
+	Prices      []SeatPrice        `bson:"prices" json:"prices"`
+	Difference  int64              `bson:"difference" json:"difference"`
+	PaymentID   primitive.ObjectID `bson:"payment_id" json:"paymentId"`
+	RequestedBy primitive.ObjectID `bson:"requested_by" json:"requestedBy"`
+	ExpiresAt   time.Time          `bson:"expires_at" json:"expiresAt"`
 }
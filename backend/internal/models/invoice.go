@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of invoice. Every confirmed booking gets an abbreviated tax invoice
+// (a receipt without the buyer's details); a full tax invoice naming the
+// buyer's company replaces it on request.
+const (
+	InvoiceTypeAbbreviated = "ABBREVIATED"
+	InvoiceTypeFull        = "FULL"
+)
+
+// Invoice is a tax invoice for a confirmed booking. Each type is numbered in
+// its own series, per year, without gaps. Amounts are integer minor units
+// (satang) and include VAT; Net and VAT split Total at VATRate percent.
+type Invoice struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number     string             `bson:"number" json:"number"`
+	Seq        int64              `bson:"seq" json:"-"`
+	Type       string             `bson:"type" json:"type"`
+	BookingID  primitive.ObjectID `bson:"booking_id" json:"bookingId"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"userId"`
+	PaymentID  primitive.ObjectID `bson:"payment_id" json:"paymentId"`
+	Seller     InvoiceParty       `bson:"seller" json:"seller"`
+	Buyer      *InvoiceParty      `bson:"buyer,omitempty" json:"buyer,omitempty"`
+	Lines      []InvoiceLine      `bson:"lines" json:"lines"`
+	Subtotal   int64              `bson:"subtotal" json:"subtotal"`
+	Discount   int64              `bson:"discount" json:"discount"`
+	Total      int64              `bson:"total" json:"total"`
+	Net        int64              `bson:"net" json:"net"`
+	VAT        int64              `bson:"vat" json:"vat"`
+	VATRate    int                `bson:"vat_rate" json:"vatRate"`
+	Currency   string             `bson:"currency" json:"currency"`
+	Replaces   string             `bson:"replaces,omitempty" json:"replaces,omitempty"`
+	ReplacedBy string             `bson:"replaced_by,omitempty" json:"replacedBy,omitempty"`
+	IssuedAt   time.Time          `bson:"issued_at" json:"issuedAt"`
+}
+
+// InvoiceParty is the seller or buyer named on an invoice. Branch is the
+// five-digit branch number, "00000" for the head office.
+type InvoiceParty struct {
+	Name    string `bson:"name" json:"name"`
+	TaxID   string `bson:"tax_id" json:"taxId"`
+	Branch  string `bson:"branch" json:"branch"`
+	Address string `bson:"address" json:"address"`
+}
+
+// InvoiceLine is one seat. Amount includes VAT.
+type InvoiceLine struct {
+	Description string `bson:"description" json:"description"`
+	SeatCode    string `bson:"seat_code" json:"seatCode"`
+	Amount      int64  `bson:"amount" json:"amount"`
+}
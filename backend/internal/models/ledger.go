@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of journal entry.
+const (
+	JournalCharge             = "CHARGE"
+	JournalRefund             = "REFUND"
+	JournalGiftCardRedemption = "GIFT_CARD_REDEMPTION"
+	JournalFee                = "FEE"
+)
+
+// JournalEntry is one balanced posting to the ledger: its lines' debits and
+// credits sum to the same amount. Amounts are integer minor units (satang).
+// Key identifies what the entry records, e.g. "charge:<paymentId>", and is
+// unique so nothing is posted twice. Entries are never changed; a mistake is
+// corrected by posting another entry.
+type JournalEntry struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Key       string              `bson:"key" json:"key"`
+	Kind      string              `bson:"kind" json:"kind"`
+	Currency  string              `bson:"currency" json:"currency"`
+	Lines     []JournalLine       `bson:"lines" json:"lines"`
+	BookingID *primitive.ObjectID `bson:"booking_id,omitempty" json:"bookingId,omitempty"`
+	PaymentID *primitive.ObjectID `bson:"payment_id,omitempty" json:"paymentId,omitempty"`
+	RefundID  *primitive.ObjectID `bson:"refund_id,omitempty" json:"refundId,omitempty"`
+	Memo      string              `bson:"memo,omitempty" json:"memo,omitempty"`
+	ActorID   *primitive.ObjectID `bson:"actor_id,omitempty" json:"actorId,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"createdAt"`
+}
+
+// JournalLine debits or credits one account. Exactly one of Debit and Credit
+// is non-zero.
+type JournalLine struct {
+	Account string `bson:"account" json:"account"`
+	Debit   int64  `bson:"debit" json:"debit"`
+	Credit  int64  `bson:"credit" json:"credit"`
+}
+
+// AccountBalance is an account's debit and credit totals. Balance is debits
+// minus credits, so revenue and liability accounts are normally negative.
+type AccountBalance struct {
+	Account string `bson:"_id" json:"account"`
+	Debit   int64  `bson:"debit" json:"debit"`
+	Credit  int64  `bson:"credit" json:"credit"`
+	Balance int64  `bson:"balance" json:"balance"`
+}
@@ -11,5 +11,6 @@ type Movie struct {
 	Title       string             `bson:"title" json:"title"`
 	DurationMin int                `bson:"duration_min" json:"durationMin"`
 	Rating      string             `bson:"rating" json:"rating"`
+	Surcharges  []Surcharge        `bson:"surcharges,omitempty" json:"surcharges,omitempty"`
 	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OutboxStatusPending = "PENDING"
+	OutboxStatusSent    = "SENT"
+)
+
+// OutboxMessage is an event waiting to be published to RabbitMQ. It is
+// inserted in the same transaction as the change it describes and removed
+// from the pending set by the outbox relay once the broker has confirmed it.
+type OutboxMessage struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EventID   string             `bson:"event_id" json:"eventId"`
+	EventType string             `bson:"event_type" json:"eventType"`
+	// Payload is the JSON message body.
+	Payload       []byte     `bson:"payload" json:"payload"`
+	Status        string     `bson:"status" json:"status"`
+	Attempts      int        `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at" json:"nextAttemptAt"`
+	LastError     string     `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	CreatedAt     time.Time  `bson:"created_at" json:"createdAt"`
+	SentAt        *time.Time `bson:"sent_at,omitempty" json:"sentAt,omitempty"`
+}
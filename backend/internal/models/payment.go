@@ -7,17 +7,69 @@ import (
 )
 
 const (
-	PaymentStatusPending = "PENDING"
-	PaymentStatusSuccess = "SUCCESS"
-	PaymentStatusFailed  = "FAILED"
+	PaymentStatusPending  = "PENDING"
+	PaymentStatusSuccess  = "SUCCESS"
+	PaymentStatusFailed   = "FAILED"
+	PaymentStatusExpired  = "EXPIRED"
+	PaymentStatusRefunded = "REFUNDED"
 )
 
+// paymentTransitions is the payment state machine. EXPIRED -> SUCCESS is a
+// provider reporting success after the booking was given up; such payments
+// are refunded straight away.
+var paymentTransitions = map[string][]string{
+	PaymentStatusPending: {PaymentStatusSuccess, PaymentStatusFailed, PaymentStatusExpired},
+	PaymentStatusExpired: {PaymentStatusSuccess},
+	PaymentStatusSuccess: {PaymentStatusRefunded},
+}
+
+// CanTransitionPayment reports whether a payment may move from one status to
+// another.
+func CanTransitionPayment(from, to string) bool {
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentPurposeExchange marks a payment of the price difference of a seat
+// exchange. It is refunded together with the booking's original payment.
+const PaymentPurposeExchange = "EXCHANGE"
+
+// Payment methods a user can choose when paying.
+const (
+	PaymentMethodCard      = "CARD"
+	PaymentMethodPromptPay = "PROMPTPAY"
+)
+
+// Payment is one attempt to pay for a booking. ProviderRef is the
+// provider's intent ID; the provider is told our payment ID as its
+// reference. Amount and Discount are integer minor units (satang).
 type Payment struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	BookingID primitive.ObjectID `bson:"booking_id" json:"bookingId"`
-	Amount    float64            `bson:"amount" json:"amount"`
-	Status    string             `bson:"status" json:"status"`
-	Provider  string             `bson:"provider" json:"provider"`
-	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updatedAt"`
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	BookingID     primitive.ObjectID  `bson:"booking_id" json:"bookingId"`
+	Amount        int64               `bson:"amount" json:"amount"`
+	Discount      int64               `bson:"discount,omitempty" json:"discount,omitempty"`
+	Status        string              `bson:"status" json:"status"`
+	Method        string              `bson:"method,omitempty" json:"method,omitempty"`
+	Purpose       string              `bson:"purpose,omitempty" json:"purpose,omitempty"`
+	Provider      string              `bson:"provider" json:"provider"`
+	ProviderRef   string              `bson:"provider_ref,omitempty" json:"providerRef,omitempty"`
+	QRPayload     string              `bson:"qr_payload,omitempty" json:"qrPayload,omitempty"`
+	FailureReason string              `bson:"failure_reason,omitempty" json:"failureReason,omitempty"`
+	CapturedAt    *time.Time          `bson:"captured_at,omitempty" json:"capturedAt,omitempty"`
+	Transitions   []PaymentTransition `bson:"transitions,omitempty" json:"transitions,omitempty"`
+	CreatedAt     time.Time           `bson:"created_at" json:"createdAt"`
+	UpdatedAt     time.Time           `bson:"updated_at" json:"updatedAt"`
+}
+
+// PaymentTransition records one status change of a payment. From is empty
+// for the status the payment was created with.
+type PaymentTransition struct {
+	From   string    `bson:"from,omitempty" json:"from,omitempty"`
+	To     string    `bson:"to" json:"to"`
+	Reason string    `bson:"reason" json:"reason"`
+	At     time.Time `bson:"at" json:"at"`
 }
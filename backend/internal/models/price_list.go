@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PriceList sets per-seat-type prices for the showtimes it matches. An
+// unset condition matches every showtime. When several lists match, the most
+// specific one that prices a seat's type wins: a showtime-specific list beats
+// a day-of-week one, which beats a time band; Priority breaks ties.
+type PriceList struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Name       string              `bson:"name" json:"name"`
+	ShowtimeID *primitive.ObjectID `bson:"showtime_id,omitempty" json:"showtimeId,omitempty"`
+	// DaysOfWeek uses time.Weekday numbering (0 = Sunday).
+	DaysOfWeek []int     `bson:"days_of_week,omitempty" json:"daysOfWeek,omitempty"`
+	TimeBand   *TimeBand `bson:"time_band,omitempty" json:"timeBand,omitempty"`
+	// Prices maps a seat type (NORMAL, VIP, ...) to the price of one seat
+	// in satang.
+	Prices    map[string]int64 `bson:"prices" json:"prices"`
+	Priority  int              `bson:"priority" json:"priority"`
+	Active    bool             `bson:"active" json:"active"`
+	CreatedAt time.Time        `bson:"created_at" json:"createdAt"`
+	UpdatedAt time.Time        `bson:"updated_at" json:"updatedAt"`
+}
+
+// TimeBand matches showtimes starting in [From, To), both "HH:MM" in the
+// pricing timezone. A band whose To is before its From spans midnight.
+type TimeBand struct {
+	From string `bson:"from" json:"from"`
+	To   string `bson:"to" json:"to"`
+}
+
+// Surcharge is a fixed amount in satang added to every seat, e.g. for a 3D
+// or IMAX presentation.
+type Surcharge struct {
+	Name   string `bson:"name" json:"name"`
+	Amount int64  `bson:"amount" json:"amount"`
+}
+
+// SeatPrice is the price quoted for one seat when it was locked. Base and
+// Price are in satang.
+type SeatPrice struct {
+	SeatCode    string              `bson:"seat_code" json:"seatCode"`
+	SeatType    string              `bson:"seat_type" json:"seatType"`
+	Base        int64               `bson:"base" json:"base"`
+	Surcharges  []Surcharge         `bson:"surcharges,omitempty" json:"surcharges,omitempty"`
+	Price       int64               `bson:"price" json:"price"`
+	PriceListID *primitive.ObjectID `bson:"price_list_id,omitempty" json:"priceListId,omitempty"`
+}
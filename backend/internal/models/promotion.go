@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// PromoTypePercent takes Value percent off the booking.
+	PromoTypePercent = "PERCENT"
+	// PromoTypeFixed takes Value satang off the booking, down to zero.
+	PromoTypeFixed = "FIXED"
+	// PromoTypeBuyGet makes FreeQuantity of every BuyQuantity+FreeQuantity
+	// seats free, cheapest seats first (buy 2 get 1: BuyQuantity 2,
+	// FreeQuantity 1).
+	PromoTypeBuyGet = "BUY_X_GET_Y"
+)
+
+// Promotion is a discount code. Empty MovieIDs and ShowtimeIDs mean the
+// code is valid for every showtime; zero caps mean unlimited.
+type Promotion struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Code           string               `bson:"code" json:"code"`
+	Description    string               `bson:"description" json:"description"`
+	Type           string               `bson:"type" json:"type"`
+	Value          int64                `bson:"value" json:"value"`
+	BuyQuantity    int                  `bson:"buy_quantity,omitempty" json:"buyQuantity,omitempty"`
+	FreeQuantity   int                  `bson:"free_quantity,omitempty" json:"freeQuantity,omitempty"`
+	MovieIDs       []primitive.ObjectID `bson:"movie_ids,omitempty" json:"movieIds,omitempty"`
+	ShowtimeIDs    []primitive.ObjectID `bson:"showtime_ids,omitempty" json:"showtimeIds,omitempty"`
+	ValidFrom      *time.Time           `bson:"valid_from,omitempty" json:"validFrom,omitempty"`
+	ValidUntil     *time.Time           `bson:"valid_until,omitempty" json:"validUntil,omitempty"`
+	MaxRedemptions int                  `bson:"max_redemptions" json:"maxRedemptions"`
+	MaxPerUser     int                  `bson:"max_per_user" json:"maxPerUser"`
+	Redemptions    int                  `bson:"redemptions" json:"redemptions"`
+	Active         bool                 `bson:"active" json:"active"`
+	CreatedAt      time.Time            `bson:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time            `bson:"updated_at" json:"updatedAt"`
+}
+
+// AppliedPromo is the code attached to a booking. Discount is in satang and
+// is recalculated when the booking is paid.
+type AppliedPromo struct {
+	PromotionID primitive.ObjectID `bson:"promotion_id" json:"promotionId"`
+	Code        string             `bson:"code" json:"code"`
+	Discount    int64              `bson:"discount" json:"discount"`
+}
+
+// PromoRedemption records one confirmed use of a code. Discount is in
+// satang.
+type PromoRedemption struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PromotionID primitive.ObjectID `bson:"promotion_id" json:"promotionId"`
+	Code        string             `bson:"code" json:"code"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"userId"`
+	BookingID   primitive.ObjectID `bson:"booking_id" json:"bookingId"`
+	Discount    int64              `bson:"discount" json:"discount"`
+	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// RefundStatusPending marks a refund recorded before the provider was
+	// asked for the money. It stays PENDING until the refund is recorded,
+	// and a retry reuses it and its idempotency key.
+	RefundStatusPending = "PENDING"
+	RefundStatusSuccess = "SUCCESS"
+	// RefundStatusFailed marks a refund the provider rejected after the
+	// booking change was committed; it has to be retried by hand.
+	RefundStatusFailed = "FAILED"
+	// RefundStatusManualRequired marks a refund the provider cannot pay
+	// out, such as a PromptPay payment. It becomes SUCCESS once an admin
+	// confirms the money was transferred back.
+	RefundStatusManualRequired = "MANUAL_REQUIRED"
+)
+
+// Refund returns money from a payment. RequestedBy is zero for refunds the
+// system made on its own, e.g. for a payment that arrived after its booking
+// expired. IdempotencyKey is passed to the provider, which pays out once per
+// key. Amount is in integer minor units (satang).
+type Refund struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BookingID      primitive.ObjectID `bson:"booking_id" json:"bookingId"`
+	PaymentID      primitive.ObjectID `bson:"payment_id" json:"paymentId"`
+	Amount         int64              `bson:"amount" json:"amount"`
+	Reason         string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	Status         string             `bson:"status" json:"status"`
+	IdempotencyKey string             `bson:"idempotency_key,omitempty" json:"-"`
+	RequestedBy    primitive.ObjectID `bson:"requested_by" json:"requestedBy"`
+	// ConfirmedBy is the admin who confirmed a manual transfer.
+	ConfirmedBy *primitive.ObjectID `bson:"confirmed_by,omitempty" json:"confirmedBy,omitempty"`
+	ConfirmedAt *time.Time          `bson:"confirmed_at,omitempty" json:"confirmedAt,omitempty"`
+	CreatedAt   time.Time           `bson:"created_at" json:"createdAt"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reasons recorded with a seat state change.
+const (
+	SeatChangeLock          = "LOCK"
+	SeatChangeWaitlistOffer = "WAITLIST_OFFER"
+	SeatChangeExtend        = "EXTEND"
+	SeatChangeModify        = "MODIFY"
+	SeatChangeConfirm       = "CONFIRM"
+	SeatChangeCancel        = "CANCEL"
+	SeatChangeExpire        = "EXPIRE"
+	SeatChangeRefund        = "REFUND"
+	SeatChangeExchange      = "EXCHANGE"
+	SeatChangeTransfer      = "TRANSFER"
+	SeatChangeBlock         = "BLOCK"
+	SeatChangeUnblock       = "UNBLOCK"
+	SeatChangeReconcile     = "RECONCILE"
+)
+
+// SeatHistory is one transition of a SeatReservation. Entries are only ever
+// appended. ActorID is nil for changes made by the system (lock expiry).
+type SeatHistory struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ShowtimeID primitive.ObjectID  `bson:"showtime_id" json:"showtimeId"`
+	SeatCode   string              `bson:"seat_code" json:"seatCode"`
+	FromState  string              `bson:"from_state" json:"fromState"`
+	ToState    string              `bson:"to_state" json:"toState"`
+	Reason     string              `bson:"reason" json:"reason"`
+	Note       string              `bson:"note,omitempty" json:"note,omitempty"`
+	ActorID    *primitive.ObjectID `bson:"actor_id,omitempty" json:"actorId,omitempty"`
+	BookingID  *primitive.ObjectID `bson:"booking_id,omitempty" json:"bookingId,omitempty"`
+	CreatedAt  time.Time           `bson:"created_at" json:"createdAt"`
+}
@@ -10,8 +10,12 @@ const (
 	SeatStateAvailable = "AVAILABLE"
 	SeatStateLocked    = "LOCKED"
 	SeatStateBooked    = "BOOKED"
+	SeatStateBlocked   = "BLOCKED"
 )
 
+// SeatReservation is one seat of a showtime. The block details are staff
+// notes and never leave the admin seat endpoints, so they are not part of
+// the seat's JSON.
 type SeatReservation struct {
 	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
 	ShowtimeID     primitive.ObjectID  `bson:"showtime_id" json:"showtimeId"`
@@ -20,5 +24,8 @@ type SeatReservation struct {
 	LockedByUserID *primitive.ObjectID `bson:"locked_by_user_id,omitempty" json:"lockedByUserId,omitempty"`
 	LockExpiresAt  *time.Time          `bson:"lock_expires_at,omitempty" json:"lockExpiresAt,omitempty"`
 	BookingID      *primitive.ObjectID `bson:"booking_id,omitempty" json:"bookingId,omitempty"`
+	BlockReason    string              `bson:"block_reason,omitempty" json:"-"`
+	BlockedBy      *primitive.ObjectID `bson:"blocked_by,omitempty" json:"-"`
+	BlockedAt      *time.Time          `bson:"blocked_at,omitempty" json:"-"`
 	UpdatedAt      time.Time           `bson:"updated_at" json:"updatedAt"`
 }
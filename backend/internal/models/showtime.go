@@ -12,5 +12,15 @@ type Showtime struct {
 	StartTime    time.Time          `bson:"start_time" json:"startTime"`
 	AuditoriumID string             `bson:"auditorium_id" json:"auditoriumId"`
 	SeatmapID    string             `bson:"seatmap_id" json:"seatmapId"`
+	GapRule      *bool              `bson:"gap_rule,omitempty" json:"gapRule,omitempty"`
+	Limits       *PurchaseLimits    `bson:"limits,omitempty" json:"limits,omitempty"`
 	CreatedAt    time.Time          `bson:"created_at" json:"createdAt"`
 }
+
+// PurchaseLimits caps how many seats a user can hold. A zero field means
+// "use the server default".
+type PurchaseLimits struct {
+	MaxSeatsPerBooking  int `bson:"max_seats_per_booking,omitempty" json:"maxSeatsPerBooking,omitempty"`
+	MaxLockedBookings   int `bson:"max_locked_bookings,omitempty" json:"maxLockedBookings,omitempty"`
+	MaxSeatsPerShowtime int `bson:"max_seats_per_showtime,omitempty" json:"maxSeatsPerShowtime,omitempty"`
+}
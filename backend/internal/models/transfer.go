@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TransferStatusPending   = "PENDING"
+	TransferStatusAccepted  = "ACCEPTED"
+	TransferStatusCancelled = "CANCELLED"
+)
+
+// Transfer offers seats of a booking to another user. FromUserID is always
+// the booking's owner; CreatedBy is whoever started the transfer, which
+// differs when an admin does it on the owner's behalf.
+type Transfer struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	BookingID    primitive.ObjectID  `bson:"booking_id" json:"bookingId"`
+	ShowtimeID   primitive.ObjectID  `bson:"showtime_id" json:"showtimeId"`
+	FromUserID   primitive.ObjectID  `bson:"from_user_id" json:"fromUserId"`
+	ToUserID     primitive.ObjectID  `bson:"to_user_id" json:"toUserId"`
+	ToEmail      string              `bson:"to_email" json:"toEmail"`
+	Seats        []string            `bson:"seats" json:"seats"`
+	Status       string              `bson:"status" json:"status"`
+	NewBookingID *primitive.ObjectID `bson:"new_booking_id,omitempty" json:"newBookingId,omitempty"`
+	CreatedBy    primitive.ObjectID  `bson:"created_by" json:"createdBy"`
+	CreatedAt    time.Time           `bson:"created_at" json:"createdAt"`
+	UpdatedAt    time.Time           `bson:"updated_at" json:"updatedAt"`
+}
+
+// TransferRecord is the history entry kept on both bookings of an accepted
+// transfer.
+type TransferRecord struct {
+	TransferID    primitive.ObjectID `bson:"transfer_id" json:"transferId"`
+	FromBookingID primitive.ObjectID `bson:"from_booking_id" json:"fromBookingId"`
+	ToBookingID   primitive.ObjectID `bson:"to_booking_id" json:"toBookingId"`
+	FromUserID    primitive.ObjectID `bson:"from_user_id" json:"fromUserId"`
+	ToUserID      primitive.ObjectID `bson:"to_user_id" json:"toUserId"`
+	Seats         []string           `bson:"seats" json:"seats"`
+	TransferredAt time.Time          `bson:"transferred_at" json:"transferredAt"`
+}
@@ -9,6 +9,7 @@ import (
 const (
 	RoleUser  = "USER"
 	RoleAdmin = "ADMIN"
+	RoleStaff = "STAFF"
 )
 
 type User struct {
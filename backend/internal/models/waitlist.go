@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WaitlistStatusWaiting   = "WAITING"
+	WaitlistStatusOffered   = "OFFERED"
+	WaitlistStatusFulfilled = "FULFILLED"
+	WaitlistStatusExpired   = "EXPIRED"
+	WaitlistStatusLeft      = "LEFT"
+)
+
+type WaitlistEntry struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ShowtimeID     primitive.ObjectID  `bson:"showtime_id" json:"showtimeId"`
+	UserID         primitive.ObjectID  `bson:"user_id" json:"userId"`
+	PartySize      int                 `bson:"party_size" json:"partySize"`
+	Status         string              `bson:"status" json:"status"`
+	OfferBookingID *primitive.ObjectID `bson:"offer_booking_id,omitempty" json:"offerBookingId,omitempty"`
+	OfferedSeats   []string            `bson:"offered_seats,omitempty" json:"offeredSeats,omitempty"`
+	OfferExpiresAt *time.Time          `bson:"offer_expires_at,omitempty" json:"offerExpiresAt,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updatedAt"`
+}
@@ -3,8 +3,10 @@ package mq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,21 +23,31 @@ type BookingEvent struct {
 	UserName   string   `json:"userName"`
 	ShowtimeID string   `json:"showtimeId"`
 	Seats      []string `json:"seats"`
+
+	// InvoiceNumber and Amount, in satang, are set on BookingConfirmed.
+	InvoiceNumber string `json:"invoiceNumber,omitempty"`
+	Amount        int64  `json:"amount,omitempty"`
 }
 
 type MQService struct {
+	url string
+
+	// mu guards the connection, which Publish replaces when the broker
+	// has gone away.
+	mu      sync.Mutex
 	conn    *amqp.Connection
 	channel *amqp.Channel
 	queue   amqp.Queue
+	handler func(BookingEvent) error
 }
 
 func NewMQService(url string) *MQService {
-	var conn *amqp.Connection
+	s := &MQService{url: url}
 	var err error
 
 	// Retry connection (RabbitMQ might not be ready yet in Docker)
 	for i := 0; i < 30; i++ {
-		conn, err = amqp.Dial(url)
+		err = s.connect()
 		if err == nil {
 			break
 		}
@@ -46,19 +58,45 @@ func NewMQService(url string) *MQService {
 		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
 	}
 
+	return s
+}
+
+// connect dials the broker, opens a channel in confirm mode and restarts the
+// consumer if one was registered. The caller must hold s.mu or own s.
+func (s *MQService) connect() error {
+	conn, err := amqp.Dial(s.url)
+	if err != nil {
+		return err
+	}
+
 	ch, err := conn.Channel()
 	if err != nil {
-		log.Fatalf("Failed to open channel: %v", err)
+		conn.Close()
+		return fmt.Errorf("open channel: %w", err)
+	}
+
+	// Publisher confirms let Publish report success only once the broker
+	// has taken responsibility for the message
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return fmt.Errorf("enable confirms: %w", err)
 	}
 
 	q, err := ch.QueueDeclare("booking.events", true, false, false, false, nil)
 	if err != nil {
-		log.Fatalf("Failed to declare queue: %v", err)
+		conn.Close()
+		return fmt.Errorf("declare queue: %w", err)
 	}
 
-	return &MQService{conn: conn, channel: ch, queue: q}
+	s.conn, s.channel, s.queue = conn, ch, q
+	if s.handler != nil {
+		return s.startConsumer()
+	}
+	return nil
 }
 
+// Publish sends event as a persistent message and waits for the broker to
+// confirm it. A dropped connection is re-established first.
 func (s *MQService) Publish(event BookingEvent) error {
 	if event.EventID == "" {
 		event.EventID = uuid.New().String()
@@ -72,33 +110,81 @@ func (s *MQService) Publish(event BookingEvent) error {
 		return err
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isConnected() {
+		if s.url == "" {
+			return errors.New("MQ not connected")
+		}
+		if err := s.connect(); err != nil {
+			return fmt.Errorf("reconnect: %w", err)
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return s.channel.PublishWithContext(ctx, "", s.queue.Name, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        body,
+	confirm, err := s.channel.PublishWithDeferredConfirmWithContext(ctx, "", s.queue.Name, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.EventID,
+		Body:         body,
 	})
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("message rejected by broker")
+	}
+	return nil
 }
 
-func (s *MQService) Consume(handler func(BookingEvent)) {
-	msgs, err := s.channel.Consume(s.queue.Name, "", true, false, false, false, nil)
-	if err != nil {
+// Consume delivers events to handler. A message is acknowledged only after
+// handler returns nil; on error it is requeued. Delivery is at least once,
+// so handler must tolerate duplicates (see BookingEvent.EventID).
+func (s *MQService) Consume(handler func(BookingEvent) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handler = handler
+	if err := s.startConsumer(); err != nil {
 		log.Fatalf("Failed to consume: %v", err)
 	}
 
+	log.Println("MQ Consumer started")
+}
+
+func (s *MQService) startConsumer() error {
+	msgs, err := s.channel.Consume(s.queue.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	handler := s.handler
 	go func() {
 		for msg := range msgs {
 			var event BookingEvent
 			if err := json.Unmarshal(msg.Body, &event); err != nil {
 				log.Printf("Failed to unmarshal event: %v", err)
+				msg.Nack(false, false)
 				continue
 			}
-			handler(event)
+			if err := handler(event); err != nil {
+				log.Printf("Failed to handle event %s: %v", event.EventID, err)
+				// Back off so a failing dependency is not retried in a tight loop
+				time.Sleep(time.Second)
+				msg.Nack(false, true)
+				continue
+			}
+			msg.Ack(false)
 		}
 	}()
-
-	log.Println("MQ Consumer started")
+	return nil
 }
 
 func (s *MQService) Close() {
@@ -125,22 +211,31 @@ func NewBookingConfirmedEvent(bookingID, userID, userEmail, userName, showtimeID
 
 }
 
+func NewBookingRefundedEvent(bookingID, userID, userEmail, userName, showtimeID string, seats []string) BookingEvent {
+	return BookingEvent{
+		EventID:    uuid.New().String(),
+		EventType:  "BookingRefunded",
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+		BookingID:  bookingID,
+		UserID:     userID,
+		UserEmail:  userEmail,
+		UserName:   userName,
+		ShowtimeID: showtimeID,
+		Seats:      seats,
+	}
+}
+
 // IgnoreConnectionError is used to make MQ optional during development
 func IgnoreConnectionError() *MQService {
 	return &MQService{}
 }
 
 func (s *MQService) IsConnected() bool {
-	return s.conn != nil && !s.conn.IsClosed()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isConnected()
 }
 
-// SafePublish publishes if connected, logs warning otherwise
-func (s *MQService) SafePublish(event BookingEvent) {
-	if !s.IsConnected() {
-		fmt.Printf("MQ not connected, skipping event: %s\n", event.EventType)
-		return
-	}
-	if err := s.Publish(event); err != nil {
-		fmt.Printf("Failed to publish event: %v\n", err)
-	}
+func (s *MQService) isConnected() bool {
+	return s.conn != nil && !s.conn.IsClosed()
 }
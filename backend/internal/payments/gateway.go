@@ -0,0 +1,142 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Wire format of the simulated gateway (cmd/paygateway).
+
+type GatewayIntentRequest struct {
+	Reference   string    `json:"reference"`
+	Amount      float64   `json:"amount"`
+	Currency    string    `json:"currency"`
+	CallbackURL string    `json:"callbackUrl"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type GatewayIntent struct {
+	ID            string    `json:"id"`
+	Reference     string    `json:"reference"`
+	Amount        float64   `json:"amount"`
+	Refunded      float64   `json:"refunded"`
+	Currency      string    `json:"currency"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type GatewayRefundRequest struct {
+	Amount         float64 `json:"amount"`
+	IdempotencyKey string  `json:"idempotencyKey"`
+}
+
+// GatewayEvent is the webhook body, signed with SignatureHeader.
+type GatewayEvent struct {
+	ID        string        `json:"id"`
+	Type      string        `json:"type"`
+	Intent    GatewayIntent `json:"intent"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// GatewayProvider talks to the simulated gateway. Intents are settled
+// asynchronously and reported to WebhookURL.
+type GatewayProvider struct {
+	BaseURL    string
+	Secret     string
+	WebhookURL string
+	Client     *http.Client
+}
+
+func NewGatewayProvider(baseURL, secret, webhookURL string) *GatewayProvider {
+	return &GatewayProvider{
+		BaseURL:    baseURL,
+		Secret:     secret,
+		WebhookURL: webhookURL,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *GatewayProvider) Name() string { return "GATEWAY" }
+
+func (p *GatewayProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	var intent GatewayIntent
+	err := p.call(ctx, "/v1/intents", GatewayIntentRequest{
+		Reference:   req.Reference,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		CallbackURL: p.WebhookURL,
+		ExpiresAt:   req.ExpiresAt,
+	}, &intent)
+	if err != nil {
+		return nil, err
+	}
+	return &Intent{ID: intent.ID, Status: intent.Status, FailureReason: intent.FailureReason}, nil
+}
+
+func (p *GatewayProvider) Capture(ctx context.Context, intentID string) error {
+	return p.call(ctx, "/v1/intents/"+intentID+"/capture", nil, nil)
+}
+
+func (p *GatewayProvider) Refund(ctx context.Context, intentID string, amount float64, idempotencyKey string) error {
+	return p.call(ctx, "/v1/intents/"+intentID+"/refund", GatewayRefundRequest{Amount: amount, IdempotencyKey: idempotencyKey}, nil)
+}
+
+func (p *GatewayProvider) VerifyWebhook(header http.Header, body []byte) (*Event, error) {
+	if err := VerifySignature(p.Secret, header.Get(SignatureHeader), body, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event GatewayEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:            event.ID,
+		IntentID:      event.Intent.ID,
+		Reference:     event.Intent.Reference,
+		Status:        event.Intent.Status,
+		FailureReason: event.Intent.FailureReason,
+		Amount:        event.Intent.Amount,
+		OccurredAt:    event.CreatedAt,
+	}, nil
+}
+
+// call POSTs in as JSON and decodes the response into out (when not nil).
+func (p *GatewayProvider) call(ctx context.Context, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.Secret)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("gateway %s: %s %s", path, resp.Status, apiErr.Error)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package payments
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// MockProvider approves every payment synchronously. It never sends
+// webhooks.
+type MockProvider struct{}
+
+func (MockProvider) Name() string { return "MOCK" }
+
+func (MockProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	return &Intent{ID: "mock_" + uuid.New().String(), Status: IntentSucceeded}, nil
+}
+
+func (MockProvider) Capture(ctx context.Context, intentID string) error {
+	return nil
+}
+
+func (MockProvider) Refund(ctx context.Context, intentID string, amount float64, idempotencyKey string) error {
+	return nil
+}
+
+func (MockProvider) VerifyWebhook(header http.Header, body []byte) (*Event, error) {
+	return nil, ErrNotSupported
+}
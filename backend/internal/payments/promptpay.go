@@ -0,0 +1,173 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// PromptPay credit transfer application ID and the EMVCo merchant account
+// sub-tags for each kind of PromptPay proxy.
+const (
+	promptPayAID     = "A000000677010111"
+	promptPayPhone   = "01"
+	promptPayTaxID   = "02"
+	promptPayEWallet = "03"
+)
+
+// PromptPayCallback is what the bank integration POSTs once a QR payment
+// has been made or has failed, signed with SignatureHeader. Status is
+// IntentSucceeded or IntentFailed.
+type PromptPayCallback struct {
+	TransactionID string    `json:"transactionId"`
+	Reference     string    `json:"reference"`
+	Amount        float64   `json:"amount"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	PaidAt        time.Time `json:"paidAt"`
+}
+
+// PromptPayProvider takes payment by PromptPay QR. The payer scans the QR
+// in their banking app; the result arrives through a signed callback, so
+// every intent starts PENDING.
+type PromptPayProvider struct {
+	// proxy is the merchant's account sub-tag and value, e.g. a tax ID.
+	proxyTag string
+	proxy    string
+
+	CallbackSecret string
+}
+
+// NewPromptPayProvider accepts a 10-digit mobile number, a 13-digit tax or
+// national ID or a 15-digit e-wallet ID as the merchant's PromptPay ID.
+func NewPromptPayProvider(merchantID, callbackSecret string) (*PromptPayProvider, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, merchantID)
+
+	p := &PromptPayProvider{CallbackSecret: callbackSecret}
+	switch {
+	case len(digits) == 10 && digits[0] == '0':
+		p.proxyTag, p.proxy = promptPayPhone, "0066"+digits[1:]
+	case len(digits) == 13:
+		p.proxyTag, p.proxy = promptPayTaxID, digits
+	case len(digits) == 15:
+		p.proxyTag, p.proxy = promptPayEWallet, digits
+	default:
+		return nil, fmt.Errorf("PromptPay ID %q is not a mobile number, tax ID or e-wallet ID", merchantID)
+	}
+	return p, nil
+}
+
+func (p *PromptPayProvider) Name() string { return "PROMPTPAY" }
+
+// CreateIntent builds the QR for the payment. The intent ID is the
+// reference until the callback supplies the bank's transaction ID.
+func (p *PromptPayProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	payload, err := p.Payload(req.Amount, req.BookingID, req.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return &Intent{ID: req.Reference, Status: IntentPending, QRPayload: payload}, nil
+}
+
+// Capture is a no-op: a PromptPay transfer is final when it is made.
+func (p *PromptPayProvider) Capture(ctx context.Context, intentID string) error {
+	return nil
+}
+
+// Refund always returns ErrManualRefund: PromptPay has no refund API, so
+// the money is transferred back to the payer by hand.
+func (p *PromptPayProvider) Refund(ctx context.Context, intentID string, amount float64, idempotencyKey string) error {
+	return ErrManualRefund
+}
+
+func (p *PromptPayProvider) VerifyWebhook(header http.Header, body []byte) (*Event, error) {
+	if err := VerifySignature(p.CallbackSecret, header.Get(SignatureHeader), body, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var callback PromptPayCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:            callback.TransactionID,
+		IntentID:      callback.TransactionID,
+		Reference:     callback.Reference,
+		Status:        callback.Status,
+		FailureReason: callback.FailureReason,
+		Amount:        callback.Amount,
+		OccurredAt:    callback.PaidAt,
+	}, nil
+}
+
+// Payload returns the EMVCo merchant-presented QR payload for a one-off
+// payment of amount THB. The additional data field carries the booking
+// reference as bill number, which the payer sees in their banking app, and
+// reference as reference label, which the bank echoes in the callback.
+func (p *PromptPayProvider) Payload(amount float64, booking, reference string) (string, error) {
+	if amount <= 0 {
+		return "", fmt.Errorf("PromptPay amount must be positive, got %.2f", amount)
+	}
+	for _, ref := range []string{booking, reference} {
+		if len(ref) > 25 {
+			return "", fmt.Errorf("PromptPay reference %q is longer than 25 characters", ref)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(emvField("00", "01"))
+	// 12: dynamic QR, valid for this amount only
+	b.WriteString(emvField("01", "12"))
+	b.WriteString(emvField("29", emvField("00", promptPayAID)+emvField(p.proxyTag, p.proxy)))
+	b.WriteString(emvField("53", "764"))
+	b.WriteString(emvField("54", fmt.Sprintf("%.2f", amount)))
+	b.WriteString(emvField("58", "TH"))
+	var additional string
+	if booking != "" {
+		additional += emvField("01", booking)
+	}
+	if reference != "" {
+		additional += emvField("05", reference)
+	}
+	if additional != "" {
+		b.WriteString(emvField("62", additional))
+	}
+	b.WriteString("6304")
+	b.WriteString(fmt.Sprintf("%04X", crc16CCITT(b.String())))
+	return b.String(), nil
+}
+
+// QRCodePNG renders payload as a square PNG size pixels wide.
+func QRCodePNG(payload string, size int) ([]byte, error) {
+	return qrcode.Encode(payload, qrcode.Medium, size)
+}
+
+func emvField(tag, value string) string {
+	return fmt.Sprintf("%s%02d%s", tag, len(value), value)
+}
+
+// crc16CCITT is the CRC-16/CCITT-FALSE checksum EMVCo payloads end with.
+func crc16CCITT(s string) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,73 @@
+// Package payments abstracts the payment service provider. The booking
+// handlers only talk to a Provider; which one is used is a deployment choice
+// (PAYMENT_PROVIDER).
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Intent statuses as reported by a provider.
+const (
+	IntentPending   = "PENDING"
+	IntentSucceeded = "SUCCEEDED"
+	IntentFailed    = "FAILED"
+	IntentCaptured  = "CAPTURED"
+	IntentRefunded  = "REFUNDED"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrNotSupported     = errors.New("not supported by this provider")
+	// ErrManualRefund is returned by Refund when the provider cannot pay
+	// out: the money has to be transferred back by hand.
+	ErrManualRefund = errors.New("refund has to be transferred by hand")
+)
+
+type IntentRequest struct {
+	// Reference is our payment ID; providers echo it back in webhooks.
+	Reference string
+	BookingID string
+	Amount    float64
+	Currency  string
+	ExpiresAt time.Time
+}
+
+// Intent is a provider-side payment. A provider that settles synchronously
+// returns IntentSucceeded or IntentFailed straight away; otherwise the
+// result arrives later through a webhook.
+type Intent struct {
+	ID            string
+	Status        string
+	FailureReason string
+	// QRPayload is set by methods the payer completes by scanning a code.
+	QRPayload string
+}
+
+// Event is a verified webhook notification about an intent.
+type Event struct {
+	ID            string
+	IntentID      string
+	Reference     string
+	Status        string
+	FailureReason string
+	// Amount is what the payer paid, when the provider reports it.
+	Amount     float64
+	OccurredAt time.Time
+}
+
+// Provider authorizes a payment when the user pays, captures it once the
+// booking is confirmed and refunds captured payments. Refunds carry an
+// idempotency key: asking again with the same key does not pay out twice.
+type Provider interface {
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	Capture(ctx context.Context, intentID string) error
+	Refund(ctx context.Context, intentID string, amount float64, idempotencyKey string) error
+	// VerifyWebhook authenticates a webhook request whose body has already
+	// been read and decodes it.
+	VerifyWebhook(header http.Header, body []byte) (*Event, error)
+}
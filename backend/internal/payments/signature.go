@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" where the
+// HMAC covers "<t>.<body>". Including the timestamp lets receivers reject
+// replayed requests.
+const SignatureHeader = "X-Gateway-Signature"
+
+// SignatureTolerance is how old a signed webhook may be.
+const SignatureTolerance = 5 * time.Minute
+
+// Sign returns the SignatureHeader value for body sent at timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + computeMAC(secret, t, body)
+}
+
+// VerifySignature checks a SignatureHeader value against body.
+func VerifySignature(secret, header string, body []byte, now time.Time) error {
+	var t, mac string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			mac = value
+		}
+	}
+	if t == "" || mac == "" {
+		return ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	if !hmac.Equal([]byte(mac), []byte(computeMAC(secret, t, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func computeMAC(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
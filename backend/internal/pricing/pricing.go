@@ -0,0 +1,226 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"cinema-booking/internal/models"
+)
+
+// DefaultPrice is charged, in satang, for a seat whose type no matching
+// price list covers, and for seats of bookings made before prices were
+// quoted.
+const DefaultPrice int64 = 25000
+
+// Quote prices seats for a showtime of movie. seatTypes maps seat codes to
+// seat types, lists are the active price lists to choose from and loc is the
+// timezone days of week and time bands are evaluated in.
+func Quote(showtime models.Showtime, movie models.Movie, seatTypes map[string]string, lists []models.PriceList, seats []string, loc *time.Location) []models.SeatPrice {
+	matching := Matching(lists, showtime, loc)
+
+	var surcharge int64
+	for _, s := range movie.Surcharges {
+		surcharge += s.Amount
+	}
+
+	prices := make([]models.SeatPrice, 0, len(seats))
+	for _, seat := range seats {
+		seatType := seatTypes[seat]
+		price := models.SeatPrice{
+			SeatCode:   seat,
+			SeatType:   seatType,
+			Base:       DefaultPrice,
+			Surcharges: movie.Surcharges,
+		}
+		for _, list := range matching {
+			if base, ok := list.Prices[seatType]; ok {
+				id := list.ID
+				price.Base = base
+				price.PriceListID = &id
+				break
+			}
+		}
+		price.Price = price.Base + surcharge
+		prices = append(prices, price)
+	}
+	return prices
+}
+
+// Matching returns the lists that apply to showtime, most specific first.
+func Matching(lists []models.PriceList, showtime models.Showtime, loc *time.Location) []models.PriceList {
+	var matching []models.PriceList
+	for _, list := range lists {
+		if list.Active && matches(list, showtime, loc) {
+			matching = append(matching, list)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		si, sj := specificity(matching[i]), specificity(matching[j])
+		if si != sj {
+			return si > sj
+		}
+		return matching[i].Priority > matching[j].Priority
+	})
+	return matching
+}
+
+func matches(list models.PriceList, showtime models.Showtime, loc *time.Location) bool {
+	if list.ShowtimeID != nil && *list.ShowtimeID != showtime.ID {
+		return false
+	}
+
+	start := showtime.StartTime.In(loc)
+	if len(list.DaysOfWeek) > 0 {
+		found := false
+		for _, day := range list.DaysOfWeek {
+			if time.Weekday(day) == start.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if list.TimeBand != nil {
+		from, _ := parseClock(list.TimeBand.From)
+		to, _ := parseClock(list.TimeBand.To)
+		minute := start.Hour()*60 + start.Minute()
+		if from <= to {
+			return minute >= from && minute < to
+		}
+		return minute >= from || minute < to
+	}
+	return true
+}
+
+func specificity(list models.PriceList) int {
+	score := 0
+	if list.ShowtimeID != nil {
+		score += 4
+	}
+	if len(list.DaysOfWeek) > 0 {
+		score += 2
+	}
+	if list.TimeBand != nil {
+		score++
+	}
+	return score
+}
+
+// Total sums the price of every seat.
+func Total(prices []models.SeatPrice) int64 {
+	var total int64
+	for _, p := range prices {
+		total += p.Price
+	}
+	return total
+}
+
+// ValidatePriceList reports the first problem with a price list submitted by an
+// admin.
+func ValidatePriceList(list models.PriceList) error {
+	if list.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(list.Prices) == 0 {
+		return errors.New("at least one seat type price is required")
+	}
+	for seatType, price := range list.Prices {
+		if price < 0 {
+			return fmt.Errorf("price for %s must not be negative", seatType)
+		}
+	}
+	for _, day := range list.DaysOfWeek {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("day of week %d is out of range 0-6", day)
+		}
+	}
+	if band := list.TimeBand; band != nil {
+		from, err := parseClock(band.From)
+		if err != nil {
+			return fmt.Errorf("timeBand.from: %w", err)
+		}
+		to, err := parseClock(band.To)
+		if err != nil {
+			return fmt.Errorf("timeBand.to: %w", err)
+		}
+		if from == to {
+			return errors.New("timeBand.from and timeBand.to must differ")
+		}
+	}
+	return nil
+}
+
+// parseClock converts "HH:MM" to minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Discount is what promo takes off a booking priced at prices, in satang. It
+// never exceeds the total.
+func Discount(promo models.Promotion, prices []models.SeatPrice) int64 {
+	total := Total(prices)
+	var discount int64
+	switch promo.Type {
+	case models.PromoTypePercent:
+		// rounded half up to the satang
+		discount = (total*promo.Value + 50) / 100
+	case models.PromoTypeFixed:
+		discount = promo.Value
+	case models.PromoTypeBuyGet:
+		group := promo.BuyQuantity + promo.FreeQuantity
+		if promo.FreeQuantity <= 0 || group <= 0 {
+			break
+		}
+		free := len(prices) / group * promo.FreeQuantity
+		sorted := make([]int64, 0, len(prices))
+		for _, p := range prices {
+			sorted = append(sorted, p.Price)
+		}
+		slices.Sort(sorted)
+		for _, price := range sorted[:free] {
+			discount += price
+		}
+	}
+	return min(discount, total)
+}
+
+// ValidatePromotion reports the first problem with a promotion submitted by
+// an admin.
+func ValidatePromotion(promo models.Promotion) error {
+	if promo.Code == "" {
+		return errors.New("code is required")
+	}
+	switch promo.Type {
+	case models.PromoTypePercent:
+		if promo.Value <= 0 || promo.Value > 100 {
+			return errors.New("percentage must be between 0 and 100")
+		}
+	case models.PromoTypeFixed:
+		if promo.Value <= 0 {
+			return errors.New("amount must be positive")
+		}
+	case models.PromoTypeBuyGet:
+		if promo.BuyQuantity < 1 || promo.FreeQuantity < 1 {
+			return errors.New("buyQuantity and freeQuantity must be at least 1")
+		}
+	default:
+		return fmt.Errorf("unknown promotion type %q", promo.Type)
+	}
+	if promo.MaxRedemptions < 0 || promo.MaxPerUser < 0 {
+		return errors.New("caps must not be negative")
+	}
+	if promo.ValidFrom != nil && promo.ValidUntil != nil && !promo.ValidFrom.Before(*promo.ValidUntil) {
+		return errors.New("validFrom must be before validUntil")
+	}
+	return nil
+}
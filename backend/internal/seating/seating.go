@@ -0,0 +1,207 @@
+package seating
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"cinema-booking/internal/models"
+)
+
+// Block is a set of seats picked by the selector, scored between 0 and 1
+// (higher is better).
+type Block struct {
+	Row         string   `json:"row,omitempty"`
+	Seats       []string `json:"seats"`
+	Score       float64  `json:"score"`
+	Explanation string   `json:"explanation"`
+}
+
+// preferredRowRatio puts the ideal row a little behind the middle of the
+// auditorium, which is where most viewers like to sit.
+const preferredRowRatio = 0.6
+
+type candidate struct {
+	rowIdx int
+	start  int
+	seats  []string
+	score  float64
+}
+
+// BestBlocks returns contiguous blocks of partySize seats ordered from best
+// to worst. available reports whether a seat code can be booked right now.
+// Inactive seats and seats of another type (when seatType is set) break a
+// block, since the party would not be sitting together.
+func BestBlocks(seatmap models.Seatmap, available func(string) bool, partySize int, seatType string) []Block {
+	candidates := contiguousCandidates(seatmap, available, seatType, partySize)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	blocks := make([]Block, 0, len(candidates))
+	for _, cand := range candidates {
+		blocks = append(blocks, toBlock(seatmap, cand))
+	}
+	return blocks
+}
+
+// SplitSuggestion is the fallback when no contiguous block fits the party.
+// It greedily combines the best-scoring smaller blocks, largest first, so
+// the party is split into as few groups as possible. It returns nil when
+// there are not enough seats at all.
+func SplitSuggestion(seatmap models.Seatmap, available func(string) bool, partySize int, seatType string) []Block {
+	taken := make(map[string]bool)
+	free := func(code string) bool { return !taken[code] && available(code) }
+
+	// No block can be longer than the longest row.
+	longest := 0
+	for _, row := range seatmap.Rows {
+		longest = max(longest, len(row.Seats))
+	}
+
+	var blocks []Block
+	remaining := partySize
+	for remaining > 0 {
+		var picked *candidate
+		for size := min(remaining, longest); size >= 1; size-- {
+			cands := contiguousCandidates(seatmap, free, seatType, size)
+			if len(cands) == 0 {
+				continue
+			}
+			sort.SliceStable(cands, func(i, j int) bool { return cands[i].score > cands[j].score })
+			picked = &cands[0]
+			break
+		}
+		if picked == nil {
+			return nil
+		}
+		for _, code := range picked.seats {
+			taken[code] = true
+		}
+		blocks = append(blocks, toBlock(seatmap, *picked))
+		remaining -= len(picked.seats)
+	}
+	return blocks
+}
+
+func contiguousCandidates(seatmap models.Seatmap, available func(string) bool, seatType string, size int) []candidate {
+	var out []candidate
+	for rowIdx, row := range seatmap.Rows {
+		run := 0
+		for i, seat := range row.Seats {
+			usable := seat.Active && available(seat.SeatCode) && (seatType == "" || seat.Type == seatType)
+			if !usable {
+				run = 0
+				continue
+			}
+			run++
+			if run < size {
+				continue
+			}
+
+			start := i - size + 1
+			codes := make([]string, 0, size)
+			for _, s := range row.Seats[start : i+1] {
+				codes = append(codes, s.SeatCode)
+			}
+			out = append(out, candidate{
+				rowIdx: rowIdx,
+				start:  start,
+				seats:  codes,
+				score:  blockScore(seatmap, rowIdx, start, size),
+			})
+		}
+	}
+	return out
+}
+
+// blockScore averages a per-seat score that falls off with horizontal
+// distance from the row centre (weight 0.6) and with distance from the
+// preferred row (weight 0.4).
+func blockScore(seatmap models.Seatmap, rowIdx, start, size int) float64 {
+	rowCount := len(seatmap.Rows)
+	rowLen := len(seatmap.Rows[rowIdx].Seats)
+
+	idealRow := preferredRowRatio * float64(rowCount-1)
+	dy := 0.0
+	if rowCount > 1 {
+		dy = math.Abs(float64(rowIdx)-idealRow) / float64(rowCount-1)
+	}
+
+	centre := float64(rowLen-1) / 2
+	total := 0.0
+	for i := start; i < start+size; i++ {
+		dx := 0.0
+		if rowLen > 1 {
+			dx = math.Abs(float64(i)-centre) / centre
+		}
+		total += 1 - 0.6*dx - 0.4*dy
+	}
+	return math.Round(total/float64(size)*1000) / 1000
+}
+
+func toBlock(seatmap models.Seatmap, cand candidate) Block {
+	row := seatmap.Rows[cand.rowIdx]
+	rowLen := len(row.Seats)
+	centre := float64(rowLen-1) / 2
+	mid := float64(cand.start) + float64(len(cand.seats)-1)/2
+
+	position := "centred"
+	if off := mid - centre; off <= -1 {
+		position = fmt.Sprintf("%.0f seat(s) left of centre", -off)
+	} else if off >= 1 {
+		position = fmt.Sprintf("%.0f seat(s) right of centre", off)
+	}
+
+	return Block{
+		Row:   row.RowLabel,
+		Seats: cand.seats,
+		Score: cand.score,
+		Explanation: fmt.Sprintf("%d seat(s) together in row %s (row %d of %d), %s",
+			len(cand.seats), row.RowLabel, cand.rowIdx+1, len(seatmap.Rows), position),
+	}
+}
+
+// Gap is an AVAILABLE seat that would be left with no available neighbour
+// on either side.
+type Gap struct {
+	Row      string `json:"row"`
+	SeatCode string `json:"seatCode"`
+}
+
+// SingleSeatGaps reports lone seats created by a change to the seat map.
+// availableAfter describes seat availability once the change is applied and
+// changed lists the seats whose state the change touches. Only seats next to
+// a changed seat are examined, so lone seats that already existed are not
+// blamed on this change. Row edges and inactive seats count as walls.
+func SingleSeatGaps(seatmap models.Seatmap, availableAfter func(string) bool, changed []string) []Gap {
+	touched := make(map[string]bool, len(changed))
+	for _, code := range changed {
+		touched[code] = true
+	}
+
+	var gaps []Gap
+	for _, row := range seatmap.Rows {
+		free := func(i int) bool {
+			if i < 0 || i >= len(row.Seats) {
+				return false
+			}
+			return row.Seats[i].Active && availableAfter(row.Seats[i].SeatCode)
+		}
+		near := func(i int) bool {
+			for _, j := range []int{i - 1, i, i + 1} {
+				if j >= 0 && j < len(row.Seats) && touched[row.Seats[j].SeatCode] {
+					return true
+				}
+			}
+			return false
+		}
+
+		for i, seat := range row.Seats {
+			if free(i) && !free(i-1) && !free(i+1) && near(i) {
+				gaps = append(gaps, Gap{Row: row.RowLabel, SeatCode: seat.SeatCode})
+			}
+		}
+	}
+	return gaps
+}
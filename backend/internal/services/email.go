@@ -5,6 +5,8 @@ import (
 	"log"
 	"net/smtp"
 	"strings"
+
+	"cinema-booking/internal/ledger"
 )
 
 type EmailService struct {
@@ -36,33 +38,50 @@ type BookingConfirmationData struct {
 	Seats      []string
 	ShowtimeID string
 	OccurredAt string
+
+	// InvoiceNumber is empty for events queued before invoices existed.
+	InvoiceNumber string
+	// Amount is in satang.
+	Amount int64
 }
 
 func (s *EmailService) SendBookingConfirmation(data BookingConfirmationData) error {
+	subject := "🎬 ยืนยันการจองตั๋วเรียบร้อยแล้ว!"
+	if err := s.send(data.UserEmail, subject, buildEmailBody(data)); err != nil {
+		return err
+	}
+
+	log.Printf("Booking confirmation email sent to %s (bookingId: %s)", data.UserEmail, data.BookingID)
+	return nil
+}
+
+// send delivers a plain-text email. It is a no-op when SMTP is not
+// configured.
+func (s *EmailService) send(to, subject, body string) error {
 	if !s.IsConfigured() {
-		log.Printf("Email service not configured, skipping email to %s", data.UserEmail)
+		log.Printf("Email service not configured, skipping email to %s", to)
 		return nil
 	}
 
-	subject := "🎬 ยืนยันการจองตั๋วเรียบร้อยแล้ว!"
-	body := buildEmailBody(data)
-
-	msg := buildMIMEMessage(s.from, data.UserEmail, subject, body)
+	msg := buildMIMEMessage(s.from, to, subject, body)
 
 	addr := fmt.Sprintf("%s:%s", s.host, s.port)
 	auth := smtp.PlainAuth("", s.username, s.password, s.host)
 
-	err := smtp.SendMail(addr, auth, s.from, []string{data.UserEmail}, []byte(msg))
-	if err != nil {
-		return fmt.Errorf("failed to send email to %s: %w", data.UserEmail, err)
+	if err := smtp.SendMail(addr, auth, s.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", to, err)
 	}
-
-	log.Printf("Booking confirmation email sent to %s (bookingId: %s)", data.UserEmail, data.BookingID)
 	return nil
 }
 
 func buildEmailBody(data BookingConfirmationData) string {
 	seats := strings.Join(data.Seats, ", ")
+	invoice := ""
+	if data.InvoiceNumber != "" {
+		invoice = fmt.Sprintf(`
+  ใบกำกับภาษี : %s
+  ยอดชำระ    : %.2f บาท (รวม VAT)`, data.InvoiceNumber, ledger.FromMinor(data.Amount))
+	}
 	return fmt.Sprintf(`
 สวัสดีคุณ %s,
 
@@ -72,14 +91,117 @@ func buildEmailBody(data BookingConfirmationData) string {
 ━━━━━━━━━━━━━━━━━━━━━━━━
   Booking ID : %s
   ที่นั่ง     : %s
-  วันที่จอง  : %s
+  วันที่จอง  : %s%s
 ━━━━━━━━━━━━━━━━━━━━━━━━
 
 กรุณาแสดง Booking ID ณ จุดรับบัตรก่อนเข้าฉาย
+ดาวน์โหลดใบกำกับภาษี หรือขอใบกำกับภาษีเต็มรูปในนามบริษัท ได้ที่หน้าการจองของคุณ
+
+ขอบคุณที่ใช้บริการ 🎬
+Cinema Booking System
+`, data.UserName, data.BookingID, seats, data.OccurredAt, invoice)
+}
+
+type WaitlistOfferData struct {
+	UserName   string
+	UserEmail  string
+	BookingID  string
+	Seats      []string
+	ShowtimeID string
+	ExpiresAt  string
+}
+
+func (s *EmailService) SendWaitlistOffer(data WaitlistOfferData) error {
+	subject := "🎟️ มีที่นั่งว่างสำหรับคุณแล้ว!"
+	if err := s.send(data.UserEmail, subject, buildWaitlistOfferBody(data)); err != nil {
+		return err
+	}
+
+	log.Printf("Waitlist offer email sent to %s (bookingId: %s)", data.UserEmail, data.BookingID)
+	return nil
+}
+
+func buildWaitlistOfferBody(data WaitlistOfferData) string {
+	seats := strings.Join(data.Seats, ", ")
+	return fmt.Sprintf(`
+สวัสดีคุณ %s,
+
+มีที่นั่งว่างสำหรับรอบที่คุณลงชื่อรอไว้ และเราได้กันที่นั่งไว้ให้คุณแล้ว! 🎉
+
+รายละเอียด:
+━━━━━━━━━━━━━━━━━━━━━━━━
+  Booking ID : %s
+  ที่นั่ง     : %s
+  หมดเวลา    : %s
+━━━━━━━━━━━━━━━━━━━━━━━━
+
+กรุณาชำระเงินและยืนยันการจองก่อนหมดเวลา มิฉะนั้นที่นั่งจะถูกส่งต่อให้ผู้รอคิวถัดไป
 
 ขอบคุณที่ใช้บริการ 🎬
 Cinema Booking System
-`, data.UserName, data.BookingID, seats, data.OccurredAt)
+`, data.UserName, data.BookingID, seats, data.ExpiresAt)
+}
+
+type TransferData struct {
+	ToEmail    string
+	FromName   string
+	ToName     string
+	TransferID string
+	BookingID  string
+	Seats      []string
+}
+
+// SendTransferOffer tells the recipient that tickets are waiting for them.
+func (s *EmailService) SendTransferOffer(data TransferData) error {
+	subject := "🎟️ คุณได้รับตั๋วภาพยนตร์จาก " + data.FromName
+	body := fmt.Sprintf(`
+สวัสดีครับ/ค่ะ,
+
+คุณ %s ต้องการโอนตั๋วภาพยนตร์ให้คุณ 🎬
+
+รายละเอียด:
+━━━━━━━━━━━━━━━━━━━━━━━━
+  Transfer ID : %s
+  ที่นั่ง       : %s
+━━━━━━━━━━━━━━━━━━━━━━━━
+
+กรุณาเข้าสู่ระบบด้วยอีเมลนี้เพื่อกดรับตั๋ว
+
+Cinema Booking System
+`, data.FromName, data.TransferID, strings.Join(data.Seats, ", "))
+
+	if err := s.send(data.ToEmail, subject, body); err != nil {
+		return err
+	}
+
+	log.Printf("Transfer offer email sent to %s (transferId: %s)", data.ToEmail, data.TransferID)
+	return nil
+}
+
+// SendTransferCompleted confirms a finished transfer to one of its parties.
+func (s *EmailService) SendTransferCompleted(to string, data TransferData) error {
+	subject := "✅ การโอนตั๋วเสร็จสมบูรณ์"
+	body := fmt.Sprintf(`
+สวัสดีครับ/ค่ะ,
+
+การโอนตั๋วจากคุณ %s ให้คุณ %s เสร็จสมบูรณ์แล้ว
+
+รายละเอียด:
+━━━━━━━━━━━━━━━━━━━━━━━━
+  Transfer ID : %s
+  Booking ID  : %s
+  ที่นั่ง       : %s
+━━━━━━━━━━━━━━━━━━━━━━━━
+
+Cinema Booking System
+`, data.FromName, data.ToName, data.TransferID, data.BookingID, strings.Join(data.Seats, ", "))
+
+	if err := s.send(to, subject, body); err != nil {
+		return err
+	}
+
+	log.Printf("Transfer completed email sent to %s (transferId: %s)", to, data.TransferID)
+	return nil
 }
 
 func buildMIMEMessage(from, to, subject, body string) string {
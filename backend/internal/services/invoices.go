@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// invoiceLockTTL bounds how long a writer that died holding an invoice
+// series lock keeps the series blocked, and how long a writer waits for it.
+const invoiceLockTTL = 30 * time.Second
+
+var ErrInvoiceSeriesBusy = errors.New("invoice series is locked")
+
+// NextInvoiceSeq takes the next number of an invoice series for year. Call
+// it in the transaction that inserts the invoice: an aborted transaction
+// gives the number back, which keeps the series free of gaps. Without
+// transactions, hold LockInvoiceSeries from before the number is taken
+// until the invoice is stored or the number given back.
+func (s *MongoService) NextInvoiceSeq(ctx context.Context, series string, year int) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := s.Collection("counters").FindOneAndUpdate(ctx,
+		bson.M{"_id": invoiceCounter(series, year)},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+// ReleaseInvoiceSeq gives back seq from a rollback when transactions are
+// unavailable. It only succeeds while seq is still the last number taken,
+// which LockInvoiceSeries guarantees.
+func (s *MongoService) ReleaseInvoiceSeq(ctx context.Context, series string, year int, seq int64) {
+	s.Collection("counters").UpdateOne(ctx,
+		bson.M{"_id": invoiceCounter(series, year), "seq": seq},
+		bson.M{"$inc": bson.M{"seq": -1}},
+	)
+}
+
+// LockInvoiceSeries makes the caller the only writer of an invoice series
+// on a server without transactions, where a number can only be given back
+// while no later one has been taken. It waits up to invoiceLockTTL for the
+// lock. Call the returned func once the invoice is stored or rolled back.
+func (s *MongoService) LockInvoiceSeries(ctx context.Context, series string) (func(), error) {
+	id := "invoice:" + series
+	holder := primitive.NewObjectID()
+	deadline := time.Now().Add(invoiceLockTTL)
+	for {
+		now := time.Now()
+		_, err := s.Collection("invoice_locks").UpdateOne(ctx,
+			bson.M{"_id": id, "held_until": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"holder": holder, "held_until": now.Add(invoiceLockTTL)}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return func() {
+				s.Collection("invoice_locks").DeleteOne(context.Background(), bson.M{"_id": id, "holder": holder})
+			}, nil
+		}
+		// Held by someone else: the upsert collided with their lock
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		if now.After(deadline) {
+			return nil, ErrInvoiceSeriesBusy
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// ResyncInvoiceSeq winds the counter of a series back to the last number
+// stored on an invoice, reclaiming numbers taken by a writer that died
+// before storing its invoice. prefix is the part of the number shared by
+// the series in year. Only call it holding LockInvoiceSeries.
+func (s *MongoService) ResyncInvoiceSeq(ctx context.Context, series string, year int, prefix string) error {
+	var last struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := s.Collection("invoices").FindOne(ctx,
+		bson.M{"number": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}},
+		options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}),
+	).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	_, err = s.Collection("counters").UpdateOne(ctx,
+		bson.M{"_id": invoiceCounter(series, year), "seq": bson.M{"$ne": last.Seq}},
+		bson.M{"$set": bson.M{"seq": last.Seq}},
+	)
+	return err
+}
+
+func invoiceCounter(series string, year int) string {
+	return fmt.Sprintf("invoice:%s:%d", series, year)
+}
@@ -0,0 +1,385 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"cinema-booking/internal/ledger"
+	"cinema-booking/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrAlreadyPosted is returned for an entry whose key is already in the
+// ledger.
+var ErrAlreadyPosted = errors.New("journal entry already posted")
+
+// PostJournalEntry writes a balanced entry to ledger_entries. Insert it in
+// the same transaction as the payment or refund it records. An entry that
+// moves nothing, like the charge of a fully discounted payment, is skipped.
+func (s *MongoService) PostJournalEntry(ctx context.Context, entry models.JournalEntry) error {
+	if len(entry.Lines) == 0 {
+		return nil
+	}
+	if err := ledger.Validate(entry); err != nil {
+		return err
+	}
+	_, err := s.Collection("ledger_entries").InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyPosted
+	}
+	return err
+}
+
+// DeleteJournalEntry removes an entry from a rollback when transactions are
+// unavailable.
+func (s *MongoService) DeleteJournalEntry(ctx context.Context, entryID primitive.ObjectID) {
+	s.Collection("ledger_entries").DeleteOne(ctx, bson.M{"_id": entryID})
+}
+
+// LedgerBalances totals each account over entries posted in [from, to). A
+// zero from or to leaves that end open; a non-empty account restricts the
+// result to accounts starting with it, e.g. "revenue:".
+func (s *MongoService) LedgerBalances(ctx context.Context, account string, from, to time.Time) ([]models.AccountBalance, error) {
+	match := bson.M{}
+	created := bson.M{}
+	if !from.IsZero() {
+		created["$gte"] = from
+	}
+	if !to.IsZero() {
+		created["$lt"] = to
+	}
+	if len(created) > 0 {
+		match["created_at"] = created
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$lines"}},
+	}
+	if account != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{
+			"lines.account": bson.M{"$regex": "^" + regexp.QuoteMeta(account)},
+		}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":    "$lines.account",
+			"debit":  bson.M{"$sum": "$lines.debit"},
+			"credit": bson.M{"$sum": "$lines.credit"},
+		}}},
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"balance": bson.M{"$subtract": bson.A{"$debit", "$credit"}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	)
+
+	cursor, err := s.Collection("ledger_entries").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	balances := []models.AccountBalance{}
+	if err := cursor.All(ctx, &balances); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// LedgerDiscrepancy is a payment or refund the ledger does not agree with.
+type LedgerDiscrepancy struct {
+	Key      string `json:"key"`
+	Problem  string `json:"problem"`
+	Expected int64  `json:"expected"`
+	Posted   int64  `json:"posted"`
+}
+
+// LedgerReport compares the ledger with the payments and refunds
+// collections. All amounts are minor units.
+type LedgerReport struct {
+	Consistent    bool                `json:"consistent"`
+	Debits        int64               `json:"debits"`
+	Credits       int64               `json:"credits"`
+	Collected     int64               `json:"collected"`
+	Charged       int64               `json:"charged"`
+	Refunded      int64               `json:"refunded"`
+	RefundsPosted int64               `json:"refundsPosted"`
+	Discrepancies []LedgerDiscrepancy `json:"discrepancies"`
+	CheckedAt     time.Time           `json:"checkedAt"`
+}
+
+// CheckLedger proves the ledger against the payments collection: every
+// successful (or since refunded) payment has a charge entry for exactly its
+// amount and discount, every successful refund has a refund entry, nothing
+// else has one, and all entries together balance.
+func (s *MongoService) CheckLedger(ctx context.Context) (*LedgerReport, error) {
+	report := &LedgerReport{Discrepancies: []LedgerDiscrepancy{}, CheckedAt: time.Now()}
+
+	entries := map[string]models.JournalEntry{}
+	cursor, err := s.Collection("ledger_entries").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	for cursor.Next(ctx) {
+		var entry models.JournalEntry
+		if err := cursor.Decode(&entry); err != nil {
+			cursor.Close(ctx)
+			return nil, err
+		}
+		for _, l := range entry.Lines {
+			report.Debits += l.Debit
+			report.Credits += l.Credit
+		}
+		if err := ledger.Validate(entry); err != nil {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{Key: entry.Key, Problem: "unbalanced"})
+		}
+		entries[entry.Key] = entry
+	}
+	cursor.Close(ctx)
+
+	payments, err := s.settledPayments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range payments {
+		key := ledger.ChargeKey(p.ID)
+		expected := ledger.Charge(p)
+		entry, ok := entries[key]
+		delete(entries, key)
+
+		report.Collected += p.Amount
+		report.Charged += ledger.Posted(entry, ledger.ProviderAccount(p.Provider))
+		if !ok && len(expected.Lines) > 0 {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{Key: key, Problem: "missing", Expected: ledger.Total(expected)})
+			continue
+		}
+		if !sameLines(entry, expected) {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{Key: key, Problem: "amount mismatch", Expected: ledger.Total(expected), Posted: ledger.Total(entry)})
+		}
+	}
+
+	cursor, err = s.Collection("refunds").Find(ctx, bson.M{"status": models.RefundStatusSuccess})
+	if err != nil {
+		return nil, err
+	}
+	var refunds []models.Refund
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return nil, err
+	}
+	for _, r := range refunds {
+		key := ledger.RefundKey(r.ID)
+		entry, ok := entries[key]
+		delete(entries, key)
+
+		expected := r.Amount
+		posted := ledger.Total(entry)
+		report.Refunded += expected
+		report.RefundsPosted += posted
+		switch {
+		case !ok:
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{Key: key, Problem: "missing", Expected: expected})
+		case posted != expected:
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{Key: key, Problem: "amount mismatch", Expected: expected, Posted: posted})
+		}
+	}
+
+	// Charges and refunds left over belong to payments that did not succeed
+	for key, entry := range entries {
+		if entry.Kind == models.JournalCharge || entry.Kind == models.JournalRefund {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{Key: key, Problem: "no matching payment", Posted: ledger.Total(entry)})
+		}
+	}
+
+	report.Consistent = len(report.Discrepancies) == 0 &&
+		report.Debits == report.Credits &&
+		report.Collected == report.Charged &&
+		report.Refunded == report.RefundsPosted
+	return report, nil
+}
+
+// MigrateMinorUnits converts money amounts still stored in baht, as they
+// were before amounts moved to satang: payments, refunds, seat prices on
+// bookings and pending exchanges, promo discounts, promotion values, price
+// lists and movie surcharges. Baht amounts are doubles and satang amounts
+// 64-bit integers, so a converted amount is never converted twice. It
+// reports how many documents it converted.
+func (s *MongoService) MigrateMinorUnits(ctx context.Context) (int, error) {
+	double := bson.M{"$type": "double"}
+	doubleInMap := func(field string) bson.M {
+		return bson.M{"$expr": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+			"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$" + field, bson.M{}}}},
+			"in":    bson.M{"$eq": bson.A{bson.M{"$type": "$$this.v"}, "double"}},
+		}}}}}
+	}
+
+	migrated := 0
+	for _, target := range []struct {
+		collection string
+		filter     bson.M
+		set        bson.M
+	}{
+		{"payments", bson.M{"amount": double}, bson.M{"amount": toSatang("$amount")}},
+		{"payments", bson.M{"discount": double}, bson.M{"discount": toSatang("$discount")}},
+		{"refunds", bson.M{"amount": double}, bson.M{"amount": toSatang("$amount")}},
+		{"bookings", bson.M{"prices.price": double}, bson.M{"prices": seatPricesToSatang("$prices")}},
+		{"bookings", bson.M{"exchange.difference": double}, bson.M{
+			"exchange.difference": toSatang("$exchange.difference"),
+			"exchange.prices":     seatPricesToSatang("$exchange.prices"),
+		}},
+		{"bookings", bson.M{"promo.discount": double}, bson.M{"promo.discount": toSatang("$promo.discount")}},
+		{"promo_redemptions", bson.M{"discount": double}, bson.M{"discount": toSatang("$discount")}},
+		{"promotions", bson.M{"type": models.PromoTypeFixed, "value": double}, bson.M{"value": toSatang("$value")}},
+		// a percentage is not an amount; it only becomes an integer
+		{"promotions", bson.M{"type": bson.M{"$ne": models.PromoTypeFixed}, "value": double}, bson.M{
+			"value": bson.M{"$toLong": bson.M{"$round": bson.A{"$value", 0}}},
+		}},
+		{"price_lists", doubleInMap("prices"), bson.M{"prices": bson.M{"$arrayToObject": bson.M{"$map": bson.M{
+			"input": bson.M{"$objectToArray": "$prices"},
+			"in":    bson.M{"k": "$$this.k", "v": toSatang("$$this.v")},
+		}}}}},
+		{"movies", bson.M{"surcharges.amount": double}, bson.M{"surcharges": surchargesToSatang("$surcharges")}},
+	} {
+		result, err := s.Collection(target.collection).UpdateMany(ctx,
+			target.filter,
+			mongo.Pipeline{{{Key: "$set", Value: target.set}}},
+		)
+		if err != nil {
+			return migrated, fmt.Errorf("%s: %w", target.collection, err)
+		}
+		migrated += int(result.ModifiedCount)
+	}
+	return migrated, nil
+}
+
+// toSatang is an aggregation expression converting expr from baht to satang
+// if it is still a double, and leaving it alone otherwise.
+func toSatang(expr string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": expr}, "double"}},
+		bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{expr, 100}}, 0}}},
+		expr,
+	}}
+}
+
+// surchargesToSatang converts the amounts of the array of surcharges expr.
+func surchargesToSatang(expr string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$isArray": expr},
+		bson.M{"$map": bson.M{
+			"input": expr,
+			"as":    "s",
+			"in":    bson.M{"$mergeObjects": bson.A{"$$s", bson.M{"amount": toSatang("$$s.amount")}}},
+		}},
+		expr,
+	}}
+}
+
+// seatPricesToSatang converts the base, price and surcharges of the array
+// of seat prices expr.
+func seatPricesToSatang(expr string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$isArray": expr},
+		bson.M{"$map": bson.M{
+			"input": expr,
+			"as":    "p",
+			"in": bson.M{"$mergeObjects": bson.A{"$$p", bson.M{
+				"base":       toSatang("$$p.base"),
+				"price":      toSatang("$$p.price"),
+				"surcharges": surchargesToSatang("$$p.surcharges"),
+			}}},
+		}},
+		expr,
+	}}
+}
+
+// BackfillLedger converts amounts left in baht (see MigrateMinorUnits) and
+// then posts the charge and refund entries missing for payments and refunds
+// made before the ledger existed, or whose entry was lost. It reports how
+// many documents it converted and how many entries it posted.
+func (s *MongoService) BackfillLedger(ctx context.Context) (migrated, posted int, err error) {
+	migrated, err = s.MigrateMinorUnits(ctx)
+	if err != nil {
+		return migrated, 0, err
+	}
+	payments, err := s.settledPayments(ctx)
+	if err != nil {
+		return migrated, 0, err
+	}
+	byID := make(map[primitive.ObjectID]models.Payment, len(payments))
+	for _, p := range payments {
+		byID[p.ID] = p
+		entry := ledger.Charge(p)
+		if len(entry.Lines) == 0 {
+			continue
+		}
+		err := s.PostJournalEntry(ctx, entry)
+		if errors.Is(err, ErrAlreadyPosted) {
+			continue
+		}
+		if err != nil {
+			return migrated, posted, err
+		}
+		posted++
+	}
+
+	cursor, err := s.Collection("refunds").Find(ctx, bson.M{"status": models.RefundStatusSuccess})
+	if err != nil {
+		return migrated, posted, err
+	}
+	var refunds []models.Refund
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return migrated, posted, err
+	}
+	for _, r := range refunds {
+		payment, ok := byID[r.PaymentID]
+		if !ok {
+			continue
+		}
+		err := s.PostJournalEntry(ctx, ledger.Refund(r, payment))
+		if errors.Is(err, ErrAlreadyPosted) {
+			continue
+		}
+		if err != nil {
+			return migrated, posted, err
+		}
+		posted++
+	}
+	return migrated, posted, nil
+}
+
+// settledPayments returns the payments money was taken for.
+func (s *MongoService) settledPayments(ctx context.Context) ([]models.Payment, error) {
+	cursor, err := s.Collection("payments").Find(ctx, bson.M{
+		"status": bson.M{"$in": []string{models.PaymentStatusSuccess, models.PaymentStatusRefunded}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var payments []models.Payment
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// sameLines reports whether two entries move the same amounts to the same
+// accounts.
+func sameLines(a, b models.JournalEntry) bool {
+	net := map[string]int64{}
+	for _, l := range a.Lines {
+		net[l.Account] += l.Debit - l.Credit
+	}
+	for _, l := range b.Lines {
+		net[l.Account] -= l.Debit - l.Credit
+	}
+	for _, v := range net {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
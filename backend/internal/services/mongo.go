@@ -5,6 +5,8 @@ import (
 	"log"
 	"time"
 
+	"cinema-booking/internal/models"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,6 +15,11 @@ import (
 type MongoService struct {
 	Client *mongo.Client
 	DB     *mongo.Database
+
+	// SupportsTransactions is true when connected to a replica set or a
+	// sharded cluster. Standalone servers cannot run multi-document
+	// transactions.
+	SupportsTransactions bool
 }
 
 func NewMongoService(uri, dbName string) *MongoService {
@@ -30,10 +37,51 @@ func NewMongoService(uri, dbName string) *MongoService {
 
 	db := client.Database(dbName)
 	svc := &MongoService{Client: client, DB: db}
+	svc.SupportsTransactions = svc.detectTransactionSupport(ctx)
+	if !svc.SupportsTransactions {
+		log.Println("Warning: MongoDB is standalone, multi-document transactions disabled")
+	}
 	svc.createIndexes()
 	return svc
 }
 
+func (s *MongoService) detectTransactionSupport(ctx context.Context) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := s.DB.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Printf("Warning: MongoDB hello failed: %v", err)
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// WithTransaction runs fn inside a multi-document transaction so that all of
+// its writes commit or roll back together. On a standalone server fn runs in
+// a plain session instead, and rollback (if not nil) is called when fn fails
+// so the caller can undo any writes that were already applied.
+func (s *MongoService) WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error, rollback func(ctx context.Context)) error {
+	session, err := s.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	if s.SupportsTransactions {
+		_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	}
+
+	err = mongo.WithSession(ctx, session, fn)
+	if err != nil && rollback != nil {
+		rollback(ctx)
+	}
+	return err
+}
+
 func (s *MongoService) createIndexes() {
 	ctx := context.Background()
 
@@ -53,12 +101,90 @@ func (s *MongoService) createIndexes() {
 	s.DB.Collection("bookings").Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
 		{Keys: bson.D{{Key: "showtime_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "payment_id", Value: 1}}},
+		{Keys: bson.D{{Key: "exchange.expires_at", Value: 1}}, Options: options.Index().SetSparse(true)},
 	})
 
 	// audit_logs indexes
 	s.DB.Collection("audit_logs").Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "event_type", Value: 1}, {Key: "created_at", Value: 1}}},
 		{Keys: bson.D{{Key: "showtime_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+
+	// waitlist indexes
+	s.DB.Collection("waitlist").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "showtime_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "showtime_id", Value: 1}}},
+		// one WAITING entry per user and showtime, so concurrent joins cannot duplicate it
+		{
+			Keys:    bson.D{{Key: "showtime_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": models.WaitlistStatusWaiting}),
+		},
+	})
+
+	// payments indexes
+	s.DB.Collection("payments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "transitions.reason", Value: 1}}},
+	})
+
+	// ledger_entries indexes
+	s.DB.Collection("ledger_entries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "booking_id", Value: 1}}},
+	})
+
+	// invoices indexes
+	s.DB.Collection("invoices").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "type", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+
+	// refunds indexes
+	s.DB.Collection("refunds").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "booking_id", Value: 1}}},
+		{Keys: bson.D{{Key: "payment_id", Value: 1}}},
+		{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+
+	// seat_history indexes
+	s.DB.Collection("seat_history").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "showtime_id", Value: 1}, {Key: "seat_code", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+
+	// transfers indexes
+	s.DB.Collection("transfers").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "to_user_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "from_user_id", Value: 1}, {Key: "status", Value: 1}}},
+	})
+
+	// price_lists indexes
+	s.DB.Collection("price_lists").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "active", Value: 1}, {Key: "showtime_id", Value: 1}}},
+	})
+
+	// promotions indexes
+	s.DB.Collection("promotions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	s.DB.Collection("promo_redemptions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "promotion_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "booking_id", Value: 1}}},
+	})
+
+	// outbox indexes; sent messages are kept for a week
+	s.DB.Collection("outbox").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "sent_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 3600)},
+	})
+
+	// processed_events expire after a week, long past any redelivery
+	s.DB.Collection("processed_events").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "processed_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 3600)},
 	})
 
 	log.Println("MongoDB indexes created")
@@ -67,3 +193,25 @@ func (s *MongoService) createIndexes() {
 func (s *MongoService) Collection(name string) *mongo.Collection {
 	return s.DB.Collection(name)
 }
+
+// EventProcessed reports whether the consumer has finished handling
+// eventID, so a redelivered message can be skipped.
+func (s *MongoService) EventProcessed(ctx context.Context, eventID string) (bool, error) {
+	err := s.Collection("processed_events").FindOne(ctx, bson.M{"_id": eventID}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MarkEventProcessed records that eventID has been handled. Call it only
+// once every effect of the event is in place; the effects themselves must
+// be safe to repeat, since a crash before the mark redelivers the event.
+func (s *MongoService) MarkEventProcessed(ctx context.Context, eventID string) error {
+	_, err := s.Collection("processed_events").UpdateOne(ctx,
+		bson.M{"_id": eventID},
+		bson.M{"$setOnInsert": bson.M{"processed_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cinema-booking/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TransitionPayment moves a payment from one status to another, applying set
+// and appending the change to the payment's transitions. It reports false
+// when the payment is no longer in from. Moves the payment state machine does
+// not allow are an error.
+func (s *MongoService) TransitionPayment(ctx context.Context, paymentID primitive.ObjectID, from, to, reason string, set bson.M) (bool, error) {
+	if !models.CanTransitionPayment(from, to) {
+		return false, fmt.Errorf("payment %s cannot move from %s to %s", paymentID.Hex(), from, to)
+	}
+
+	now := time.Now()
+	fields := bson.M{"status": to, "updated_at": now}
+	for k, v := range set {
+		fields[k] = v
+	}
+	result, err := s.Collection("payments").UpdateOne(ctx,
+		bson.M{"_id": paymentID, "status": from},
+		bson.M{
+			"$set":  fields,
+			"$push": bson.M{"transitions": models.PaymentTransition{From: from, To: to, Reason: reason, At: now}},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// RevertPaymentTransition undoes a TransitionPayment from a rollback when
+// transactions are unavailable.
+func (s *MongoService) RevertPaymentTransition(ctx context.Context, paymentID primitive.ObjectID, from, to string) {
+	s.Collection("payments").UpdateOne(ctx,
+		bson.M{"_id": paymentID, "status": to},
+		bson.M{
+			"$set": bson.M{"status": from, "updated_at": time.Now()},
+			"$pop": bson.M{"transitions": 1},
+		},
+	)
+}
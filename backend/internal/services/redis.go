@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -39,6 +40,85 @@ func (s *RedisService) AcquireLock(ctx context.Context, showtimeId, seatCode, us
 	return ok, err
 }
 
+// acquireLocksScript sets every seat key only if none of them exist yet.
+// It returns the indexes (1-based) of the keys that were already taken,
+// or an empty table when all keys were set.
+var acquireLocksScript = redis.NewScript(`
+	local conflicts = {}
+	for i, key in ipairs(KEYS) do
+		if redis.call("EXISTS", key) == 1 then
+			table.insert(conflicts, i)
+		end
+	end
+	if #conflicts > 0 then
+		return conflicts
+	end
+	for _, key in ipairs(KEYS) do
+		redis.call("SET", key, ARGV[1], "PX", ARGV[2])
+	end
+	return conflicts
+`)
+
+// AcquireLocks locks all seats in a single atomic step. Either every seat is
+// locked for userId, or none are and the conflicting seat codes are returned.
+func (s *RedisService) AcquireLocks(ctx context.Context, showtimeId string, seats []string, userId string, ttl time.Duration) ([]string, error) {
+	keys := make([]string, len(seats))
+	for i, seat := range seats {
+		keys[i] = lockKey(showtimeId, seat)
+	}
+
+	res, err := acquireLocksScript.Run(ctx, s.Client, keys, userId, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := make([]string, 0, len(res))
+	for _, idx := range res {
+		conflicts = append(conflicts, seats[idx-1])
+	}
+	return conflicts, nil
+}
+
+// extendLocksScript renews the TTL of every seat key, but only if all of
+// them are still held by ARGV[1]. It returns the 1-based indexes of keys
+// that are missing or owned by someone else.
+var extendLocksScript = redis.NewScript(`
+	local lost = {}
+	for i, key in ipairs(KEYS) do
+		if redis.call("GET", key) ~= ARGV[1] then
+			table.insert(lost, i)
+		end
+	end
+	if #lost > 0 then
+		return lost
+	end
+	for _, key in ipairs(KEYS) do
+		redis.call("PEXPIRE", key, ARGV[2])
+	end
+	return lost
+`)
+
+// ExtendLocks renews the TTL of seat locks held by userId. It is
+// all-or-nothing: if any lock was lost, nothing is extended and the lost
+// seat codes are returned.
+func (s *RedisService) ExtendLocks(ctx context.Context, showtimeId string, seats []string, userId string, ttl time.Duration) ([]string, error) {
+	keys := make([]string, len(seats))
+	for i, seat := range seats {
+		keys[i] = lockKey(showtimeId, seat)
+	}
+
+	res, err := extendLocksScript.Run(ctx, s.Client, keys, userId, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	lost := make([]string, 0, len(res))
+	for _, idx := range res {
+		lost = append(lost, seats[idx-1])
+	}
+	return lost, nil
+}
+
 func (s *RedisService) ReleaseLock(ctx context.Context, showtimeId, seatCode, userId string) error {
 	key := lockKey(showtimeId, seatCode)
 	// Lua script: only delete if value matches
@@ -52,6 +132,12 @@ func (s *RedisService) ReleaseLock(ctx context.Context, showtimeId, seatCode, us
 	return err
 }
 
+func (s *RedisService) ReleaseLocks(ctx context.Context, showtimeId string, seats []string, userId string) {
+	for _, seat := range seats {
+		s.ReleaseLock(ctx, showtimeId, seat, userId)
+	}
+}
+
 func (s *RedisService) GetLockOwner(ctx context.Context, showtimeId, seatCode string) (string, error) {
 	key := lockKey(showtimeId, seatCode)
 	val, err := s.Client.Get(ctx, key).Result()
@@ -65,3 +151,103 @@ func (s *RedisService) ForceReleaseLock(ctx context.Context, showtimeId, seatCod
 	key := lockKey(showtimeId, seatCode)
 	return s.Client.Del(ctx, key).Err()
 }
+
+// SeatLock is a seat lock key as found in Redis. TTL is -1 when the key has
+// no expiry.
+type SeatLock struct {
+	ShowtimeID string
+	SeatCode   string
+	Owner      string
+	TTL        time.Duration
+}
+
+// ScanSeatLocks returns every seat lock currently in Redis. It uses SCAN so
+// it does not block the server on large keyspaces.
+func (s *RedisService) ScanSeatLocks(ctx context.Context) ([]SeatLock, error) {
+	var keys []string
+	iter := s.Client.Scan(ctx, 0, lockKey("*", "*"), 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := s.Client.Pipeline()
+	gets := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	locks := make([]SeatLock, 0, len(keys))
+	for i, key := range keys {
+		// lock:showtime:<id>:seat:<code>
+		parts := strings.Split(key, ":")
+		if len(parts) != 5 {
+			continue
+		}
+		owner, err := gets[i].Result()
+		if err != nil {
+			// Expired between SCAN and GET
+			continue
+		}
+		locks = append(locks, SeatLock{
+			ShowtimeID: parts[2],
+			SeatCode:   parts[4],
+			Owner:      owner,
+			TTL:        ttls[i].Val(),
+		})
+	}
+	return locks, nil
+}
+
+// replaceLockOwnerScript swaps the owner of a lock only if it is still held
+// by ARGV[1].
+var replaceLockOwnerScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+		return 1
+	end
+	return 0
+`)
+
+// ReplaceLockOwner hands a seat lock from oldOwner to newOwner with a fresh
+// ttl. It returns false if the lock is no longer held by oldOwner.
+func (s *RedisService) ReplaceLockOwner(ctx context.Context, showtimeId, seatCode, oldOwner, newOwner string, ttl time.Duration) (bool, error) {
+	n, err := replaceLockOwnerScript.Run(ctx, s.Client, []string{lockKey(showtimeId, seatCode)}, oldOwner, newOwner, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func idempotencyKey(userId, key string) string {
+	return fmt.Sprintf("idempotency:user:%s:key:%s", userId, key)
+}
+
+// ReserveIdempotencyKey stores record under the key only if it is not taken
+// yet. It returns false when a request with the same key was already seen.
+func (s *RedisService) ReserveIdempotencyKey(ctx context.Context, userId, key string, record []byte, ttl time.Duration) (bool, error) {
+	return s.Client.SetNX(ctx, idempotencyKey(userId, key), record, ttl).Result()
+}
+
+func (s *RedisService) GetIdempotencyRecord(ctx context.Context, userId, key string) ([]byte, error) {
+	val, err := s.Client.Get(ctx, idempotencyKey(userId, key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return val, err
+}
+
+func (s *RedisService) SaveIdempotencyRecord(ctx context.Context, userId, key string, record []byte, ttl time.Duration) error {
+	return s.Client.Set(ctx, idempotencyKey(userId, key), record, ttl).Err()
+}
+
+func (s *RedisService) DeleteIdempotencyRecord(ctx context.Context, userId, key string) error {
+	return s.Client.Del(ctx, idempotencyKey(userId, key)).Err()
+}
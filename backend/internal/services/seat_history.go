@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cinema-booking/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SeatChange describes a state transition applied to a set of seats of one
+// showtime.
+type SeatChange struct {
+	ShowtimeID primitive.ObjectID
+	Seats      []string
+	From, To   string
+	Reason     string
+	Note       string
+	Actor      *primitive.ObjectID
+	BookingID  *primitive.ObjectID
+}
+
+// RecordSeatHistory appends one seat_history entry per seat. It is called
+// after the change has been committed; a failure is logged and does not undo
+// the change.
+func (s *MongoService) RecordSeatHistory(ctx context.Context, change SeatChange) {
+	if len(change.Seats) == 0 {
+		return
+	}
+
+	now := time.Now()
+	docs := make([]interface{}, 0, len(change.Seats))
+	for _, seat := range change.Seats {
+		docs = append(docs, models.SeatHistory{
+			ID:         primitive.NewObjectID(),
+			ShowtimeID: change.ShowtimeID,
+			SeatCode:   seat,
+			FromState:  change.From,
+			ToState:    change.To,
+			Reason:     change.Reason,
+			Note:       change.Note,
+			ActorID:    change.Actor,
+			BookingID:  change.BookingID,
+			CreatedAt:  now,
+		})
+	}
+	if _, err := s.Collection("seat_history").InsertMany(ctx, docs); err != nil {
+		log.Printf("Failed to record seat history (%s, showtime %s): %v", change.Reason, change.ShowtimeID.Hex(), err)
+	}
+}
@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/mq"
+	"cinema-booking/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// outboxClaimTimeout is how long a claimed message is hidden from other
+	// relay passes. If the server dies mid-publish the message becomes due
+	// again after this and is republished.
+	outboxClaimTimeout = time.Minute
+	outboxMaxBackoff   = 5 * time.Minute
+	outboxBatchSize    = 100
+)
+
+// outboxMetrics is published at /api/admin/metrics.
+var outboxMetrics = expvar.NewMap("outbox_relay")
+
+// OutboxRelay publishes PENDING outbox messages to RabbitMQ and marks them
+// SENT once the broker has confirmed them. Failed publishes are retried with
+// exponential backoff. A message can be published more than once (for
+// example when the server stops between publish and marking it SENT), so
+// delivery is at least once and consumers deduplicate by event ID.
+type OutboxRelay struct {
+	Mongo    *services.MongoService
+	MQ       *mq.MQService
+	Interval time.Duration
+}
+
+func (r *OutboxRelay) Start() {
+	go func() {
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		log.Printf("Outbox relay started (interval: %v)", r.Interval)
+		for range ticker.C {
+			sent, err := r.Run(context.Background())
+			if err != nil {
+				log.Printf("Outbox relay: %v", err)
+			}
+			if sent > 0 {
+				log.Printf("Outbox relay: published %d event(s)", sent)
+			}
+		}
+	}()
+}
+
+// Run publishes due messages, oldest first, until none are left or a
+// publish fails. It returns the number published.
+func (r *OutboxRelay) Run(ctx context.Context) (int, error) {
+	sent := 0
+	for sent < outboxBatchSize {
+		msg, err := r.claim(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		if err := r.publish(msg); err != nil {
+			outboxMetrics.Add("failed", 1)
+			r.retryLater(ctx, msg, err)
+			// The broker is most likely unavailable; try again next tick
+			return sent, err
+		}
+
+		now := time.Now()
+		_, err = r.Mongo.Collection("outbox").UpdateOne(ctx,
+			bson.M{"_id": msg.ID},
+			bson.M{
+				"$set":   bson.M{"status": models.OutboxStatusSent, "sent_at": now},
+				"$unset": bson.M{"last_error": ""},
+			},
+		)
+		if err != nil {
+			// Published but not marked; it will be published again
+			return sent, err
+		}
+		outboxMetrics.Add("published", 1)
+		sent++
+	}
+	return sent, nil
+}
+
+// claim takes the oldest due message and pushes its next attempt past
+// outboxClaimTimeout so overlapping passes do not publish it concurrently.
+func (r *OutboxRelay) claim(ctx context.Context) (models.OutboxMessage, error) {
+	now := time.Now()
+	var msg models.OutboxMessage
+	err := r.Mongo.Collection("outbox").FindOneAndUpdate(ctx,
+		bson.M{"status": models.OutboxStatusPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{
+			"$set": bson.M{"next_attempt_at": now.Add(outboxClaimTimeout)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&msg)
+	return msg, err
+}
+
+func (r *OutboxRelay) publish(msg models.OutboxMessage) error {
+	var event mq.BookingEvent
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		return err
+	}
+	return r.MQ.Publish(event)
+}
+
+func (r *OutboxRelay) retryLater(ctx context.Context, msg models.OutboxMessage, cause error) {
+	backoff := outboxMaxBackoff
+	if msg.Attempts < 10 {
+		backoff = min(time.Duration(1<<msg.Attempts)*time.Second, outboxMaxBackoff)
+	}
+	_, err := r.Mongo.Collection("outbox").UpdateOne(ctx,
+		bson.M{"_id": msg.ID},
+		bson.M{"$set": bson.M{
+			"next_attempt_at": time.Now().Add(backoff),
+			"last_error":      cause.Error(),
+		}},
+	)
+	if err != nil {
+		log.Printf("Outbox relay: failed to reschedule %s: %v", msg.EventID, err)
+	}
+}
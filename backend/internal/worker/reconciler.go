@@ -0,0 +1,392 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"cinema-booking/internal/models"
+	"cinema-booking/internal/services"
+	wsHub "cinema-booking/internal/ws"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Drift kinds found by the reconciler. MongoDB is the source of truth for
+// seat state; Redis locks are a fast-path guard that must agree with it.
+const (
+	// DriftOrphanRedisLock: a Redis lock on a seat that is not LOCKED in
+	// Mongo. The key is deleted.
+	DriftOrphanRedisLock = "ORPHAN_REDIS_LOCK"
+	// DriftOwnerMismatch: the Redis lock and the LOCKED reservation name
+	// different users. The key is handed to the Mongo owner.
+	DriftOwnerMismatch = "OWNER_MISMATCH"
+	// DriftMissingRedisLock: a live LOCKED reservation without a Redis lock.
+	// The key is recreated until the reservation's lock_expires_at.
+	DriftMissingRedisLock = "MISSING_REDIS_LOCK"
+	// DriftStaleMongoLock: a LOCKED reservation whose booking no longer
+	// holds seats: it is neither LOCKED nor waiting on an exchange payment.
+	// The seat is released and its Redis lock deleted.
+	DriftStaleMongoLock = "STALE_MONGO_LOCK"
+)
+
+// reconcilerMetrics is published at /api/admin/metrics.
+var reconcilerMetrics = expvar.NewMap("lock_reconciler")
+
+type Repair struct {
+	Kind       string `json:"kind"`
+	ShowtimeID string `json:"showtimeId"`
+	SeatCode   string `json:"seatCode"`
+	RedisOwner string `json:"redisOwner,omitempty"`
+	MongoState string `json:"mongoState,omitempty"`
+	MongoOwner string `json:"mongoOwner,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	Duration   string    `json:"duration"`
+	Showtimes  int       `json:"showtimes"`
+	RedisLocks int       `json:"redisLocks"`
+	// InGrace counts drift that was left alone because it may belong to a
+	// request still in flight.
+	InGrace int      `json:"inGrace"`
+	Repairs []Repair `json:"repairs"`
+}
+
+// LockReconciler periodically compares Redis seat locks with LOCKED
+// seat_reservations and repairs any drift between them. Anything younger
+// than Grace is skipped: every write path touches both stores within
+// milliseconds, so only drift that outlives Grace is left by a crash.
+type LockReconciler struct {
+	Mongo    *services.MongoService
+	Redis    *services.RedisService
+	Hub      *wsHub.Hub
+	Interval time.Duration
+	Grace    time.Duration
+	// LockTTL is the TTL new locks are created with. A Redis lock whose
+	// remaining TTL is within Grace of it was set too recently to judge.
+	LockTTL time.Duration
+
+	// OnSeatsReleased is called once per showtime in which stale locks
+	// were released.
+	OnSeatsReleased func(showtimeID primitive.ObjectID)
+
+	mu sync.Mutex
+}
+
+func (r *LockReconciler) Start() {
+	go func() {
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		log.Printf("Lock reconciler started (interval: %v, grace: %v)", r.Interval, r.Grace)
+		for range ticker.C {
+			report, err := r.Run(context.Background())
+			if err != nil {
+				log.Printf("Reconciler: %v", err)
+				continue
+			}
+			if len(report.Repairs) > 0 {
+				log.Printf("Reconciler: repaired %d seat lock(s)", len(report.Repairs))
+			}
+		}
+	}()
+}
+
+// Run performs one reconciliation pass. Concurrent calls are serialised.
+func (r *LockReconciler) Run(ctx context.Context) (*ReconcileReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &ReconcileReport{StartedAt: time.Now(), Repairs: []Repair{}}
+	reconcilerMetrics.Add("runs", 1)
+	defer func() {
+		report.Duration = time.Since(report.StartedAt).String()
+		reconcilerMetrics.Set("last_run_unix", expvarInt(report.StartedAt.Unix()))
+	}()
+
+	redisLocks, err := r.Redis.ScanSeatLocks(ctx)
+	if err != nil {
+		reconcilerMetrics.Add("errors", 1)
+		return nil, err
+	}
+	report.RedisLocks = len(redisLocks)
+
+	// Group both stores by showtime
+	byShowtime := make(map[primitive.ObjectID]map[string]services.SeatLock)
+	for _, lock := range redisLocks {
+		showtimeID, err := primitive.ObjectIDFromHex(lock.ShowtimeID)
+		if err != nil {
+			continue
+		}
+		if byShowtime[showtimeID] == nil {
+			byShowtime[showtimeID] = make(map[string]services.SeatLock)
+		}
+		byShowtime[showtimeID][lock.SeatCode] = lock
+	}
+	lockedShowtimes, err := r.Mongo.Collection("seat_reservations").Distinct(ctx, "showtime_id", bson.M{"state": models.SeatStateLocked})
+	if err != nil {
+		reconcilerMetrics.Add("errors", 1)
+		return nil, err
+	}
+	for _, v := range lockedShowtimes {
+		if id, ok := v.(primitive.ObjectID); ok && byShowtime[id] == nil {
+			byShowtime[id] = map[string]services.SeatLock{}
+		}
+	}
+	report.Showtimes = len(byShowtime)
+
+	for showtimeID, locks := range byShowtime {
+		if err := r.reconcileShowtime(ctx, showtimeID, locks, report); err != nil {
+			reconcilerMetrics.Add("errors", 1)
+			log.Printf("Reconciler: showtime %s: %v", showtimeID.Hex(), err)
+		}
+	}
+
+	reconcilerMetrics.Add("in_grace", int64(report.InGrace))
+	return report, nil
+}
+
+func (r *LockReconciler) reconcileShowtime(ctx context.Context, showtimeID primitive.ObjectID, locks map[string]services.SeatLock, report *ReconcileReport) error {
+	seatCodes := make([]string, 0, len(locks))
+	for code := range locks {
+		seatCodes = append(seatCodes, code)
+	}
+
+	cursor, err := r.Mongo.Collection("seat_reservations").Find(ctx, bson.M{
+		"showtime_id": showtimeID,
+		"$or": []bson.M{
+			{"state": models.SeatStateLocked},
+			{"seat_code": bson.M{"$in": seatCodes}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	var seats []models.SeatReservation
+	if err := cursor.All(ctx, &seats); err != nil {
+		return err
+	}
+
+	holding, err := r.holdingBookings(ctx, seats)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	settled := func(seat models.SeatReservation) bool {
+		return now.Sub(seat.UpdatedAt) >= r.Grace
+	}
+	// A key whose remaining TTL is close to LockTTL was set moments ago
+	lockSettled := func(lock services.SeatLock) bool {
+		return lock.TTL < 0 || lock.TTL <= r.LockTTL-r.Grace
+	}
+
+	released := false
+	for _, seat := range seats {
+		lock, hasLock := locks[seat.SeatCode]
+		delete(locks, seat.SeatCode)
+
+		mongoOwner := ""
+		if seat.LockedByUserID != nil {
+			mongoOwner = seat.LockedByUserID.Hex()
+		}
+		repair := Repair{
+			ShowtimeID: showtimeID.Hex(),
+			SeatCode:   seat.SeatCode,
+			RedisOwner: lock.Owner,
+			MongoState: seat.State,
+			MongoOwner: mongoOwner,
+		}
+
+		if seat.State != models.SeatStateLocked {
+			if !hasLock {
+				continue
+			}
+			if !settled(seat) || !lockSettled(lock) {
+				report.InGrace++
+				continue
+			}
+			repair.Kind = DriftOrphanRedisLock
+			repair.Error = errString(r.Redis.ReleaseLock(ctx, lock.ShowtimeID, lock.SeatCode, lock.Owner))
+			r.record(ctx, showtimeID, repair, report)
+			continue
+		}
+
+		// Expired locks belong to the TimeoutWorker
+		if seat.LockExpiresAt == nil || !seat.LockExpiresAt.After(now) {
+			continue
+		}
+
+		if seat.BookingID == nil || !holding[*seat.BookingID] {
+			if !settled(seat) {
+				report.InGrace++
+				continue
+			}
+			repair.Kind = DriftStaleMongoLock
+			if err := r.releaseStaleSeat(ctx, seat); err != nil {
+				repair.Error = err.Error()
+			} else {
+				released = true
+				if hasLock {
+					r.Redis.ReleaseLock(ctx, lock.ShowtimeID, lock.SeatCode, lock.Owner)
+				}
+			}
+			r.record(ctx, showtimeID, repair, report)
+			continue
+		}
+
+		remaining := seat.LockExpiresAt.Sub(now)
+		switch {
+		case !hasLock:
+			if !settled(seat) {
+				report.InGrace++
+				continue
+			}
+			repair.Kind = DriftMissingRedisLock
+			ok, err := r.Redis.AcquireLock(ctx, showtimeID.Hex(), seat.SeatCode, mongoOwner, remaining)
+			if err == nil && !ok {
+				// Someone took the key in the meantime; the next pass sees it
+				continue
+			}
+			repair.Error = errString(err)
+			r.record(ctx, showtimeID, repair, report)
+		case lock.Owner != mongoOwner:
+			if !settled(seat) || !lockSettled(lock) {
+				report.InGrace++
+				continue
+			}
+			repair.Kind = DriftOwnerMismatch
+			_, err := r.Redis.ReplaceLockOwner(ctx, showtimeID.Hex(), seat.SeatCode, lock.Owner, mongoOwner, remaining)
+			repair.Error = errString(err)
+			r.record(ctx, showtimeID, repair, report)
+		}
+	}
+
+	// Redis locks on seats that have no reservation document at all
+	for _, lock := range locks {
+		if !lockSettled(lock) {
+			report.InGrace++
+			continue
+		}
+		repair := Repair{
+			Kind:       DriftOrphanRedisLock,
+			ShowtimeID: showtimeID.Hex(),
+			SeatCode:   lock.SeatCode,
+			RedisOwner: lock.Owner,
+		}
+		repair.Error = errString(r.Redis.ReleaseLock(ctx, lock.ShowtimeID, lock.SeatCode, lock.Owner))
+		r.record(ctx, showtimeID, repair, report)
+	}
+
+	if released && r.OnSeatsReleased != nil {
+		r.OnSeatsReleased(showtimeID)
+	}
+	return nil
+}
+
+// holdingBookings reports, for every booking referenced by a LOCKED seat,
+// whether the booking still holds seats: it is LOCKED, or BOOKED with an
+// exchange waiting on its payment. Missing bookings are absent from the map.
+func (r *LockReconciler) holdingBookings(ctx context.Context, seats []models.SeatReservation) (map[primitive.ObjectID]bool, error) {
+	var ids []primitive.ObjectID
+	for _, seat := range seats {
+		if seat.State == models.SeatStateLocked && seat.BookingID != nil {
+			ids = append(ids, *seat.BookingID)
+		}
+	}
+	holding := make(map[primitive.ObjectID]bool, len(ids))
+	if len(ids) == 0 {
+		return holding, nil
+	}
+
+	cursor, err := r.Mongo.Collection("bookings").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	for _, b := range bookings {
+		holding[b.ID] = b.Status == models.BookingStatusLocked || b.Exchange != nil
+	}
+	return holding, nil
+}
+
+func (r *LockReconciler) releaseStaleSeat(ctx context.Context, seat models.SeatReservation) error {
+	result, err := r.Mongo.Collection("seat_reservations").UpdateOne(ctx,
+		bson.M{"_id": seat.ID, "state": models.SeatStateLocked, "booking_id": seat.BookingID},
+		bson.M{"$set": bson.M{
+			"state":             models.SeatStateAvailable,
+			"locked_by_user_id": nil,
+			"lock_expires_at":   nil,
+			"booking_id":        nil,
+			"updated_at":        time.Now(),
+		}},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		return err
+	}
+
+	r.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+		ShowtimeID: seat.ShowtimeID,
+		Seats:      []string{seat.SeatCode},
+		From:       models.SeatStateLocked,
+		To:         models.SeatStateAvailable,
+		Reason:     models.SeatChangeReconcile,
+		BookingID:  seat.BookingID,
+	})
+
+	msg, _ := json.Marshal(map[string]interface{}{
+		"type":     "SEAT_RELEASED",
+		"seatCode": seat.SeatCode,
+	})
+	r.Hub.BroadcastToRoom(seat.ShowtimeID.Hex(), msg)
+	return nil
+}
+
+// record counts a repair and writes a LOCK_RECONCILED audit log for it.
+func (r *LockReconciler) record(ctx context.Context, showtimeID primitive.ObjectID, repair Repair, report *ReconcileReport) {
+	report.Repairs = append(report.Repairs, repair)
+	reconcilerMetrics.Add("repairs."+repair.Kind, 1)
+	if repair.Error != "" {
+		reconcilerMetrics.Add("errors", 1)
+	}
+
+	auditLog := models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		EventType:  "LOCK_RECONCILED",
+		ShowtimeID: &showtimeID,
+		SeatCode:   repair.SeatCode,
+		Payload: map[string]interface{}{
+			"kind":        repair.Kind,
+			"redis_owner": repair.RedisOwner,
+			"mongo_state": repair.MongoState,
+			"mongo_owner": repair.MongoOwner,
+		},
+		CreatedAt: time.Now(),
+	}
+	if repair.Error != "" {
+		auditLog.Payload["error"] = repair.Error
+	}
+	r.Mongo.Collection("audit_logs").InsertOne(ctx, auditLog)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func expvarInt(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
@@ -19,6 +19,14 @@ type TimeoutWorker struct {
 	Redis    *services.RedisService
 	Hub      *wsHub.Hub
 	Interval time.Duration
+
+	// OnSeatsReleased is called once per showtime after expired locks have
+	// been released.
+	OnSeatsReleased func(showtimeID primitive.ObjectID)
+
+	// VoidPayments settles payments left behind by expired and cancelled
+	// bookings. It reports how many it handled.
+	VoidPayments func(ctx context.Context) (int, error)
 }
 
 func NewTimeoutWorker(mongo *services.MongoService, redis *services.RedisService, hub *wsHub.Hub, interval time.Duration) *TimeoutWorker {
@@ -38,10 +46,24 @@ func (w *TimeoutWorker) Start() {
 		log.Printf("Timeout worker started (interval: %v)", w.Interval)
 		for range ticker.C {
 			w.cleanup()
+			w.voidPayments()
 		}
 	}()
 }
 
+func (w *TimeoutWorker) voidPayments() {
+	if w.VoidPayments == nil {
+		return
+	}
+	n, err := w.VoidPayments(context.Background())
+	if err != nil {
+		log.Printf("Worker: failed to void payments: %v", err)
+	}
+	if n > 0 {
+		log.Printf("Worker: voided %d abandoned payments", n)
+	}
+}
+
 func (w *TimeoutWorker) cleanup() {
 	ctx := context.Background()
 
@@ -72,13 +94,15 @@ func (w *TimeoutWorker) cleanup() {
 
 	// Group by booking for batch processing
 	bookingSeats := make(map[primitive.ObjectID][]models.SeatReservation)
+	showtimes := make(map[primitive.ObjectID]bool)
 	for _, seat := range expiredSeats {
+		showtimes[seat.ShowtimeID] = true
 		if seat.BookingID != nil {
 			bookingSeats[*seat.BookingID] = append(bookingSeats[*seat.BookingID], seat)
 		}
 
 		// Release seat
-		w.Mongo.Collection("seat_reservations").UpdateOne(ctx,
+		result, err := w.Mongo.Collection("seat_reservations").UpdateOne(ctx,
 			bson.M{"_id": seat.ID, "state": models.SeatStateLocked},
 			bson.M{"$set": bson.M{
 				"state":             models.SeatStateAvailable,
@@ -89,6 +113,17 @@ func (w *TimeoutWorker) cleanup() {
 			}},
 		)
 
+		if err == nil && result.ModifiedCount > 0 {
+			w.Mongo.RecordSeatHistory(ctx, services.SeatChange{
+				ShowtimeID: seat.ShowtimeID,
+				Seats:      []string{seat.SeatCode},
+				From:       models.SeatStateLocked,
+				To:         models.SeatStateAvailable,
+				Reason:     models.SeatChangeExpire,
+				BookingID:  seat.BookingID,
+			})
+		}
+
 		// Force release Redis lock
 		w.Redis.ForceReleaseLock(ctx, seat.ShowtimeID.Hex(), seat.SeatCode)
 
@@ -136,4 +171,10 @@ func (w *TimeoutWorker) cleanup() {
 		}
 		w.Mongo.Collection("audit_logs").InsertOne(ctx, auditLog)
 	}
+
+	if w.OnSeatsReleased != nil {
+		for showtimeID := range showtimes {
+			w.OnSeatsReleased(showtimeID)
+		}
+	}
 }